@@ -65,9 +65,12 @@ func Run(name, usage, version string) {
 		getRunCommand(),
 		getTestCommand(),
 		getOpenAPICommand(),
+		getProtoCommand(),
 		getMarkdownCommand(),
 		getDotCommand(),
 		getGraceServerCommand(),
+		getPolicyCommand(),
+		getSyncCommand(),
 	}
 	app.Run(os.Args)
 }
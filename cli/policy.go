@@ -0,0 +1,101 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwan/gohan/server"
+	"github.com/cloudwan/gohan/util"
+	"github.com/codegangsta/cli"
+)
+
+func getPolicyCommand() cli.Command {
+	return cli.Command{
+		Name:  "policy",
+		Usage: "Inspect policies",
+		Subcommands: []cli.Command{
+			getPolicyExplainCommand(),
+		},
+	}
+}
+
+func getPolicyExplainCommand() cli.Command {
+	return cli.Command{
+		Name:  "explain",
+		Usage: "Explain policy decision",
+		Description: `
+Explain which policies match the request and why, the final decision,
+and tenant and property filters applied by the deciding policy.
+
+Policies are loaded from schema files listed in the configuration.
+Use POST /gohan/v0.1/policies/explain to explain policies of a running server.`,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file, c", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.StringFlag{Name: "action, a", Value: "read", Usage: "Action (create, read, update, delete or custom action)"},
+			cli.StringFlag{Name: "path, p", Value: "", Usage: "Request path"},
+			cli.StringFlag{Name: "principal", Value: "", Usage: "Principal (role name)"},
+			cli.StringSliceFlag{Name: "role, r", Usage: "Role name, can be repeated"},
+			cli.StringFlag{Name: "tenant-id", Value: "", Usage: "Tenant ID"},
+			cli.StringFlag{Name: "tenant-name", Value: "", Usage: "Tenant name"},
+			cli.StringFlag{Name: "domain-id", Value: "", Usage: "Domain ID"},
+			cli.StringFlag{Name: "domain-name", Value: "", Usage: "Domain name"},
+			cli.StringFlag{Name: "resource", Value: "", Usage: "Resource body in JSON checked against policy conditions (optional)"},
+		},
+		Action: func(c *cli.Context) {
+			request := &server.PolicyExplainRequest{
+				Action:     c.String("action"),
+				Path:       c.String("path"),
+				Principal:  c.String("principal"),
+				Roles:      c.StringSlice("role"),
+				TenantID:   c.String("tenant-id"),
+				TenantName: c.String("tenant-name"),
+				DomainID:   c.String("domain-id"),
+				DomainName: c.String("domain-name"),
+			}
+			if request.Principal == "" && len(request.Roles) == 0 {
+				util.ExitFatal("Need to provide principal or roles")
+			}
+			if resource := c.String("resource"); resource != "" {
+				if err := json.Unmarshal([]byte(resource), &request.Resource); err != nil {
+					util.ExitFatal("Invalid resource:", err)
+				}
+			}
+
+			configFile := c.String("config-file")
+			if err := util.GetConfig().ReadConfig(configFile); err != nil {
+				util.ExitFatal(err)
+			}
+			pwd, _ := os.Getwd()
+			os.Chdir(filepath.Dir(configFile))
+			err := loadSchemasFromConfig()
+			os.Chdir(pwd)
+			if err != nil {
+				util.ExitFatal(err)
+			}
+
+			explanation, err := request.Explain(nil)
+			if err != nil {
+				util.ExitFatal(err)
+			}
+			data, _ := json.MarshalIndent(explanation, "", "    ")
+			fmt.Println(string(data))
+		},
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/server"
+	"github.com/cloudwan/gohan/util"
+	"github.com/codegangsta/cli"
+)
+
+func getSyncCommand() cli.Command {
+	return cli.Command{
+		Name:  "sync",
+		Usage: "Manage sync backend",
+		Subcommands: []cli.Command{
+			getSyncReconcileCommand(),
+		},
+	}
+}
+
+func getSyncReconcileCommand() cli.Command {
+	return cli.Command{
+		Name:  "reconcile",
+		Usage: "Reconcile sync backend with database",
+		Description: `
+Write resource keys missing in the sync backend or differing from the database,
+and delete keys of resources which don't exist in the database.
+Keys are computed the same way as the sync process does, honouring
+sync_key_template, sync_plain and sync_property of schemas.
+
+Use --dry-run to only report differences.
+Use POST /gohan/v0.1/sync/reconcile to reconcile from a running server.`,
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file, c", Value: defaultConfigFile, Usage: "Server config File"},
+			cli.BoolFlag{Name: "dry-run", Usage: "Report differences without changing sync backend"},
+		},
+		Action: func(c *cli.Context) {
+			configFile := c.String("config-file")
+			config := util.GetConfig()
+			if err := config.ReadConfig(configFile); err != nil {
+				util.ExitFatal(err)
+			}
+			os.Chdir(filepath.Dir(configFile))
+			if err := loadSchemasFromConfig(); err != nil {
+				util.ExitFatal(err)
+			}
+
+			dataStore, err := db.ConnectDB(
+				config.GetString("database/type", "sqlite3"),
+				config.GetString("database/connection", ""),
+				db.DefaultMaxOpenConn)
+			if err != nil {
+				util.ExitFatal(err)
+			}
+			backend, err := server.ConnectSync(config)
+			if err != nil {
+				util.ExitFatal(err)
+			}
+			if backend == nil {
+				util.ExitFatal("No sync backend configured")
+			}
+
+			report, err := server.ReconcileSync(dataStore, backend, c.Bool("dry-run"))
+			if err != nil {
+				util.ExitFatal(err)
+			}
+			data, _ := json.MarshalIndent(report, "", "    ")
+			fmt.Println(string(data))
+		},
+	}
+}
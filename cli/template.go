@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 	"regexp"
 
 	"github.com/cloudwan/gohan/schema"
@@ -16,85 +17,11 @@ import (
 	"strings"
 )
 
-func deleteGohanExtendedProperties(node map[string]interface{}) {
-	extendedProperties := [...]string{"unique", "permission", "relation",
-		"relation_property", "view", "detail_view", "propertiesOrder",
-		"on_delete_cascade", "indexed", "relationColumn"}
-
-	for _, extendedProperty := range extendedProperties {
-		delete(node, extendedProperty)
-	}
-}
-
-func fixEnumDefaultValue(node map[string]interface{}) {
-	if defaultValue, ok := node["default"]; ok {
-		if enums, ok := node["enum"]; ok {
-			if defaultValueStr, ok := defaultValue.(string); ok {
-				enumsArr := util.MaybeStringList(enums)
-				if !util.ContainsString(enumsArr, defaultValueStr) {
-					delete(node, "default")
-				}
-			}
-		}
-	}
-}
-
-func removeEmptyRequiredList(node map[string]interface{}) {
-	const requiredProperty = "required"
-
-	if required, ok := node[requiredProperty]; ok {
-		switch list := required.(type) {
-		case []string:
-			if len(list) == 0 {
-				delete(node, requiredProperty)
-			}
-		case []interface{}:
-			if len(list) == 0 {
-				delete(node, requiredProperty)
-			}
-		}
-	}
-}
-
-func removeNotSupportedFormat(node map[string]interface{}) {
-	const formatProperty string = "format"
-	var allowedFormats = []string{"uri", "uuid", "email", "int32", "int64", "float", "double",
-		"byte", "binary", "date", "date-time", "password"}
-
-	if format, ok := node[formatProperty]; ok {
-		if format, ok := format.(string); ok {
-			if !util.ContainsString(allowedFormats, format) {
-				delete(node, formatProperty)
-			}
-		}
-	}
-}
-
-func fixPropertyTree(node map[string]interface{}) {
-
-	deleteGohanExtendedProperties(node)
-	fixEnumDefaultValue(node)
-	removeEmptyRequiredList(node)
-	removeNotSupportedFormat(node)
-
-	for _, value := range node {
-		switch childs := value.(type) {
-		case map[string]interface{}:
-			fixPropertyTree(childs)
-		case map[string]map[string]interface{}:
-			for _, value := range childs {
-				fixPropertyTree(value)
-			}
-		}
-	}
-
-}
-
 func toSwagger(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
 	i := in.Interface()
 	m := i.(map[string]interface{})
 
-	fixPropertyTree(m)
+	schema.FixOpenAPIPropertyTree(m)
 
 	data, _ := json.MarshalIndent(i, param.String(), "    ")
 	return pongo2.AsValue(string(data)), nil
@@ -117,6 +44,16 @@ func init() {
 	pongo2.RegisterFilter("swagger_has_id_param", hasIdParam)
 }
 
+func loadSchemasFromConfig() error {
+	manager := schema.GetManager()
+	config := util.GetConfig()
+	schemaFiles := config.GetStringList("schemas", nil)
+	if schemaFiles == nil {
+		return fmt.Errorf("No schema specified in configuraion")
+	}
+	return manager.LoadSchemasFromFiles(schemaFiles...)
+}
+
 func doTemplate(c *cli.Context) {
 	template := c.String("template")
 	manager := schema.GetManager()
@@ -134,22 +71,13 @@ func doTemplate(c *cli.Context) {
 	}
 	pwd, _ := os.Getwd()
 	os.Chdir(path.Dir(configFile))
-	schemaFiles := config.GetStringList("schemas", nil)
-	if schemaFiles == nil {
-		util.ExitFatal("No schema specified in configuraion")
-	} else {
-		err = manager.LoadSchemasFromFiles(schemaFiles...)
-		if err != nil {
-			util.ExitFatal(err)
-			return
-		}
-	}
-	schemas := manager.OrderedSchemas()
-
+	err = loadSchemasFromConfig()
 	if err != nil {
 		util.ExitFatal(err)
 		return
 	}
+	schemas := manager.OrderedSchemas()
+
 	tpl, err := pongo2.FromString(string(templateCode))
 	if err != nil {
 		util.ExitFatal(err)
@@ -171,6 +99,46 @@ func doTemplate(c *cli.Context) {
 	fmt.Println(output)
 }
 
+func doProto(c *cli.Context) {
+	manager := schema.GetManager()
+	configFile := c.String("config-file")
+	config := util.GetConfig()
+	err := config.ReadConfig(configFile)
+	if err != nil {
+		util.ExitFatal(err)
+		return
+	}
+	outputDir, err := filepath.Abs(c.String("output-dir"))
+	if err != nil {
+		util.ExitFatal(err)
+		return
+	}
+	pwd, _ := os.Getwd()
+	os.Chdir(path.Dir(configFile))
+	err = loadSchemasFromConfig()
+	if err != nil {
+		util.ExitFatal(err)
+		return
+	}
+	os.Chdir(pwd)
+	schemasPolicy, schemasCRUDPolicy := filterSchemasForPolicy(c.String("policy"), manager.Policies(), manager.OrderedSchemas())
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		util.ExitFatal(err)
+		return
+	}
+	for _, s := range append(schemasPolicy, schemasCRUDPolicy...) {
+		if s.IsAbstract() {
+			continue
+		}
+		protoFile := filepath.Join(outputDir, s.ID+".proto")
+		if err := ioutil.WriteFile(protoFile, []byte(s.ProtoService().Proto()), 0644); err != nil {
+			util.ExitFatal(err)
+			return
+		}
+		fmt.Println(protoFile)
+	}
+}
+
 func saveAllResources(schemas []*schema.Schema, schemasCRUD []*schema.Schema, tpl *pongo2.Template) {
 	for _, resource := range getAllResourcesFromSchemas(schemas, schemasCRUD) {
 		resourceSchemas := filerSchemasByResource(resource, schemas)
@@ -313,6 +281,21 @@ func getOpenAPICommand() cli.Command {
 	}
 }
 
+func getProtoCommand() cli.Command {
+	return cli.Command{
+		Name:        "proto",
+		ShortName:   "proto",
+		Usage:       "Convert gohan schema to gRPC protocol buffers definitions",
+		Description: "Convert gohan schema to gRPC protocol buffers definitions, one .proto file per schema",
+		Flags: []cli.Flag{
+			cli.StringFlag{Name: "config-file", Value: "gohan.yaml", Usage: "Server config File"},
+			cli.StringFlag{Name: "output-dir, o", Value: ".", Usage: "Output directory"},
+			cli.StringFlag{Name: "policy", Value: "admin", Usage: "Policy"},
+		},
+		Action: doProto,
+	}
+}
+
 func getMarkdownCommand() cli.Command {
 	return cli.Command{
 		Name:        "markdown",
@@ -0,0 +1,500 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/rackspace/gophercloud"
+)
+
+//JWTConfig describes how tokens are verified and how claims are mapped
+//Claims are JSON pointer like paths separated by dots, e.g. realm_access.roles
+//Issuer defaults to the issuer of the discovery document. Audience is required
+//unless SkipAudienceCheck is set.
+type JWTConfig struct {
+	JWKSFile          string
+	DiscoveryURL      string
+	Issuer            string
+	Audience          string
+	SkipAudienceCheck bool
+	TenantIDClaim     string
+	TenantNameClaim   string
+	RolesClaim        string
+	Leeway            time.Duration
+	ServiceTenantID   string
+	ServiceTenantName string
+	ServiceRoles      []string
+}
+
+const (
+	//jwksReloadInterval is the minimum time between reloads of keys caused by unknown key IDs
+	jwksReloadInterval = time.Minute
+	//unknownKeyIDTTL is how long key IDs which weren't found after a reload don't cause reloads
+	unknownKeyIDTTL = 10 * time.Minute
+	//maxUnknownKeyIDs limits the number of remembered unknown key IDs
+	maxUnknownKeyIDs = 1024
+)
+
+//JWTIdentity verifies JSON Web Tokens issued by an OpenID Connect provider
+type JWTIdentity struct {
+	config  JWTConfig
+	client  *http.Client
+	now     func() time.Time
+	mu      sync.RWMutex
+	keys    map[string]crypto.PublicKey
+	tenants map[string]string
+
+	reloadMu    sync.Mutex
+	lastReload  time.Time
+	unknownKeys map[string]time.Time
+}
+
+type jwtHeader struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jsonWebKey struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	Curve   string `json:"crv"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+//NewJWTIdentity is a constructor for JWTIdentity middleware
+//Keys are loaded from the JWKS file or from jwks_uri of the OpenID Connect discovery document
+func NewJWTIdentity(config JWTConfig) (*JWTIdentity, error) {
+	if config.JWKSFile == "" && config.DiscoveryURL == "" {
+		return nil, fmt.Errorf("JWKS file or discovery URL is required")
+	}
+	if config.Audience == "" && !config.SkipAudienceCheck {
+		return nil, fmt.Errorf("Audience is required unless the audience check is skipped")
+	}
+	if config.TenantIDClaim == "" {
+		config.TenantIDClaim = "tenant_id"
+	}
+	if config.TenantNameClaim == "" {
+		config.TenantNameClaim = "tenant_name"
+	}
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	identity := &JWTIdentity{
+		config:  config,
+		client:  &http.Client{Timeout: 10 * time.Second},
+		now:     time.Now,
+		tenants: map[string]string{},
+
+		unknownKeys: map[string]time.Time{},
+	}
+	if identity.config.Issuer == "" && config.JWKSFile == "" {
+		discovery, err := identity.fetchDiscovery()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load discovery document: %s", err)
+		}
+		identity.config.Issuer = discovery.Issuer
+	}
+	if identity.config.Issuer == "" {
+		return nil, fmt.Errorf("Issuer is required when it isn't in the discovery document")
+	}
+	if err := identity.loadKeys(); err != nil {
+		return nil, err
+	}
+	return identity, nil
+}
+
+// VerifyToken verifies the token signature and claims and maps claims to authorization
+func (identity *JWTIdentity) VerifyToken(token string) (schema.Authorization, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Invalid token format")
+	}
+	var header jwtHeader
+	if err := decodeJWTSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("Invalid token header: %s", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid token signature: %s", err)
+	}
+	if err := identity.verifySignature(header, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err := decodeJWTSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("Invalid token claims: %s", err)
+	}
+	if err := identity.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	tenantID, _ := jwtClaim(claims, identity.config.TenantIDClaim).(string)
+	if tenantID == "" {
+		return nil, fmt.Errorf("Token has no %s claim", identity.config.TenantIDClaim)
+	}
+	tenantName, _ := jwtClaim(claims, identity.config.TenantNameClaim).(string)
+	roles := jwtRoles(jwtClaim(claims, identity.config.RolesClaim))
+
+	if tenantName != "" {
+		identity.mu.Lock()
+		identity.tenants[tenantID] = tenantName
+		identity.mu.Unlock()
+	}
+	return schema.NewAuthorizationWithClaims(tenantID, tenantName, token, roles, nil, claims), nil
+}
+
+// GetTenantID maps the given tenant name to the tenant's ID using tenants seen in verified tokens
+func (identity *JWTIdentity) GetTenantID(tenantName string) (string, error) {
+	identity.mu.RLock()
+	defer identity.mu.RUnlock()
+	for id, name := range identity.tenants {
+		if name == tenantName {
+			return id, nil
+		}
+	}
+	return "", nil
+}
+
+// GetTenantName maps the given tenant ID to the tenant's name using tenants seen in verified tokens
+func (identity *JWTIdentity) GetTenantName(tenantID string) (string, error) {
+	identity.mu.RLock()
+	defer identity.mu.RUnlock()
+	return identity.tenants[tenantID], nil
+}
+
+// GetServiceAuthorization returns the authorization configured for the service
+func (identity *JWTIdentity) GetServiceAuthorization() (schema.Authorization, error) {
+	return schema.NewAuthorization(identity.config.ServiceTenantID, identity.config.ServiceTenantName,
+		"", identity.config.ServiceRoles, nil), nil
+}
+
+// GetClient returns always nil
+func (identity *JWTIdentity) GetClient() *gophercloud.ServiceClient {
+	return nil
+}
+
+func (identity *JWTIdentity) verifySignature(header jwtHeader, signed string, signature []byte) error {
+	if len(header.Algorithm) != 5 {
+		return fmt.Errorf("Unsupported token algorithm: %s", header.Algorithm)
+	}
+	family := header.Algorithm[:2]
+	hash, ok := jwtHashes[header.Algorithm[2:]]
+	if !ok || (family != "RS" && family != "ES") {
+		return fmt.Errorf("Unsupported token algorithm: %s", header.Algorithm)
+	}
+	keys := identity.candidateKeys(header.KeyID)
+	if len(keys) == 0 && header.KeyID != "" && identity.config.DiscoveryURL != "" {
+		if err := identity.reloadKeys(header.KeyID); err != nil {
+			return err
+		}
+		keys = identity.candidateKeys(header.KeyID)
+	}
+	if len(keys) == 0 {
+		return fmt.Errorf("No key found for token")
+	}
+	hasher := hash.New()
+	hasher.Write([]byte(signed))
+	digest := hasher.Sum(nil)
+	for _, key := range keys {
+		switch key := key.(type) {
+		case *rsa.PublicKey:
+			if family == "RS" && rsa.VerifyPKCS1v15(key, hash, digest, signature) == nil {
+				return nil
+			}
+		case *ecdsa.PublicKey:
+			size := (key.Curve.Params().BitSize + 7) / 8
+			if family != "ES" || len(signature) != 2*size {
+				continue
+			}
+			r := new(big.Int).SetBytes(signature[:size])
+			s := new(big.Int).SetBytes(signature[size:])
+			if ecdsa.Verify(key, digest, r, s) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("Invalid token signature")
+}
+
+//reloadKeys reloads keys from the provider when a token has an unknown key ID
+//Keys are reloaded at most once per jwksReloadInterval, and key IDs which weren't found
+//after a reload are remembered, so tokens with made up key IDs don't hit the provider.
+func (identity *JWTIdentity) reloadKeys(keyID string) error {
+	identity.reloadMu.Lock()
+	defer identity.reloadMu.Unlock()
+	if len(identity.candidateKeys(keyID)) > 0 {
+		return nil
+	}
+	now := identity.now()
+	if seen, ok := identity.unknownKeys[keyID]; ok && now.Sub(seen) < unknownKeyIDTTL {
+		return nil
+	}
+	if !identity.lastReload.IsZero() && now.Sub(identity.lastReload) < jwksReloadInterval {
+		return nil
+	}
+	identity.lastReload = now
+	if err := identity.loadKeys(); err != nil {
+		return err
+	}
+	if len(identity.candidateKeys(keyID)) == 0 {
+		identity.rememberUnknownKey(keyID, now)
+	}
+	return nil
+}
+
+//rememberUnknownKey keeps a key ID which wasn't found after a reload
+func (identity *JWTIdentity) rememberUnknownKey(keyID string, now time.Time) {
+	if len(identity.unknownKeys) >= maxUnknownKeyIDs {
+		for unknown, seen := range identity.unknownKeys {
+			if now.Sub(seen) >= unknownKeyIDTTL {
+				delete(identity.unknownKeys, unknown)
+			}
+		}
+	}
+	if len(identity.unknownKeys) >= maxUnknownKeyIDs {
+		identity.unknownKeys = map[string]time.Time{}
+	}
+	identity.unknownKeys[keyID] = now
+}
+
+func (identity *JWTIdentity) candidateKeys(keyID string) []crypto.PublicKey {
+	identity.mu.RLock()
+	defer identity.mu.RUnlock()
+	if keyID != "" {
+		if key, ok := identity.keys[keyID]; ok {
+			return []crypto.PublicKey{key}
+		}
+		return nil
+	}
+	keys := []crypto.PublicKey{}
+	for _, key := range identity.keys {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (identity *JWTIdentity) validateClaims(claims map[string]interface{}) error {
+	now := identity.now()
+	leeway := identity.config.Leeway
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return fmt.Errorf("Token has no exp claim")
+	}
+	if now.After(time.Unix(int64(exp), 0).Add(leeway)) {
+		return fmt.Errorf("Token expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Before(time.Unix(int64(nbf), 0).Add(-leeway)) {
+		return fmt.Errorf("Token not valid yet")
+	}
+	if claims["iss"] != identity.config.Issuer {
+		return fmt.Errorf("Invalid token issuer: %v", claims["iss"])
+	}
+	if identity.config.SkipAudienceCheck {
+		return nil
+	}
+	for _, audience := range jwtRoles(claims["aud"]) {
+		if audience == identity.config.Audience {
+			return nil
+		}
+	}
+	return fmt.Errorf("Invalid token audience: %v", claims["aud"])
+}
+
+func (identity *JWTIdentity) loadKeys() error {
+	var data []byte
+	var err error
+	if identity.config.JWKSFile != "" {
+		data, err = ioutil.ReadFile(identity.config.JWKSFile)
+	} else {
+		data, err = identity.fetchJWKS()
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to load JWKS: %s", err)
+	}
+	var keySet jsonWebKeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return fmt.Errorf("Failed to parse JWKS: %s", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for i, jwk := range keySet.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			return fmt.Errorf("Failed to parse key %d: %s", i, err)
+		}
+		keyID := jwk.KeyID
+		if keyID == "" {
+			keyID = fmt.Sprintf("#%d", i)
+		}
+		keys[keyID] = key
+	}
+	identity.mu.Lock()
+	identity.keys = keys
+	identity.mu.Unlock()
+	return nil
+}
+
+//discoveryDocument is the part of OpenID Connect discovery document used to verify tokens
+type discoveryDocument struct {
+	Issuer  string `json:"issuer"`
+	JWKSURI string `json:"jwks_uri"`
+}
+
+func (identity *JWTIdentity) fetchDiscovery() (*discoveryDocument, error) {
+	data, err := identity.get(identity.config.DiscoveryURL)
+	if err != nil {
+		return nil, err
+	}
+	document := &discoveryDocument{}
+	if err := json.Unmarshal(data, document); err != nil {
+		return nil, err
+	}
+	return document, nil
+}
+
+func (identity *JWTIdentity) fetchJWKS() ([]byte, error) {
+	document, err := identity.fetchDiscovery()
+	if err != nil {
+		return nil, err
+	}
+	if document.JWKSURI == "" {
+		return nil, fmt.Errorf("no jwks_uri in discovery document")
+	}
+	return identity.get(document.JWKSURI)
+}
+
+func (identity *JWTIdentity) get(url string) ([]byte, error) {
+	response, err := identity.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %d", url, response.StatusCode)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"256": crypto.SHA256,
+	"384": crypto.SHA384,
+	"512": crypto.SHA512,
+}
+
+var jwtCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+func (jwk jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch jwk.KeyType {
+	case "RSA":
+		n, err := decodeJWKInt(jwk.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(jwk.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		curve, ok := jwtCurves[jwk.Curve]
+		if !ok {
+			return nil, fmt.Errorf("unsupported curve %s", jwk.Curve)
+		}
+		x, err := decodeJWKInt(jwk.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKInt(jwk.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", jwk.KeyType)
+}
+
+func decodeJWKInt(value string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+func decodeJWTSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func jwtClaim(claims map[string]interface{}, path string) interface{} {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+//jwtRoles accepts list of strings or space separated string
+func jwtRoles(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		return strings.Fields(value)
+	case []interface{}:
+		roles := []string{}
+		for _, role := range value {
+			if role, ok := role.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+		return roles
+	}
+	return []string{}
+}
@@ -0,0 +1,414 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/onsi/gomega/ghttp"
+)
+
+var _ = Describe("JWT identity", func() {
+	var (
+		rsaKey   *rsa.PrivateKey
+		ecKey    *ecdsa.PrivateKey
+		jwks     map[string]interface{}
+		jwksFile string
+		config   JWTConfig
+	)
+
+	encode := func(data []byte) string {
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	encodeJSON := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		Expect(err).ToNot(HaveOccurred())
+		return encode(data)
+	}
+
+	signRSA := func(kid string, claims map[string]interface{}) string {
+		signed := encodeJSON(map[string]string{"alg": "RS256", "kid": kid}) + "." + encodeJSON(claims)
+		digest := sha256.Sum256([]byte(signed))
+		signature, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		Expect(err).ToNot(HaveOccurred())
+		return signed + "." + encode(signature)
+	}
+
+	signEC := func(kid string, claims map[string]interface{}) string {
+		signed := encodeJSON(map[string]string{"alg": "ES256", "kid": kid}) + "." + encodeJSON(claims)
+		digest := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, ecKey, digest[:])
+		Expect(err).ToNot(HaveOccurred())
+		signature := make([]byte, 64)
+		rBytes, sBytes := r.Bytes(), s.Bytes()
+		copy(signature[32-len(rBytes):32], rBytes)
+		copy(signature[64-len(sBytes):], sBytes)
+		return signed + "." + encode(signature)
+	}
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":         "https://issuer.example.com",
+			"aud":         []string{"gohan", "other"},
+			"exp":         time.Now().Add(time.Hour).Unix(),
+			"tenant_id":   "tenant-id",
+			"tenant_name": "tenant-name",
+			"roles":       []string{"member", "viewer"},
+		}
+	}
+
+	roleNames := func(identity *JWTIdentity, token string) []string {
+		auth, err := identity.VerifyToken(token)
+		Expect(err).ToNot(HaveOccurred())
+		names := []string{}
+		for _, role := range auth.Roles() {
+			names = append(names, role.Name)
+		}
+		return names
+	}
+
+	BeforeEach(func() {
+		var err error
+		rsaKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		ecKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).ToNot(HaveOccurred())
+		jwks = map[string]interface{}{
+			"keys": []map[string]string{
+				{
+					"kty": "RSA",
+					"kid": "rsa-key",
+					"use": "sig",
+					"n":   encode(rsaKey.N.Bytes()),
+					"e":   encode(big.NewInt(int64(rsaKey.E)).Bytes()),
+				},
+				{
+					"kty": "EC",
+					"kid": "ec-key",
+					"crv": "P-256",
+					"x":   encode(ecKey.X.Bytes()),
+					"y":   encode(ecKey.Y.Bytes()),
+				},
+			},
+		}
+		file, err := ioutil.TempFile("", "jwks")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.NewEncoder(file).Encode(jwks)).To(Succeed())
+		file.Close()
+		jwksFile = file.Name()
+		config = JWTConfig{
+			JWKSFile: jwksFile,
+			Issuer:   "https://issuer.example.com",
+			Audience: "gohan",
+		}
+	})
+
+	AfterEach(func() {
+		os.Remove(jwksFile)
+	})
+
+	Describe("Creation", func() {
+		It("requires a key source", func() {
+			_, err := NewJWTIdentity(JWTConfig{})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails for a missing JWKS file", func() {
+			config.JWKSFile = jwksFile + ".missing"
+			_, err := NewJWTIdentity(config)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("requires an audience", func() {
+			config.Audience = ""
+			_, err := NewJWTIdentity(config)
+			Expect(err).To(MatchError(ContainSubstring("Audience is required")))
+		})
+
+		It("requires an issuer with a JWKS file", func() {
+			config.Issuer = ""
+			_, err := NewJWTIdentity(config)
+			Expect(err).To(MatchError(ContainSubstring("Issuer is required")))
+		})
+	})
+
+	Describe("Token verification", func() {
+		var identity *JWTIdentity
+
+		BeforeEach(func() {
+			var err error
+			identity, err = NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("accepts a valid RS256 token", func() {
+			token := signRSA("rsa-key", validClaims())
+			auth, err := identity.VerifyToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth.TenantID()).To(Equal("tenant-id"))
+			Expect(auth.TenantName()).To(Equal("tenant-name"))
+			Expect(auth.AuthToken()).To(Equal(token))
+			Expect(roleNames(identity, token)).To(Equal([]string{"member", "viewer"}))
+		})
+
+		It("accepts a valid ES256 token", func() {
+			auth, err := identity.VerifyToken(signEC("ec-key", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth.TenantID()).To(Equal("tenant-id"))
+		})
+
+		It("accepts a token without a key ID", func() {
+			_, err := identity.VerifyToken(signRSA("", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("remembers tenants of verified tokens", func() {
+			_, err := identity.VerifyToken(signRSA("rsa-key", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(identity.GetTenantID("tenant-name")).To(Equal("tenant-id"))
+			Expect(identity.GetTenantName("tenant-id")).To(Equal("tenant-name"))
+		})
+
+		It("rejects a malformed token", func() {
+			_, err := identity.VerifyToken("not-a-token")
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects a token with an unsupported algorithm", func() {
+			token := encodeJSON(map[string]string{"alg": "none"}) + "." + encodeJSON(validClaims()) + "."
+			_, err := identity.VerifyToken(token)
+			Expect(err).To(MatchError(ContainSubstring("Unsupported token algorithm")))
+		})
+
+		It("rejects a token with a tampered payload", func() {
+			token := signRSA("rsa-key", validClaims())
+			claims := validClaims()
+			claims["tenant_id"] = "other-tenant"
+			parts := strings.Split(token, ".")
+			_, err := identity.VerifyToken(parts[0] + "." + encodeJSON(claims) + "." + parts[2])
+			Expect(err).To(MatchError("Invalid token signature"))
+		})
+
+		It("rejects a token signed by an unknown key", func() {
+			_, err := identity.VerifyToken(signRSA("unknown", validClaims()))
+			Expect(err).To(MatchError("No key found for token"))
+		})
+
+		It("rejects an expired token", func() {
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-time.Minute).Unix()
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError("Token expired"))
+		})
+
+		It("rejects a token without expiration", func() {
+			claims := validClaims()
+			delete(claims, "exp")
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError("Token has no exp claim"))
+		})
+
+		It("rejects a token which is not valid yet", func() {
+			claims := validClaims()
+			claims["nbf"] = time.Now().Add(time.Hour).Unix()
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError("Token not valid yet"))
+		})
+
+		It("rejects a token of another issuer", func() {
+			claims := validClaims()
+			claims["iss"] = "https://evil.example.com"
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError(ContainSubstring("Invalid token issuer")))
+		})
+
+		It("rejects a token for another audience", func() {
+			claims := validClaims()
+			claims["aud"] = "other"
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError(ContainSubstring("Invalid token audience")))
+		})
+
+		It("rejects a token without tenant", func() {
+			claims := validClaims()
+			delete(claims, "tenant_id")
+			_, err := identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError("Token has no tenant_id claim"))
+		})
+	})
+
+	Describe("Leeway", func() {
+		It("accepts a recently expired token within leeway", func() {
+			config.Leeway = time.Minute
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			claims := validClaims()
+			claims["exp"] = time.Now().Add(-10 * time.Second).Unix()
+			_, err = identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).ToNot(HaveOccurred())
+		})
+	})
+
+	Describe("Claim mapping", func() {
+		It("maps nested claims and space separated roles", func() {
+			config.TenantIDClaim = "project.id"
+			config.TenantNameClaim = "project.name"
+			config.RolesClaim = "scope"
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			claims := validClaims()
+			claims["project"] = map[string]interface{}{"id": "project-id", "name": "project-name"}
+			claims["scope"] = "admin member"
+			token := signRSA("rsa-key", claims)
+			auth, err := identity.VerifyToken(token)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth.TenantID()).To(Equal("project-id"))
+			Expect(auth.TenantName()).To(Equal("project-name"))
+			Expect(roleNames(identity, token)).To(Equal([]string{"admin", "member"}))
+		})
+	})
+
+	Describe("Service authorization", func() {
+		It("uses configured service tenant and roles", func() {
+			config.ServiceTenantID = "service-id"
+			config.ServiceTenantName = "service"
+			config.ServiceRoles = []string{"admin"}
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			auth, err := identity.GetServiceAuthorization()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(auth.TenantID()).To(Equal("service-id"))
+			Expect(auth.TenantName()).To(Equal("service"))
+			Expect(auth.Roles()).To(HaveLen(1))
+			Expect(auth.Roles()[0].Name).To(Equal("admin"))
+		})
+	})
+
+	Describe("OpenID Connect discovery", func() {
+		var (
+			server     *ghttp.Server
+			keyFetches int
+		)
+
+		BeforeEach(func() {
+			keyFetches = 0
+			server = ghttp.NewServer()
+			server.RouteToHandler("GET", "/.well-known/openid-configuration",
+				ghttp.RespondWithJSONEncoded(http.StatusOK, map[string]string{
+					"issuer":   "https://issuer.example.com",
+					"jwks_uri": server.URL() + "/keys",
+				}))
+			server.RouteToHandler("GET", "/keys", func(w http.ResponseWriter, r *http.Request) {
+				keyFetches++
+				ghttp.RespondWithJSONEncoded(http.StatusOK, jwks)(w, r)
+			})
+			config.JWKSFile = ""
+			config.DiscoveryURL = server.URL() + "/.well-known/openid-configuration"
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("loads keys from jwks_uri", func() {
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = identity.VerifyToken(signRSA("rsa-key", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rejects a token of another issuer than the discovery document", func() {
+			config.Issuer = ""
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = identity.VerifyToken(signRSA("rsa-key", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+			claims := validClaims()
+			claims["iss"] = "https://evil.example.com"
+			_, err = identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError(ContainSubstring("Invalid token issuer")))
+		})
+
+		It("rejects a token for another client of the issuer", func() {
+			config.Issuer = ""
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			claims := validClaims()
+			claims["aud"] = "other-client"
+			_, err = identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).To(MatchError(ContainSubstring("Invalid token audience")))
+		})
+
+		It("accepts any audience when the check is skipped", func() {
+			config.Audience = ""
+			config.SkipAudienceCheck = true
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			claims := validClaims()
+			claims["aud"] = "other-client"
+			_, err = identity.VerifyToken(signRSA("rsa-key", claims))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("reloads keys when an unknown key ID is seen", func() {
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			keys := jwks["keys"].([]map[string]string)
+			keys[0]["kid"] = "rotated-key"
+			_, err = identity.VerifyToken(signRSA("rotated-key", validClaims()))
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("rate limits reloads caused by unknown key IDs", func() {
+			identity, err := NewJWTIdentity(config)
+			Expect(err).ToNot(HaveOccurred())
+			now := time.Now()
+			identity.now = func() time.Time { return now }
+			Expect(keyFetches).To(Equal(1))
+
+			_, err = identity.VerifyToken(signRSA("unknown", validClaims()))
+			Expect(err).To(HaveOccurred())
+			Expect(keyFetches).To(Equal(2))
+			_, err = identity.VerifyToken(signRSA("other-unknown", validClaims()))
+			Expect(err).To(HaveOccurred())
+			Expect(keyFetches).To(Equal(2))
+
+			now = now.Add(2 * jwksReloadInterval)
+			_, err = identity.VerifyToken(signRSA("unknown", validClaims()))
+			Expect(err).To(HaveOccurred())
+			Expect(keyFetches).To(Equal(2))
+			_, err = identity.VerifyToken(signRSA("other-unknown", validClaims()))
+			Expect(err).To(HaveOccurred())
+			Expect(keyFetches).To(Equal(3))
+		})
+	})
+})
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"net/http"
 	"regexp"
+	"time"
 
 	"github.com/cloudwan/gohan/schema"
 	"github.com/rackspace/gophercloud"
@@ -34,11 +35,42 @@ const maxReauthAttempts = 3
 //KeystoneIdentity middleware
 type KeystoneIdentity struct {
 	Client KeystoneClient
+	Cache  *TokenCache
+}
+
+type tokenDetailsVerifier interface {
+	verifyTokenDetails(token string) (schema.Authorization, TokenDetails, error)
 }
 
 // VerifyToken verifies identity
 func (identity *KeystoneIdentity) VerifyToken(token string) (schema.Authorization, error) {
-	return identity.Client.VerifyToken(token)
+	if identity.Cache == nil {
+		return identity.Client.VerifyToken(token)
+	}
+	if auth, ok := identity.Cache.Get(token); ok {
+		return auth, nil
+	}
+	var auth schema.Authorization
+	var details TokenDetails
+	var err error
+	if verifier, ok := identity.Client.(tokenDetailsVerifier); ok {
+		auth, details, err = verifier.verifyTokenDetails(token)
+	} else {
+		auth, err = identity.Client.VerifyToken(token)
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.Cache.Put(token, auth, details)
+	return auth, nil
+}
+
+//EnableCache caches verified tokens
+//Revoked tokens are removed from cache if the client supports revocation events.
+func (identity *KeystoneIdentity) EnableCache(config TokenCacheConfig) {
+	identity.Cache = NewTokenCache(config)
+	source, _ := identity.Client.(RevocationSource)
+	identity.Cache.Start(source)
 }
 
 // GetTenantID maps the given tenant/project name to the tenant's/project's ID
@@ -185,13 +217,18 @@ func NewKeystoneV3Client(authURL, userName, password, domainName, tenantName str
 
 //VerifyToken verifies keystone v3.0 token
 func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization, error) {
+	auth, _, err := client.verifyTokenDetails(token)
+	return auth, err
+}
+
+func (client *keystoneV3Client) verifyTokenDetails(token string) (auth schema.Authorization, details TokenDetails, err error) {
 	tokenResult := v3tokens.Get(client.client, token)
 	if tokenResult.Err != nil {
-		return nil, fmt.Errorf("Error during verifying token: %s", tokenResult.Err.Error())
+		return nil, details, fmt.Errorf("Error during verifying token: %s", tokenResult.Err.Error())
 	}
-	_, err := tokenResult.Extract()
+	_, err = tokenResult.Extract()
 	if err != nil {
-		return nil, fmt.Errorf("Invalid token")
+		return nil, details, fmt.Errorf("Invalid token")
 	}
 	tokenBody := tokenResult.Body.(map[string]interface{})["token"]
 	roles := tokenBody.(map[string]interface{})["roles"]
@@ -200,13 +237,19 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 		roleIDs = append(roleIDs, roleBody.(map[string]interface{})["name"].(string))
 	}
 	tokenBodyMap := tokenBody.(map[string]interface{})
-	projectObj, ok := tokenBodyMap["project"]
-	if !ok {
-		return nil, fmt.Errorf("Token is unscoped")
+	var tenantID, tenantName, domainID, domainName string
+	domain, _ := tokenBodyMap["domain"].(map[string]interface{})
+	if project, ok := tokenBodyMap["project"].(map[string]interface{}); ok {
+		tenantID, _ = project["id"].(string)
+		tenantName, _ = project["name"].(string)
+		domain, _ = project["domain"].(map[string]interface{})
+	} else if domain == nil {
+		return nil, details, fmt.Errorf("Token is unscoped")
+	}
+	if domain != nil {
+		domainID, _ = domain["id"].(string)
+		domainName, _ = domain["name"].(string)
 	}
-	project := projectObj.(map[string]interface{})
-	tenantID := project["id"].(string)
-	tenantName := project["name"].(string)
 	catalogList, ok := tokenBodyMap["catalog"].([]interface{})
 	catalogObj := []*schema.Catalog{}
 	if ok {
@@ -224,7 +267,38 @@ func (client *keystoneV3Client) VerifyToken(token string) (schema.Authorization,
 			catalogObj = append(catalogObj, schema.NewCatalog(catalog["name"].(string), catalog["type"].(string), endPoints))
 		}
 	}
-	return schema.NewAuthorization(tenantID, tenantName, token, roleIDs, catalogObj), nil
+	if user, ok := tokenBodyMap["user"].(map[string]interface{}); ok {
+		details.UserID, _ = user["id"].(string)
+	}
+	details.AuditIDs = keystoneAuditIDs(tokenBodyMap["audit_ids"])
+	details.IssuedAt = parseKeystoneTime(tokenBodyMap["issued_at"])
+	details.ExpiresAt = parseKeystoneTime(tokenBodyMap["expires_at"])
+	return schema.NewDomainAuthorization(tenantID, tenantName, domainID, domainName, token, roleIDs, catalogObj), details, nil
+}
+
+//RevocationEvents lists keystone v3.0 revocation events
+func (client *keystoneV3Client) RevocationEvents(since time.Time) ([]RevocationEvent, error) {
+	url := client.client.ServiceURL("OS-REVOKE", "events") + "?since=" + since.UTC().Format(time.RFC3339)
+	var result interface{}
+	if _, err := client.client.Get(url, &result, &gophercloud.RequestOpts{OkCodes: []int{http.StatusOK}}); err != nil {
+		return nil, err
+	}
+	body, _ := result.(map[string]interface{})
+	rawEvents, _ := body["events"].([]interface{})
+	events := []RevocationEvent{}
+	for _, rawEvent := range rawEvents {
+		event, ok := rawEvent.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		revocation := RevocationEvent{IssuedBefore: parseKeystoneTime(event["issued_before"])}
+		revocation.AuditID, _ = event["audit_id"].(string)
+		revocation.AuditChainID, _ = event["audit_chain_id"].(string)
+		revocation.UserID, _ = event["user_id"].(string)
+		revocation.ProjectID, _ = event["project_id"].(string)
+		events = append(events, revocation)
+	}
+	return events, nil
 }
 
 // GetTenantID maps the given v3.0 project ID to the projects's name
@@ -267,9 +341,14 @@ func (client *keystoneV3Client) GetClient() *gophercloud.ServiceClient {
 
 //VerifyToken verifies keystone v2.0 token
 func (client *keystoneV2Client) VerifyToken(token string) (schema.Authorization, error) {
+	auth, _, err := client.verifyTokenDetails(token)
+	return auth, err
+}
+
+func (client *keystoneV2Client) verifyTokenDetails(token string) (auth schema.Authorization, details TokenDetails, err error) {
 	tokenResult, err := verifyV2Token(client.client, token)
 	if err != nil {
-		return nil, fmt.Errorf("Invalid token")
+		return nil, details, fmt.Errorf("Invalid token")
 	}
 	fmt.Printf("%v", tokenResult)
 	tokenBody := tokenResult.(map[string]interface{})["access"]
@@ -282,7 +361,7 @@ func (client *keystoneV2Client) VerifyToken(token string) (schema.Authorization,
 	tokenBodyMap := tokenBody.(map[string]interface{})
 	tenantObj, ok := tokenBodyMap["token"].(map[string]interface{})["tenant"]
 	if !ok {
-		return nil, fmt.Errorf("Token is unscoped")
+		return nil, details, fmt.Errorf("Token is unscoped")
 	}
 	tenant := tenantObj.(map[string]interface{})
 	tenantID := tenant["id"].(string)
@@ -314,7 +393,12 @@ func (client *keystoneV2Client) VerifyToken(token string) (schema.Authorization,
 		}
 		catalogObj = append(catalogObj, schema.NewCatalog(catalog["name"].(string), catalog["type"].(string), endPoints))
 	}
-	return schema.NewAuthorization(tenantID, tenantName, token, roleIDs, catalogObj), nil
+	details.UserID, _ = userBody.(map[string]interface{})["id"].(string)
+	tokenMap := tokenBodyMap["token"].(map[string]interface{})
+	details.AuditIDs = keystoneAuditIDs(tokenMap["audit_ids"])
+	details.IssuedAt = parseKeystoneTime(tokenMap["issued_at"])
+	details.ExpiresAt = parseKeystoneTime(tokenMap["expires"])
+	return schema.NewAuthorization(tenantID, tenantName, token, roleIDs, catalogObj), details, nil
 }
 
 // GetTenantID maps the given v2.0 project name to the tenant's id
@@ -350,6 +434,28 @@ func (client *keystoneV2Client) GetServiceAuthorization() (schema.Authorization,
 	return client.VerifyToken(client.client.TokenID)
 }
 
+func keystoneAuditIDs(value interface{}) []string {
+	auditIDs := []string{}
+	rawAuditIDs, _ := value.([]interface{})
+	for _, rawAuditID := range rawAuditIDs {
+		if auditID, ok := rawAuditID.(string); ok {
+			auditIDs = append(auditIDs, auditID)
+		}
+	}
+	return auditIDs
+}
+
+//parseKeystoneTime parses timestamps returned by keystone, which may lack a time zone
+func parseKeystoneTime(value interface{}) time.Time {
+	timestamp, _ := value.(string)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999"} {
+		if parsed, err := time.Parse(layout, timestamp); err == nil {
+			return parsed
+		}
+	}
+	return time.Time{}
+}
+
 func (client *keystoneV2Client) getTenant(filter func(*v2tenants.Tenant) bool) (*v2tenants.Tenant, error) {
 	opts := v2tenants.ListOpts{}
 	pager := v2tenants.List(client.client, &opts)
@@ -169,6 +169,39 @@ var _ = Describe("Keystone client", func() {
 				Expect(tenantName).To(Equal(""))
 				Expect(err).To(MatchError("Tenant with ID 'santa' not found"))
 			})
+
+			It("Should verify project scoped token with domain", func() {
+				server.AppendHandlers(
+					ghttp.RespondWithJSONEncoded(200, getV3ProjectScopedTokenResponse()),
+				)
+				auth, err := client.VerifyToken("token")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(auth.TenantID()).To(Equal("1234"))
+				Expect(auth.TenantName()).To(Equal("admin"))
+				Expect(auth.DomainID()).To(Equal("111"))
+				Expect(auth.DomainName()).To(Equal("domain"))
+			})
+
+			It("Should verify domain scoped token", func() {
+				server.AppendHandlers(
+					ghttp.RespondWithJSONEncoded(200, getV3DomainScopedTokenResponse()),
+				)
+				auth, err := client.VerifyToken("token")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(auth.TenantID()).To(BeEmpty())
+				Expect(auth.DomainID()).To(Equal("111"))
+				Expect(auth.Roles()[0].Name).To(Equal("domain_admin"))
+			})
+
+			It("Should reject unscoped token", func() {
+				response := getV3DomainScopedTokenResponse()
+				delete(response.(map[string]interface{})["token"].(map[string]interface{}), "domain")
+				server.AppendHandlers(
+					ghttp.RespondWithJSONEncoded(200, response),
+				)
+				_, err := client.VerifyToken("token")
+				Expect(err).To(MatchError("Token is unscoped"))
+			})
 		})
 	})
 })
@@ -90,3 +90,33 @@ func getV3TenantsResponse() interface{} {
 		},
 	}
 }
+
+func getV3ProjectScopedTokenResponse() interface{} {
+	response := getV3TokensResponse().(map[string]interface{})
+	token := response["token"].(map[string]interface{})
+	token["roles"] = []interface{}{
+		map[string]interface{}{"id": "1", "name": "admin"},
+	}
+	token["project"] = map[string]interface{}{
+		"id":   "1234",
+		"name": "admin",
+		"domain": map[string]interface{}{
+			"id":   "111",
+			"name": "domain",
+		},
+	}
+	return response
+}
+
+func getV3DomainScopedTokenResponse() interface{} {
+	response := getV3TokensResponse().(map[string]interface{})
+	token := response["token"].(map[string]interface{})
+	token["roles"] = []interface{}{
+		map[string]interface{}{"id": "2", "name": "domain_admin"},
+	}
+	token["domain"] = map[string]interface{}{
+		"id":   "111",
+		"name": "domain",
+	}
+	return response
+}
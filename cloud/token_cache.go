@@ -0,0 +1,455 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	syn "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/sync"
+)
+
+//TokenCachePath is a sync path under which shared token cache entries are stored
+const TokenCachePath = "/gohan/token_cache"
+
+const tokenCacheWatchRetryInterval = 5 * time.Second
+
+//TokenCacheConfig describes token cache
+type TokenCacheConfig struct {
+	//TTL is the maximum time a verified token is cached
+	TTL time.Duration
+	//Size is the maximum number of cached tokens
+	Size int
+	//Sync shares cached tokens across nodes, nil disables sharing
+	Sync sync.Sync
+	//SharedKey signs shared entries, entries without a valid signature are ignored
+	//Sharing is disabled without it.
+	SharedKey []byte
+	//CheckInterval is an interval of revocation checks and removal of expired shared entries
+	CheckInterval time.Duration
+}
+
+//TokenDetails describes token properties used for expiration and revocation
+type TokenDetails struct {
+	UserID    string
+	AuditIDs  []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+//RevocationEvent describes revoked tokens
+//Empty fields match any token.
+type RevocationEvent struct {
+	AuditID      string
+	AuditChainID string
+	UserID       string
+	ProjectID    string
+	IssuedBefore time.Time
+}
+
+//RevocationSource lists revocation events
+type RevocationSource interface {
+	RevocationEvents(since time.Time) ([]RevocationEvent, error)
+}
+
+//TokenCache caches verified tokens
+//Tokens are identified by their SHA-256 hash, so raw tokens are never shared.
+//Shared entries are signed with HMAC-SHA256, so only nodes knowing the shared key
+//can add authorizations through the sync backend.
+type TokenCache struct {
+	config      TokenCacheConfig
+	now         func() time.Time
+	mu          syn.Mutex
+	entries     map[string]*list.Element
+	lru         *list.List
+	revocations []RevocationEvent
+	stop        chan bool
+	wg          syn.WaitGroup
+}
+
+type cachedToken struct {
+	Key        string            `json:"-"`
+	TenantID   string            `json:"tenant_id"`
+	TenantName string            `json:"tenant_name"`
+	DomainID   string            `json:"domain_id,omitempty"`
+	DomainName string            `json:"domain_name,omitempty"`
+	Roles      []string          `json:"roles"`
+	Catalog    []*schema.Catalog `json:"catalog"`
+	UserID     string            `json:"user_id"`
+	AuditIDs   []string          `json:"audit_ids"`
+	IssuedAt   time.Time         `json:"issued_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+}
+
+//signedToken is a shared cache entry with its signature
+type signedToken struct {
+	Entry json.RawMessage `json:"entry"`
+	MAC   string          `json:"mac"`
+}
+
+//NewTokenCache is a constructor for TokenCache
+func NewTokenCache(config TokenCacheConfig) *TokenCache {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = config.TTL
+	}
+	if config.Sync != nil && len(config.SharedKey) == 0 {
+		log.Warning("No shared key for token cache, cached tokens aren't shared")
+		config.Sync = nil
+	}
+	return &TokenCache{
+		config:  config,
+		now:     time.Now,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+		stop:    make(chan bool),
+	}
+}
+
+//Start starts revocation checks and watching of shared entries
+//source may be nil, then only expired entries are removed.
+func (cache *TokenCache) Start(source RevocationSource) {
+	if cache.config.Sync != nil {
+		cache.wg.Add(1)
+		go func() {
+			defer cache.wg.Done()
+			cache.watchShared()
+		}()
+	}
+	cache.wg.Add(1)
+	go func() {
+		defer cache.wg.Done()
+		since := cache.now()
+		ticker := time.NewTicker(cache.config.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-cache.stop:
+				return
+			case <-ticker.C:
+				if source != nil {
+					since = cache.checkRevocations(source, since)
+				}
+				cache.removeExpiredShared()
+			}
+		}
+	}()
+}
+
+//Stop stops background processing
+func (cache *TokenCache) Stop() {
+	close(cache.stop)
+	cache.wg.Wait()
+}
+
+//Get returns cached authorization of the token
+func (cache *TokenCache) Get(token string) (schema.Authorization, bool) {
+	key := tokenCacheKey(token)
+	cache.mu.Lock()
+	entry := cache.get(key)
+	cache.mu.Unlock()
+	if entry == nil && cache.config.Sync != nil {
+		entry = cache.fetchShared(key)
+	}
+	if entry == nil {
+		return nil, false
+	}
+	return schema.NewDomainAuthorization(entry.TenantID, entry.TenantName, entry.DomainID, entry.DomainName,
+		token, entry.Roles, entry.Catalog), true
+}
+
+//Put caches authorization of the verified token
+func (cache *TokenCache) Put(token string, auth schema.Authorization, details TokenDetails) {
+	expiresAt := cache.now().Add(cache.config.TTL)
+	if !details.ExpiresAt.IsZero() && details.ExpiresAt.Before(expiresAt) {
+		expiresAt = details.ExpiresAt
+	}
+	roles := []string{}
+	for _, role := range auth.Roles() {
+		roles = append(roles, role.Name)
+	}
+	entry := &cachedToken{
+		Key:        tokenCacheKey(token),
+		TenantID:   auth.TenantID(),
+		TenantName: auth.TenantName(),
+		DomainID:   auth.DomainID(),
+		DomainName: auth.DomainName(),
+		Roles:      roles,
+		Catalog:    auth.Catalog(),
+		UserID:     details.UserID,
+		AuditIDs:   details.AuditIDs,
+		IssuedAt:   details.IssuedAt,
+		ExpiresAt:  expiresAt,
+	}
+	cache.mu.Lock()
+	cache.put(entry)
+	cache.mu.Unlock()
+	if cache.config.Sync != nil {
+		data, err := cache.encodeShared(entry)
+		if err != nil {
+			log.Warning("Failed to encode cached token: %s", err)
+			return
+		}
+		if err := cache.config.Sync.Update(TokenCachePath+"/"+entry.Key, string(data)); err != nil {
+			log.Warning("Failed to share cached token: %s", err)
+		}
+	}
+}
+
+//Revoke removes the token from cache
+func (cache *TokenCache) Revoke(token string) {
+	cache.remove(tokenCacheKey(token))
+}
+
+//Revoked removes cached tokens matching revocation events
+func (cache *TokenCache) Revoked(events []RevocationEvent) {
+	cache.mu.Lock()
+	keys := []string{}
+	for _, element := range cache.entries {
+		entry := element.Value.(*cachedToken)
+		for _, event := range events {
+			if event.matches(entry) {
+				keys = append(keys, entry.Key)
+				break
+			}
+		}
+	}
+	cache.revocations = append(cache.revocations, events...)
+	cache.mu.Unlock()
+	for _, key := range keys {
+		cache.remove(key)
+	}
+}
+
+//Len returns number of locally cached tokens
+func (cache *TokenCache) Len() int {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.lru.Len()
+}
+
+func (cache *TokenCache) get(key string) *cachedToken {
+	element, ok := cache.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := element.Value.(*cachedToken)
+	if !cache.now().Before(entry.ExpiresAt) {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+		return nil
+	}
+	cache.lru.MoveToFront(element)
+	return entry
+}
+
+func (cache *TokenCache) put(entry *cachedToken) {
+	if element, ok := cache.entries[entry.Key]; ok {
+		cache.lru.Remove(element)
+	}
+	cache.entries[entry.Key] = cache.lru.PushFront(entry)
+	for cache.config.Size > 0 && cache.lru.Len() > cache.config.Size {
+		oldest := cache.lru.Back()
+		cache.lru.Remove(oldest)
+		delete(cache.entries, oldest.Value.(*cachedToken).Key)
+	}
+}
+
+func (cache *TokenCache) remove(key string) {
+	cache.mu.Lock()
+	if element, ok := cache.entries[key]; ok {
+		cache.lru.Remove(element)
+		delete(cache.entries, key)
+	}
+	cache.mu.Unlock()
+	if cache.config.Sync != nil {
+		cache.config.Sync.Delete(TokenCachePath + "/" + key)
+	}
+}
+
+func (cache *TokenCache) fetchShared(key string) *cachedToken {
+	node, err := cache.config.Sync.Fetch(TokenCachePath + "/" + key)
+	if err != nil || node == nil || node.Value == "" {
+		return nil
+	}
+	entry, err := cache.decodeShared(key, node.Value)
+	if err != nil {
+		log.Warning("Failed to decode shared token: %s", err)
+		return nil
+	}
+	if !cache.now().Before(entry.ExpiresAt) {
+		return nil
+	}
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	// Entries shared by other nodes may miss revocations seen by this node
+	for _, event := range cache.revocations {
+		if event.matches(entry) {
+			return nil
+		}
+	}
+	cache.put(entry)
+	return entry
+}
+
+func (cache *TokenCache) watchShared() {
+	for {
+		events := make(chan *sync.Event, 32)
+		stopWatch := make(chan bool)
+		done := make(chan error, 1)
+		go func() {
+			done <- cache.config.Sync.Watch(TokenCachePath, events, stopWatch, sync.RevisionCurrent)
+		}()
+	loop:
+		for {
+			select {
+			case <-cache.stop:
+				close(stopWatch)
+				return
+			case event := <-events:
+				if event.Action == "delete" {
+					key := strings.TrimPrefix(event.Key, TokenCachePath+"/")
+					cache.mu.Lock()
+					if element, ok := cache.entries[key]; ok {
+						cache.lru.Remove(element)
+						delete(cache.entries, key)
+					}
+					cache.mu.Unlock()
+				}
+			case err := <-done:
+				if err != nil {
+					log.Warning("Token cache watch failed: %s", err)
+				}
+				break loop
+			}
+		}
+		select {
+		case <-cache.stop:
+			return
+		case <-time.After(tokenCacheWatchRetryInterval):
+		}
+	}
+}
+
+func (cache *TokenCache) checkRevocations(source RevocationSource, since time.Time) time.Time {
+	checkedAt := cache.now()
+	events, err := source.RevocationEvents(since)
+	if err != nil {
+		log.Warning("Failed to list revoked tokens: %s", err)
+		return since
+	}
+	cache.Revoked(events)
+
+	// Revocations older than TTL can't affect live entries
+	cache.mu.Lock()
+	threshold := checkedAt.Add(-cache.config.TTL)
+	recent := []RevocationEvent{}
+	for _, event := range cache.revocations {
+		if event.IssuedBefore.IsZero() || event.IssuedBefore.After(threshold) {
+			recent = append(recent, event)
+		}
+	}
+	cache.revocations = recent
+	cache.mu.Unlock()
+	return checkedAt
+}
+
+func (cache *TokenCache) removeExpiredShared() {
+	if cache.config.Sync == nil {
+		return
+	}
+	node, err := cache.config.Sync.Fetch(TokenCachePath)
+	if err != nil || node == nil {
+		return
+	}
+	now := cache.now()
+	for _, child := range node.Children {
+		entry, err := cache.decodeShared(strings.TrimPrefix(child.Key, TokenCachePath+"/"), child.Value)
+		if err != nil || !now.Before(entry.ExpiresAt) {
+			cache.config.Sync.Delete(child.Key)
+		}
+	}
+}
+
+//encodeShared encodes the entry signed with the shared key
+func (cache *TokenCache) encodeShared(entry *cachedToken) (string, error) {
+	encoded, err := json.Marshal(entry)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(&signedToken{
+		Entry: encoded,
+		MAC:   cache.sign(entry.Key, encoded),
+	})
+	return string(data), err
+}
+
+//decodeShared decodes the entry stored under the key, verifying its signature
+func (cache *TokenCache) decodeShared(key, value string) (*cachedToken, error) {
+	signed := &signedToken{}
+	if err := json.Unmarshal([]byte(value), signed); err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(signed.MAC), []byte(cache.sign(key, signed.Entry))) {
+		return nil, fmt.Errorf("invalid signature of shared token %s", key)
+	}
+	entry := &cachedToken{}
+	if err := json.Unmarshal(signed.Entry, entry); err != nil {
+		return nil, err
+	}
+	entry.Key = key
+	return entry, nil
+}
+
+//sign returns a signature binding the encoded entry to its key
+func (cache *TokenCache) sign(key string, encoded []byte) string {
+	mac := hmac.New(sha256.New, cache.config.SharedKey)
+	mac.Write([]byte(key))
+	mac.Write([]byte{0})
+	mac.Write(encoded)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (event RevocationEvent) matches(entry *cachedToken) bool {
+	if event.UserID != "" && event.UserID != entry.UserID {
+		return false
+	}
+	if event.ProjectID != "" && event.ProjectID != entry.TenantID {
+		return false
+	}
+	if event.AuditID != "" && (len(entry.AuditIDs) == 0 || entry.AuditIDs[0] != event.AuditID) {
+		return false
+	}
+	if event.AuditChainID != "" && (len(entry.AuditIDs) == 0 || entry.AuditIDs[len(entry.AuditIDs)-1] != event.AuditChainID) {
+		return false
+	}
+	if !event.IssuedBefore.IsZero() && !entry.IssuedAt.IsZero() && entry.IssuedAt.After(event.IssuedBefore) {
+		return false
+	}
+	return true
+}
+
+func tokenCacheKey(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
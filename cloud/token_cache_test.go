@@ -0,0 +1,327 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package cloud
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	syn "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/sync"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/rackspace/gophercloud"
+)
+
+type mapSync struct {
+	mu   syn.Mutex
+	data map[string]string
+}
+
+func newMapSync() *mapSync {
+	return &mapSync{data: map[string]string{}}
+}
+
+func (s *mapSync) HasLock(path string) bool           { return false }
+func (s *mapSync) Lock(path string, block bool) error { return nil }
+func (s *mapSync) Unlock(path string) error           { return nil }
+func (s *mapSync) Close()                             {}
+func (s *mapSync) Watch(path string, responseChan chan *sync.Event, stopChan chan bool, revision int64) error {
+	<-stopChan
+	return nil
+}
+
+func (s *mapSync) Fetch(path string) (*sync.Node, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node := &sync.Node{Key: path}
+	value, found := s.data[path]
+	node.Value = value
+	for key, value := range s.data {
+		if strings.HasPrefix(key, path+"/") {
+			node.Children = append(node.Children, &sync.Node{Key: key, Value: value})
+		}
+	}
+	if !found && len(node.Children) == 0 {
+		return nil, fmt.Errorf("Not found")
+	}
+	return node, nil
+}
+
+func (s *mapSync) Update(path, json string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[path] = json
+	return nil
+}
+
+func (s *mapSync) Delete(path string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, path)
+	return nil
+}
+
+func (s *mapSync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, condition := range conditions {
+		var node *sync.Node
+		if value, ok := s.data[condition.Key]; ok {
+			node = &sync.Node{Key: condition.Key, Value: value}
+		}
+		if !condition.Check(node) {
+			return false, nil
+		}
+	}
+	for _, op := range ops {
+		switch op.Action {
+		case sync.OpUpdate:
+			s.data[op.Key] = op.Value
+		case sync.OpDelete:
+			delete(s.data, op.Key)
+		}
+	}
+	return true, nil
+}
+
+type countingKeystoneClient struct {
+	verified int
+}
+
+func (client *countingKeystoneClient) GetTenantID(string) (string, error)   { return "", nil }
+func (client *countingKeystoneClient) GetTenantName(string) (string, error) { return "", nil }
+func (client *countingKeystoneClient) GetServiceAuthorization() (schema.Authorization, error) {
+	return nil, nil
+}
+func (client *countingKeystoneClient) GetClient() *gophercloud.ServiceClient { return nil }
+func (client *countingKeystoneClient) VerifyToken(token string) (schema.Authorization, error) {
+	client.verified++
+	if token == "invalid" {
+		return nil, fmt.Errorf("Invalid token")
+	}
+	return schema.NewAuthorization("tenant", "tenant", token, []string{"member"}, nil), nil
+}
+
+type staticRevocationSource struct {
+	since  time.Time
+	events []RevocationEvent
+}
+
+func (source *staticRevocationSource) RevocationEvents(since time.Time) ([]RevocationEvent, error) {
+	source.since = since
+	return source.events, nil
+}
+
+var _ = Describe("Token cache", func() {
+	var (
+		cache *TokenCache
+		now   time.Time
+		auth  schema.Authorization
+	)
+
+	newCache := func(config TokenCacheConfig) *TokenCache {
+		cache := NewTokenCache(config)
+		cache.now = func() time.Time { return now }
+		return cache
+	}
+
+	BeforeEach(func() {
+		now = time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+		auth = schema.NewAuthorization("tenant", "tenant-name", "token", []string{"member", "viewer"}, nil)
+		cache = newCache(TokenCacheConfig{TTL: time.Minute, Size: 2})
+	})
+
+	It("returns cached authorization", func() {
+		_, ok := cache.Get("token")
+		Expect(ok).To(BeFalse())
+		cache.Put("token", auth, TokenDetails{})
+		cached, ok := cache.Get("token")
+		Expect(ok).To(BeTrue())
+		Expect(cached.TenantID()).To(Equal("tenant"))
+		Expect(cached.TenantName()).To(Equal("tenant-name"))
+		Expect(cached.AuthToken()).To(Equal("token"))
+		Expect(cached.Roles()).To(HaveLen(2))
+	})
+
+	It("expires entries after TTL", func() {
+		cache.Put("token", auth, TokenDetails{})
+		now = now.Add(time.Minute)
+		_, ok := cache.Get("token")
+		Expect(ok).To(BeFalse())
+		Expect(cache.Len()).To(Equal(0))
+	})
+
+	It("honours token expiry", func() {
+		cache.Put("token", auth, TokenDetails{ExpiresAt: now.Add(10 * time.Second)})
+		now = now.Add(10 * time.Second)
+		_, ok := cache.Get("token")
+		Expect(ok).To(BeFalse())
+	})
+
+	It("evicts least recently used entries", func() {
+		cache.Put("first", auth, TokenDetails{})
+		cache.Put("second", auth, TokenDetails{})
+		cache.Get("first")
+		cache.Put("third", auth, TokenDetails{})
+		Expect(cache.Len()).To(Equal(2))
+		_, ok := cache.Get("second")
+		Expect(ok).To(BeFalse())
+		_, ok = cache.Get("first")
+		Expect(ok).To(BeTrue())
+	})
+
+	It("drops revoked tokens", func() {
+		cache.Put("token", auth, TokenDetails{})
+		cache.Revoke("token")
+		_, ok := cache.Get("token")
+		Expect(ok).To(BeFalse())
+	})
+
+	Describe("Revocation events", func() {
+		BeforeEach(func() {
+			cache.Put("first", auth, TokenDetails{UserID: "alice", AuditIDs: []string{"audit1"}, IssuedAt: now.Add(-time.Hour)})
+			cache.Put("second", auth, TokenDetails{UserID: "bob", AuditIDs: []string{"audit2", "audit1"}, IssuedAt: now})
+		})
+
+		It("matches audit ID", func() {
+			cache.Revoked([]RevocationEvent{{AuditID: "audit1"}})
+			_, ok := cache.Get("first")
+			Expect(ok).To(BeFalse())
+			_, ok = cache.Get("second")
+			Expect(ok).To(BeTrue())
+		})
+
+		It("matches audit chain ID", func() {
+			cache.Revoked([]RevocationEvent{{AuditChainID: "audit1"}})
+			Expect(cache.Len()).To(Equal(0))
+		})
+
+		It("matches user tokens issued before the event", func() {
+			cache.Revoked([]RevocationEvent{{UserID: "alice", IssuedBefore: now.Add(-time.Minute)}})
+			_, ok := cache.Get("first")
+			Expect(ok).To(BeFalse())
+			cache.Revoked([]RevocationEvent{{UserID: "bob", IssuedBefore: now.Add(-time.Minute)}})
+			_, ok = cache.Get("second")
+			Expect(ok).To(BeTrue())
+		})
+	})
+
+	It("polls revocation source", func() {
+		cache.Put("token", auth, TokenDetails{UserID: "alice"})
+		source := &staticRevocationSource{events: []RevocationEvent{{UserID: "alice"}}}
+		since := now.Add(-time.Minute)
+		Expect(cache.checkRevocations(source, since)).To(Equal(now))
+		Expect(source.since).To(Equal(since))
+		_, ok := cache.Get("token")
+		Expect(ok).To(BeFalse())
+	})
+
+	Describe("Shared cache", func() {
+		var (
+			shared *mapSync
+			other  *TokenCache
+		)
+
+		BeforeEach(func() {
+			shared = newMapSync()
+			cache = newCache(TokenCacheConfig{TTL: time.Minute, Sync: shared, SharedKey: []byte("key")})
+			other = newCache(TokenCacheConfig{TTL: time.Minute, Sync: shared, SharedKey: []byte("key")})
+		})
+
+		It("shares entries without raw tokens", func() {
+			cache.Put("token", auth, TokenDetails{})
+			for key, value := range shared.data {
+				Expect(key).ToNot(ContainSubstring("token/token"))
+				Expect(value).ToNot(ContainSubstring(`"token"`))
+			}
+			cached, ok := other.Get("token")
+			Expect(ok).To(BeTrue())
+			Expect(cached.TenantID()).To(Equal("tenant"))
+		})
+
+		It("removes revoked entries from other nodes", func() {
+			cache.Put("token", auth, TokenDetails{})
+			cache.Revoke("token")
+			_, ok := other.Get("token")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("rejects shared entries matching seen revocations", func() {
+			other.Revoked([]RevocationEvent{{UserID: "alice"}})
+			cache.Put("token", auth, TokenDetails{UserID: "alice"})
+			_, ok := other.Get("token")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("rejects shared entries without a valid signature", func() {
+			forged := newCache(TokenCacheConfig{TTL: time.Minute, Sync: shared, SharedKey: []byte("forged")})
+			forged.Put("token", auth, TokenDetails{})
+			_, ok := other.Get("token")
+			Expect(ok).To(BeFalse())
+
+			entry, _ := json.Marshal(&cachedToken{TenantID: "admin", Roles: []string{"admin"}, ExpiresAt: now.Add(time.Hour)})
+			shared.Update(TokenCachePath+"/"+tokenCacheKey("other"), string(entry))
+			_, ok = other.Get("other")
+			Expect(ok).To(BeFalse())
+		})
+
+		It("removes shared entries without a valid signature", func() {
+			shared.Update(TokenCachePath+"/"+tokenCacheKey("token"), `{"entry": {"tenant_id": "admin"}, "mac": ""}`)
+			cache.removeExpiredShared()
+			Expect(shared.data).To(BeEmpty())
+		})
+
+		It("doesn't share entries without a shared key", func() {
+			cache = newCache(TokenCacheConfig{TTL: time.Minute, Sync: shared})
+			cache.Put("token", auth, TokenDetails{})
+			Expect(shared.data).To(BeEmpty())
+		})
+
+		It("removes expired shared entries", func() {
+			cache.Put("token", auth, TokenDetails{})
+			now = now.Add(time.Hour)
+			cache.removeExpiredShared()
+			Expect(shared.data).To(BeEmpty())
+		})
+	})
+
+	Describe("Keystone identity", func() {
+		It("verifies a token once", func() {
+			client := &countingKeystoneClient{}
+			identity := &KeystoneIdentity{Client: client, Cache: cache}
+			for i := 0; i < 3; i++ {
+				auth, err := identity.VerifyToken("token")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(auth.TenantID()).To(Equal("tenant"))
+			}
+			Expect(client.verified).To(Equal(1))
+		})
+
+		It("doesn't cache invalid tokens", func() {
+			client := &countingKeystoneClient{}
+			identity := &KeystoneIdentity{Client: client, Cache: cache}
+			for i := 0; i < 2; i++ {
+				_, err := identity.VerifyToken("invalid")
+				Expect(err).To(HaveOccurred())
+			}
+			Expect(client.verified).To(Equal(2))
+		})
+	})
+})
@@ -38,6 +38,8 @@ type DB interface {
 	Begin() (transaction.Transaction, error)
 	RegisterTable(s *schema.Schema, cascade, migrate bool) error
 	DropTable(*schema.Schema) error
+	//SupportsRollback tells if changes of transactions which aren't committed are discarded
+	SupportsRollback() bool
 }
 
 //ConnectDB is builder function of DB
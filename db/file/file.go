@@ -85,6 +85,11 @@ func (db *DB) DropTable(s *schema.Schema) error {
 	return nil
 }
 
+//SupportsRollback is false because file databases apply changes immediately
+func (db *DB) SupportsRollback() bool {
+	return false
+}
+
 func (db *DB) load() error {
 	data, err := util.LoadMap(db.filePath)
 	if err != nil {
@@ -168,6 +173,23 @@ func (tx *Transaction) Delete(s *schema.Schema, resourceID interface{}) error {
 	return nil
 }
 
+//DeleteFilter deletes resources matching the filter from db
+func (tx *Transaction) DeleteFilter(s *schema.Schema, filter transaction.Filter) error {
+	db := tx.db
+	db.load()
+	table := db.getTable(s)
+	newTable := []interface{}{}
+	for _, rawDataInDB := range table {
+		dataInDB := rawDataInDB.(map[string]interface{})
+		if !matchFilter(s, dataInDB, filter) {
+			newTable = append(newTable, dataInDB)
+		}
+	}
+	db.data[s.GetDbTableName()] = newTable
+	db.write()
+	return nil
+}
+
 type byPaginator struct {
 	data []*schema.Resource
 	pg   *pagination.Paginator
@@ -215,45 +237,7 @@ func (tx *Transaction) List(s *schema.Schema, filter transaction.Filter, pg *pag
 			log.Warning("%s %s", resource, err)
 			return
 		}
-		valid := true
-		if filter != nil {
-			for key, value := range filter {
-				if data[key] == nil {
-					continue
-				}
-				property, err := s.GetPropertyByID(key)
-				if err != nil {
-					continue
-				}
-				switch value.(type) {
-				case string:
-					if property.Type == "boolean" {
-						dataBool, err1 := strconv.ParseBool(data[key].(string))
-						valueBool, err2 := strconv.ParseBool(value.(string))
-						if err1 != nil || err2 != nil || dataBool != valueBool {
-							valid = false
-						}
-					} else if data[key] != value {
-						valid = false
-					}
-				case []string:
-					if property.Type == "boolean" {
-						v, _ := strconv.ParseBool(data[key].(string))
-						if !boolInSlice(v, value.([]string)) {
-							valid = false
-						}
-					}
-					if !stringInSlice(fmt.Sprintf("%v", data[key]), value.([]string)) {
-						valid = false
-					}
-				default:
-					if data[key] != value {
-						valid = false
-					}
-				}
-			}
-		}
-		if valid {
+		if matchFilter(s, data, filter) {
 			list = append(list, resource)
 		}
 
@@ -313,6 +297,60 @@ func (tx *Transaction) Exec(sql string, args ...interface{}) error {
 	panic("Not implemented")
 }
 
+//matchFilter checks data against the filter, a slice value matches any of its elements
+func matchFilter(s *schema.Schema, data map[string]interface{}, filter transaction.Filter) bool {
+	valid := true
+	for key, value := range filter {
+		if data[key] == nil {
+			continue
+		}
+		property, err := s.GetPropertyByID(key)
+		if err != nil {
+			continue
+		}
+		switch value.(type) {
+		case string:
+			if property.Type == "boolean" {
+				dataBool, err1 := strconv.ParseBool(data[key].(string))
+				valueBool, err2 := strconv.ParseBool(value.(string))
+				if err1 != nil || err2 != nil || dataBool != valueBool {
+					valid = false
+				}
+			} else if data[key] != value {
+				valid = false
+			}
+		case []interface{}:
+			if !interfaceInSlice(data[key], value.([]interface{})) {
+				valid = false
+			}
+		case []string:
+			if property.Type == "boolean" {
+				v, _ := strconv.ParseBool(data[key].(string))
+				if !boolInSlice(v, value.([]string)) {
+					valid = false
+				}
+			}
+			if !stringInSlice(fmt.Sprintf("%v", data[key]), value.([]string)) {
+				valid = false
+			}
+		default:
+			if data[key] != value {
+				valid = false
+			}
+		}
+	}
+	return valid
+}
+
+func interfaceInSlice(a interface{}, list []interface{}) bool {
+	for _, b := range list {
+		if fmt.Sprint(b) == fmt.Sprint(a) {
+			return true
+		}
+	}
+	return false
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
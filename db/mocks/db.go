@@ -86,6 +86,16 @@ func (_mr *_MockDBRecorder) DropTable(arg0 interface{}) *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "DropTable", arg0)
 }
 
+func (_m *MockDB) SupportsRollback() bool {
+	ret := _m.ctrl.Call(_m, "SupportsRollback")
+	ret0, _ := ret[0].(bool)
+	return ret0
+}
+
+func (_mr *_MockDBRecorder) SupportsRollback() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SupportsRollback")
+}
+
 func (_m *MockDB) Close() {
 	_m.ctrl.Call(_m, "Close")
 }
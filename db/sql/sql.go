@@ -433,6 +433,11 @@ func (db *DB) RegisterTable(s *schema.Schema, cascade, migrate bool) error {
 	return err
 }
 
+//SupportsRollback is true for sql databases
+func (db *DB) SupportsRollback() bool {
+	return true
+}
+
 //DropTable drop table definition
 func (db *DB) DropTable(s *schema.Schema) error {
 	if s.IsAbstract() {
@@ -570,6 +575,23 @@ func (tx *Transaction) Delete(s *schema.Schema, resourceID interface{}) error {
 	return tx.Exec(sql, args...)
 }
 
+//DeleteFilter deletes resources matching the filter in one statement
+//A slice value matches any of its elements, like id IN (...).
+func (tx *Transaction) DeleteFilter(s *schema.Schema, filter transaction.Filter) error {
+	q := sq.Delete(quote(s.GetDbTableName()))
+	for key, value := range filter {
+		if _, err := s.GetPropertyByID(key); err != nil {
+			return err
+		}
+		q = q.Where(sq.Eq{quote(key): value})
+	}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+	return tx.Exec(sql, args...)
+}
+
 func (db *DB) handler(property *schema.Property) propertyHandler {
 	handler, ok := db.handlers[property.Type]
 	if ok {
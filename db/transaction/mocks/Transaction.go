@@ -84,6 +84,20 @@ func (_m *Transaction) Delete(_a0 *schema.Schema, _a1 interface{}) error {
 	return r0
 }
 
+// DeleteFilter mock
+func (_m *Transaction) DeleteFilter(_a0 *schema.Schema, _a1 transaction.Filter) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*schema.Schema, transaction.Filter) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Fetch mock
 func (_m *Transaction) Fetch(_a0 *schema.Schema, _a1 transaction.Filter) (*schema.Resource, error) {
 	ret := _m.Called(_a0, _a1)
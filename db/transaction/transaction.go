@@ -64,6 +64,7 @@ type Transaction interface {
 	SetIsolationLevel(Type) error
 	StateUpdate(*schema.Resource, *ResourceState) error
 	Delete(*schema.Schema, interface{}) error
+	DeleteFilter(*schema.Schema, Filter) error
 	Fetch(*schema.Schema, Filter) (*schema.Resource, error)
 	LockFetch(*schema.Schema, Filter, LockPolicy) (*schema.Resource, error)
 	StateFetch(*schema.Schema, Filter) (ResourceState, error)
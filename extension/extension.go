@@ -17,6 +17,7 @@ package extension
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/cloudwan/gohan/schema"
@@ -35,10 +36,13 @@ var manager *Manager
 //This is a singleton class.
 type Manager struct {
 	environments map[string]Environment
+	mu           sync.RWMutex
 }
 
 //RegisterEnvironment registers a new environment for the given schema ID
 func (manager *Manager) RegisterEnvironment(schemaID string, env Environment) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
 	if _, ok := manager.environments[schemaID]; ok {
 		return fmt.Errorf("Environment already registered for this schema")
 	}
@@ -46,8 +50,17 @@ func (manager *Manager) RegisterEnvironment(schemaID string, env Environment) er
 	return nil
 }
 
+//ReplaceEnvironment registers the environment for the given schema ID in place of the current one
+func (manager *Manager) ReplaceEnvironment(schemaID string, env Environment) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.environments[schemaID] = env
+}
+
 //UnRegisterEnvironment removes an environment registered for the given schema ID
 func (manager *Manager) UnRegisterEnvironment(schemaID string) error {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
 	if _, ok := manager.environments[schemaID]; !ok {
 		return fmt.Errorf("No environment registered for this schema")
 	}
@@ -57,7 +70,9 @@ func (manager *Manager) UnRegisterEnvironment(schemaID string) error {
 
 //GetEnvironment returns the environment registered for the given schema ID
 func (manager *Manager) GetEnvironment(schemaID string) (env Environment, ok bool) {
+	manager.mu.RLock()
 	env, ok = manager.environments[schemaID]
+	manager.mu.RUnlock()
 	if ok {
 		env = env.Clone()
 	}
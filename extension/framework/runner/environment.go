@@ -27,7 +27,7 @@ import (
 	"github.com/cloudwan/gohan/extension"
 	"github.com/cloudwan/gohan/schema"
 	"github.com/cloudwan/gohan/server/middleware"
-	"github.com/cloudwan/gohan/sync/noop"
+	"github.com/cloudwan/gohan/sync/memory"
 	"github.com/xyproto/otto"
 
 	_ "github.com/xyproto/otto/underscore"
@@ -74,7 +74,11 @@ func (env *Environment) InitializeEnvironment() error {
 	envName := strings.TrimSuffix(
 		filepath.Base(env.testFileName),
 		filepath.Ext(env.testFileName))
-	env.Environment = gohan_otto.NewEnvironment(envName, env.dbConnection, &middleware.FakeIdentity{}, noop.NewSync())
+	sync, err := memory.NewSync("")
+	if err != nil {
+		return fmt.Errorf("Failed to create sync: %s", err)
+	}
+	env.Environment = gohan_otto.NewEnvironment(envName, env.dbConnection, &middleware.FakeIdentity{}, sync)
 	env.SetUp()
 	env.addTestingAPI()
 
@@ -136,6 +140,7 @@ func (env *Environment) ClearEnvironment() {
 	for _, tx := range env.dbTransactions {
 		tx.Close()
 	}
+	env.Sync.Close()
 	env.Environment.ClearEnvironment()
 	schema.ClearManager()
 }
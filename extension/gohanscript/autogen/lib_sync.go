@@ -0,0 +1,249 @@
+package autogen
+
+// AUTO GENERATED CODE DO NOT MODIFY MANUALLY
+import (
+	"github.com/cloudwan/gohan/extension/gohanscript"
+	"github.com/cloudwan/gohan/extension/gohanscript/lib"
+	"github.com/cloudwan/gohan/sync"
+)
+
+func init() {
+
+	gohanscript.RegisterStmtParser("memory_sync",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var file string
+				ifile := stmt.Arg("file", context)
+				if ifile != nil {
+					file = ifile.(string)
+				}
+
+				result1,
+					err :=
+					lib.MemorySync(
+						file)
+
+				return result1, err
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("MemorySync",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			file, _ := args[0].(string)
+
+			result1,
+				err :=
+				lib.MemorySync(
+					file)
+			return []interface{}{
+				result1,
+				err}
+
+		})
+
+	gohanscript.RegisterStmtParser("sync_txn",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var backend sync.Sync
+				ibackend := stmt.Arg("backend", context)
+				if ibackend != nil {
+					backend = ibackend.(sync.Sync)
+				}
+				var conditions []interface{}
+				iconditions := stmt.Arg("conditions", context)
+				if iconditions != nil {
+					conditions = iconditions.([]interface{})
+				}
+				var operations []interface{}
+				ioperations := stmt.Arg("operations", context)
+				if ioperations != nil {
+					operations = ioperations.([]interface{})
+				}
+
+				result1,
+					err :=
+					lib.SyncTxn(
+						backend, conditions, operations)
+
+				return result1, err
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("SyncTxn",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			backend, _ := args[0].(sync.Sync)
+			conditions, _ := args[0].([]interface{})
+			operations, _ := args[0].([]interface{})
+
+			result1,
+				err :=
+				lib.SyncTxn(
+					backend, conditions, operations)
+			return []interface{}{
+				result1,
+				err}
+
+		})
+
+	gohanscript.RegisterStmtParser("sync_campaign",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var backend sync.Sync
+				ibackend := stmt.Arg("backend", context)
+				if ibackend != nil {
+					backend = ibackend.(sync.Sync)
+				}
+				var key string
+				ikey := stmt.Arg("key", context)
+				if ikey != nil {
+					key = ikey.(string)
+				}
+				var timeout int
+				itimeout := stmt.Arg("timeout", context)
+				if itimeout != nil {
+					timeout = itimeout.(int)
+				}
+
+				result1,
+					err :=
+					lib.SyncCampaign(
+						backend, key, timeout)
+
+				return result1, err
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("SyncCampaign",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			backend, _ := args[0].(sync.Sync)
+			key, _ := args[0].(string)
+			timeout, _ := args[0].(int)
+
+			result1,
+				err :=
+				lib.SyncCampaign(
+					backend, key, timeout)
+			return []interface{}{
+				result1,
+				err}
+
+		})
+
+	gohanscript.RegisterStmtParser("sync_resign",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var backend sync.Sync
+				ibackend := stmt.Arg("backend", context)
+				if ibackend != nil {
+					backend = ibackend.(sync.Sync)
+				}
+				var key string
+				ikey := stmt.Arg("key", context)
+				if ikey != nil {
+					key = ikey.(string)
+				}
+
+				err :=
+					lib.SyncResign(
+						backend, key)
+
+				return nil, err
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("SyncResign",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			backend, _ := args[0].(sync.Sync)
+			key, _ := args[0].(string)
+
+			err :=
+				lib.SyncResign(
+					backend, key)
+			return []interface{}{
+				err}
+
+		})
+
+	gohanscript.RegisterStmtParser("sync_is_leader",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var backend sync.Sync
+				ibackend := stmt.Arg("backend", context)
+				if ibackend != nil {
+					backend = ibackend.(sync.Sync)
+				}
+				var key string
+				ikey := stmt.Arg("key", context)
+				if ikey != nil {
+					key = ikey.(string)
+				}
+
+				result1 :=
+					lib.SyncIsLeader(
+						backend, key)
+
+				return result1, nil
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("SyncIsLeader",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			backend, _ := args[0].(sync.Sync)
+			key, _ := args[0].(string)
+
+			result1 :=
+				lib.SyncIsLeader(
+					backend, key)
+			return []interface{}{
+				result1}
+
+		})
+
+	gohanscript.RegisterStmtParser("sync_leader",
+		func(stmt *gohanscript.Stmt) (func(*gohanscript.Context) (interface{}, error), error) {
+			return func(context *gohanscript.Context) (interface{}, error) {
+
+				var backend sync.Sync
+				ibackend := stmt.Arg("backend", context)
+				if ibackend != nil {
+					backend = ibackend.(sync.Sync)
+				}
+				var key string
+				ikey := stmt.Arg("key", context)
+				if ikey != nil {
+					key = ikey.(string)
+				}
+
+				result1 :=
+					lib.SyncLeader(
+						backend, key)
+
+				return result1, nil
+
+			}, nil
+		})
+	gohanscript.RegisterMiniGoFunc("SyncLeader",
+		func(vm *gohanscript.VM, args []interface{}) []interface{} {
+
+			backend, _ := args[0].(sync.Sync)
+			key, _ := args[0].(string)
+
+			result1 :=
+				lib.SyncLeader(
+					backend, key)
+			return []interface{}{
+				result1}
+
+		})
+
+}
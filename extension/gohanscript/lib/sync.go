@@ -0,0 +1,73 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lib
+
+import (
+	"time"
+
+	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+//MemorySync creates an in-memory sync, persisted to file if it isn't empty
+func MemorySync(file string) (sync.Sync, error) {
+	s, err := memory.NewSync(file)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+//SyncTxn applies operations atomically when all conditions hold
+//and returns if they did. See gohan_sync_txn for the format of conditions and operations.
+func SyncTxn(backend sync.Sync, conditions []interface{}, operations []interface{}) (bool, error) {
+	parsedConditions, ops, err := sync.ParseTxn(conditions, operations)
+	if err != nil {
+		return false, err
+	}
+	return backend.Txn(parsedConditions, ops)
+}
+
+//SyncCampaign campaigns to lead the election of key no longer than timeout in milliseconds
+//and returns if this process leads. See gohan_sync_campaign.
+func SyncCampaign(backend sync.Sync, key string, timeout int) (bool, error) {
+	stopChan := make(chan bool, 1)
+	timer := time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+		stopChan <- true
+	})
+	defer timer.Stop()
+	err := election.Get(backend, key).Campaign(stopChan)
+	if err == election.ErrStopped {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+//SyncResign stops leading the election of key
+func SyncResign(backend sync.Sync, key string) error {
+	return election.Get(backend, key).Resign()
+}
+
+//SyncIsLeader returns if this process leads the election of key
+func SyncIsLeader(backend sync.Sync, key string) bool {
+	return election.Get(backend, key).IsLeader()
+}
+
+//SyncLeader returns ID of the process leading the election of key
+func SyncLeader(backend sync.Sync, key string) string {
+	return election.Get(backend, key).Leader()
+}
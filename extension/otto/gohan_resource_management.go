@@ -269,6 +269,7 @@ func GohanModelCreate(context map[string]interface{}, schemaID string,
 	if err != nil {
 		return nil, err
 	}
+	context["resource"] = resourceObj.Data()
 
 	if err := resources.CreateResourceInTransaction(
 		context, resourceObj); err != nil {
@@ -17,6 +17,7 @@ package otto
 
 import (
 	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/xyproto/otto"
 	"time"
 )
@@ -149,6 +150,228 @@ func init() {
 				}
 				return otto.NullValue()
 			},
+			"gohan_sync_txn": func(call otto.FunctionCall) otto.Value {
+				var rawConditions, rawOperations []interface{}
+				var conditions []*sync.Condition
+				var ops []*sync.Op
+				var succeeded bool
+				var err error
+				var value otto.Value
+
+				VerifyCallArguments(&call, "gohan_sync_txn", 2)
+
+				if rawConditions, err = GetList(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected an array")
+					return otto.NullValue()
+				}
+
+				if rawOperations, err = GetList(call.Argument(1)); err != nil {
+					ThrowOttoException(&call, "Invalid type of second argument: expected an array")
+					return otto.NullValue()
+				}
+
+				if conditions, ops, err = sync.ParseTxn(rawConditions, rawOperations); err != nil {
+					ThrowOttoException(&call, "Invalid sync transaction: "+err.Error())
+					return otto.NullValue()
+				}
+
+				done := make(chan struct{})
+				go func() {
+					succeeded, err = env.Sync.Txn(conditions, ops)
+					close(done)
+				}()
+
+				select {
+				case interrupt := <-call.Otto.Interrupt:
+					log.Debug("Received otto interrupt in gohan_sync_txn")
+					interrupt()
+				case <-done:
+				}
+
+				if err != nil {
+					ThrowOttoException(&call, "Failed to apply sync transaction: "+err.Error())
+					return otto.NullValue()
+				}
+
+				if value, err = vm.ToValue(succeeded); err == nil {
+					return value
+				}
+
+				return otto.NullValue()
+			},
+			"gohan_sync_campaign": func(call otto.FunctionCall) otto.Value {
+				var path string
+				var timeoutMsec int64
+				var err error
+				var value otto.Value
+
+				VerifyCallArguments(&call, "gohan_sync_campaign", 2)
+
+				if path, err = GetString(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+					return otto.NullValue()
+				}
+
+				if timeoutMsec, err = GetInt64(call.Argument(1)); err != nil {
+					ThrowOttoException(&call, "Invalid type of second argument: expected an int64")
+					return otto.NullValue()
+				}
+
+				stopChan := make(chan bool, 1) // non-blocking
+				done := make(chan struct{})
+				go func() {
+					err = election.Get(env.Sync, path).Campaign(stopChan)
+					close(done)
+				}()
+
+				timer := time.NewTimer(time.Duration(timeoutMsec) * time.Millisecond)
+				defer timer.Stop()
+				select {
+				case interrupt := <-call.Otto.Interrupt:
+					log.Debug("Received otto interrupt in gohan_sync_campaign")
+					stopChan <- true
+					interrupt()
+				case <-timer.C:
+					stopChan <- true
+					<-done
+				case <-done:
+				}
+
+				if err != nil && err != election.ErrStopped {
+					ThrowOttoException(&call, "Failed to campaign: "+err.Error())
+					return otto.NullValue()
+				}
+
+				if value, err = vm.ToValue(err == nil); err == nil {
+					return value
+				}
+
+				return otto.NullValue()
+			},
+			"gohan_sync_resign": func(call otto.FunctionCall) otto.Value {
+				var path string
+				var err error
+
+				VerifyCallArguments(&call, "gohan_sync_resign", 1)
+
+				if path, err = GetString(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+					return otto.NullValue()
+				}
+
+				if err = election.Get(env.Sync, path).Resign(); err != nil {
+					ThrowOttoException(&call, "Failed to resign: "+err.Error())
+				}
+
+				return otto.NullValue()
+			},
+			"gohan_sync_is_leader": func(call otto.FunctionCall) otto.Value {
+				var path string
+				var err error
+				var value otto.Value
+
+				VerifyCallArguments(&call, "gohan_sync_is_leader", 1)
+
+				if path, err = GetString(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+					return otto.NullValue()
+				}
+
+				if value, err = vm.ToValue(election.Get(env.Sync, path).IsLeader()); err == nil {
+					return value
+				}
+
+				return otto.NullValue()
+			},
+			"gohan_sync_leader": func(call otto.FunctionCall) otto.Value {
+				var path string
+				var leader string
+				var err error
+				var value otto.Value
+
+				VerifyCallArguments(&call, "gohan_sync_leader", 1)
+
+				if path, err = GetString(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+					return otto.NullValue()
+				}
+
+				done := make(chan struct{})
+				go func() {
+					leader = election.Get(env.Sync, path).Leader()
+					close(done)
+				}()
+
+				select {
+				case interrupt := <-call.Otto.Interrupt:
+					log.Debug("Received otto interrupt in gohan_sync_leader")
+					interrupt()
+				case <-done:
+				}
+
+				if value, err = vm.ToValue(leader); err == nil {
+					return value
+				}
+
+				return otto.NullValue()
+			},
+			"gohan_sync_observe_leader": func(call otto.FunctionCall) otto.Value {
+				var path string
+				var timeoutMsec int64
+				var err error
+				var value otto.Value
+
+				VerifyCallArguments(&call, "gohan_sync_observe_leader", 2)
+
+				if path, err = GetString(call.Argument(0)); err != nil {
+					ThrowOttoException(&call, "Invalid type of first argument: expected a string")
+					return otto.NullValue()
+				}
+
+				if timeoutMsec, err = GetInt64(call.Argument(1)); err != nil {
+					ThrowOttoException(&call, "Invalid type of second argument: expected an int64")
+					return otto.NullValue()
+				}
+
+				leaderChan := make(chan string, 2) // non-blocking for the current and the next leader
+				stopChan := make(chan bool, 1)     // non-blocking
+				errorChan := make(chan error, 1)   // non-blocking
+
+				go func() {
+					if err := election.Get(env.Sync, path).Observe(leaderChan, stopChan); err != nil {
+						errorChan <- err
+					}
+				}()
+
+				timer := time.NewTimer(time.Duration(timeoutMsec) * time.Millisecond)
+				defer timer.Stop()
+				first := true
+				for {
+					select {
+					case interrupt := <-call.Otto.Interrupt:
+						log.Debug("Received otto interrupt in gohan_sync_observe_leader")
+						stopChan <- true
+						interrupt()
+						return otto.NullValue()
+					case leader := <-leaderChan:
+						if first {
+							first = false
+							continue
+						}
+						stopChan <- true
+						if value, err = vm.ToValue(leader); err == nil {
+							return value
+						}
+						return otto.NullValue()
+					case <-timer.C:
+						stopChan <- true
+						return otto.NullValue()
+					case err := <-errorChan:
+						ThrowOttoException(&call, "Failed to observe leader: "+err.Error())
+						return otto.NullValue()
+					}
+				}
+			},
 		}
 		for name, object := range builtins {
 			vm.Set(name, object)
@@ -1806,6 +1806,70 @@ var _ = Describe("Otto extension manager", func() {
 			Expect(context).To(HaveKeyWithValue("resp", HaveKeyWithValue("value", "{}")))
 		})
 	})
+	Describe("Using gohan_sync_txn builtin", func() {
+		It("Should apply operations when conditions hold", func() {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+					gohan_register_handler(
+						"test_event",
+					 	function(context) {
+							var operations = [
+								{"action": "update", "key": "/gohan_sync_txn_test/a", "value": {"name": "a"}},
+								{"action": "update", "key": "/gohan_sync_txn_test/b", "value": "{}"}
+							];
+							context.first = gohan_sync_txn([{"key": "/gohan_sync_txn_test/a", "revision": 0}], operations);
+							context.second = gohan_sync_txn([{"key": "/gohan_sync_txn_test/a", "revision": 0}], operations);
+						}
+					);
+					`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			extensions := []*schema.Extension{extension}
+			env := newEnvironment()
+			Expect(env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "test_path")).To(Succeed())
+			context := map[string]interface{}{}
+			env.Sync.Delete("/gohan_sync_txn_test/a")
+			env.Sync.Delete("/gohan_sync_txn_test/b")
+			Expect(env.HandleEvent("test_event", context)).To(Succeed())
+			Expect(context).To(HaveKeyWithValue("first", true))
+			Expect(context).To(HaveKeyWithValue("second", false))
+			node, err := env.Sync.Fetch("/gohan_sync_txn_test/a")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(node.Value).To(MatchJSON(`{"name": "a"}`))
+		})
+	})
+	Describe("Using gohan_sync_campaign builtin", func() {
+		It("Should lead until resigning", func() {
+			extension, err := schema.NewExtension(map[string]interface{}{
+				"id": "test_extension",
+				"code": `
+					gohan_register_handler(
+						"test_event",
+					 	function(context) {
+							context.elected = gohan_sync_campaign("/gohan_sync_campaign_test", 500);
+							context.leading = gohan_sync_is_leader("/gohan_sync_campaign_test");
+							context.leader = gohan_sync_leader("/gohan_sync_campaign_test");
+							gohan_sync_resign("/gohan_sync_campaign_test");
+							context.resigned = !gohan_sync_is_leader("/gohan_sync_campaign_test");
+						}
+					);
+					`,
+				"path": ".*",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			extensions := []*schema.Extension{extension}
+			env := newEnvironment()
+			Expect(env.LoadExtensionsForPath(extensions, timeLimit, timeLimits, "test_path")).To(Succeed())
+			context := map[string]interface{}{}
+			Expect(env.HandleEvent("test_event", context)).To(Succeed())
+			Expect(context).To(HaveKeyWithValue("elected", true))
+			Expect(context).To(HaveKeyWithValue("leading", true))
+			Expect(context).To(HaveKeyWithValue("leader", Not(BeEmpty())))
+			Expect(context).To(HaveKeyWithValue("resigned", true))
+		})
+	})
 	Describe("Using gohan_sync_watch builtin", func() {
 		It("Should timeout with no events", func() {
 			extension, err := schema.NewExtension(map[string]interface{}{
@@ -75,3 +75,8 @@ func NewExtension(raw interface{}) (*Extension, error) {
 func (e *Extension) Match(path string) bool {
 	return e.Path.MatchString(path)
 }
+
+func (e *Extension) equal(other *Extension) bool {
+	return e.ID == other.ID && e.CodeType == other.CodeType && e.URL == other.URL &&
+		e.Code == other.Code && e.Path.String() == other.Path.String()
+}
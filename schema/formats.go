@@ -17,8 +17,10 @@ package schema
 
 import (
 	"net"
+	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
 
 	"github.com/twinj/uuid"
 	"github.com/xeipuuv/gojsonschema"
@@ -34,6 +36,7 @@ type nonHyphenatedUUIDFormatChecker struct{}
 type portFormatChecker struct{}
 type yamlFormatChecker struct{}
 type textFormatChecker struct{}
+type urlFormatChecker struct{}
 
 func (f macFormatChecker) IsFormat(input string) bool {
 	match, _ := regexp.MatchString(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`, input)
@@ -84,6 +87,30 @@ func (f textFormatChecker) IsFormat(input string) bool {
 	return true
 }
 
+//urlFormatChecker accepts locations extension code can be loaded from:
+//http and https URLs with a host, embed and file URLs, and plain file paths
+func (f urlFormatChecker) IsFormat(input string) bool {
+	if input == "" {
+		return true
+	}
+	if strings.TrimSpace(input) != input {
+		return false
+	}
+	location, err := url.Parse(input)
+	if err != nil {
+		return false
+	}
+	switch location.Scheme {
+	case "http", "https":
+		return location.Host != ""
+	case "embed", "file":
+		return location.Host != "" || location.Path != ""
+	case "":
+		return location.Path != ""
+	}
+	return false
+}
+
 func registerGohanFormats(checkers gojsonschema.FormatCheckerChain) {
 	checkers.Add("mac", macFormatChecker{})
 	checkers.Add("cidr", cidrFormatChecker{})
@@ -95,4 +122,7 @@ func registerGohanFormats(checkers gojsonschema.FormatCheckerChain) {
 	checkers.Add("port", portFormatChecker{})
 	checkers.Add("yaml", yamlFormatChecker{})
 	checkers.Add("text", textFormatChecker{})
+	//code of extensions is compiled according to its code_type when environments are built
+	checkers.Add("javascript", textFormatChecker{})
+	checkers.Add("url", urlFormatChecker{})
 }
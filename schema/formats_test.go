@@ -295,4 +295,51 @@ var _ = Describe("format checkers", func() {
 			Expect(result).To(Equal(false))
 		})
 	})
+
+	Describe("URL format checker", func() {
+		BeforeEach(func() {
+			formatChecker = urlFormatChecker{}
+		})
+
+		It("Should pass - empty", func() {
+			result := formatChecker.IsFormat("")
+			Expect(result).To(Equal(true))
+		})
+
+		It("Should pass - file URL", func() {
+			result := formatChecker.IsFormat("file://etc/extensions/code.js")
+			Expect(result).To(Equal(true))
+		})
+
+		It("Should pass - https URL", func() {
+			result := formatChecker.IsFormat("https://example.com/extensions/code.js")
+			Expect(result).To(Equal(true))
+		})
+
+		It("Should pass - file path", func() {
+			result := formatChecker.IsFormat("etc/extensions/code.js")
+			Expect(result).To(Equal(true))
+		})
+
+		It("Should not pass - invalid escape", func() {
+			result := formatChecker.IsFormat("http://example.com/%zz")
+			Expect(result).To(Equal(false))
+		})
+
+		It("Should not pass - unsupported scheme", func() {
+			for _, input := range []string{"ftp://example.com/code.js", "javascript:alert(1)", "mailto:admin@example.com"} {
+				Expect(formatChecker.IsFormat(input)).To(Equal(false), input)
+			}
+		})
+
+		It("Should not pass - http URL without host", func() {
+			result := formatChecker.IsFormat("https:///code.js")
+			Expect(result).To(Equal(false))
+		})
+
+		It("Should not pass - surrounding whitespace", func() {
+			result := formatChecker.IsFormat(" etc/extensions/code.js")
+			Expect(result).To(Equal(false))
+		})
+	})
 })
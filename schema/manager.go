@@ -40,11 +40,17 @@ type Manager struct {
 	schemas     Map
 	schemaOrder []string
 	policies    []*Policy
+	roles       *RoleHierarchy
 	Extensions  []*Extension
 	TimeLimit   time.Duration         // default time limit for an extension
 	TimeLimits  []*PathEventTimeLimit // a list of exceptions for time limits
 	namespaces  map[string]*Namespace
 	mu          sync.RWMutex
+
+	// policies, extensions and namespaces loaded from db objects, replaced on reload
+	storedPolicies   []*Policy
+	storedExtensions []*Extension
+	storedNamespaces []string
 }
 
 func (manager *Manager) String() string {
@@ -264,6 +270,43 @@ func (manager *Manager) Namespaces() map[string]*Namespace {
 	return m
 }
 
+//Reference describes a property of a schema referencing another schema
+//Cascade is true when referencing resources are deleted together with the referenced one
+type Reference struct {
+	Schema   *Schema
+	Property Property
+	Cascade  bool
+}
+
+//RelationColumn returns a column of the referenced schema
+func (reference Reference) RelationColumn() string {
+	if reference.Property.RelationColumn != "" {
+		return reference.Property.RelationColumn
+	}
+	return "id"
+}
+
+//References returns properties of loaded schemas referencing the schema
+func (manager *Manager) References(target *Schema) []Reference {
+	references := []Reference{}
+	for _, s := range manager.OrderedSchemas() {
+		if s.IsAbstract() {
+			continue
+		}
+		for _, property := range s.Properties {
+			if property.Relation != target.ID {
+				continue
+			}
+			references = append(references, Reference{
+				Schema:   s,
+				Property: property,
+				Cascade:  property.OnDeleteCascade || (property.Relation == s.Parent && s.OnParentDeleteCascade),
+			})
+		}
+	}
+	return references
+}
+
 //LoadResource makes resource from datamap
 func (manager *Manager) LoadResource(schemaID string, dataMap map[string]interface{}) (*Resource, error) {
 	manager.mu.RLock()
@@ -418,6 +461,13 @@ func (manager *Manager) loadSchemaFromFile(filePath string) error {
 		}
 	}
 
+	roles, _ := schemas["roles"].([]interface{})
+	for _, roleData := range roles {
+		if err := manager.roles.AddRole(roleData); err != nil {
+			return err
+		}
+	}
+
 	policies, _ := schemas["policies"].([]interface{})
 	if policies != nil {
 		for _, policyData := range policies {
@@ -465,10 +515,41 @@ func (manager *Manager) LoadPolicies(policies []*Resource) error {
 			return err
 		}
 		manager.policies = append(manager.policies, policy)
+		manager.storedPolicies = append(manager.storedPolicies, policy)
 	}
 	return nil
 }
 
+//ReloadPolicies replaces policies registered by db objects
+//Policies loaded from schema files are kept. Nothing changes if any policy is invalid.
+func (manager *Manager) ReloadPolicies(policies []*Resource) error {
+	loaded := []*Policy{}
+	for _, policyData := range policies {
+		policy, err := NewPolicy(policyData.Data())
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, policy)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	stored := map[*Policy]bool{}
+	for _, policy := range manager.storedPolicies {
+		stored[policy] = true
+	}
+	updated := []*Policy{}
+	for _, policy := range manager.policies {
+		if !stored[policy] {
+			updated = append(updated, policy)
+		}
+	}
+	manager.policies = append(updated, loaded...)
+	manager.storedPolicies = loaded
+	return nil
+}
+
 //LoadExtensions register extension by db object
 func (manager *Manager) LoadExtensions(extensions []*Resource) error {
 	manager.mu.Lock()
@@ -480,10 +561,62 @@ func (manager *Manager) LoadExtensions(extensions []*Resource) error {
 			return err
 		}
 		manager.Extensions = append(manager.Extensions, extension)
+		manager.storedExtensions = append(manager.storedExtensions, extension)
 	}
 	return nil
 }
 
+//ReloadExtensions replaces extensions registered by db objects
+//It returns both old and new versions of added, modified and removed extensions.
+//Extensions loaded from schema files are kept. Nothing changes if any extension is invalid.
+func (manager *Manager) ReloadExtensions(extensions []*Resource) ([]*Extension, error) {
+	loaded := []*Extension{}
+	for _, extensionData := range extensions {
+		extension, err := NewExtension(extensionData.Data())
+		if err != nil {
+			return nil, err
+		}
+		loaded = append(loaded, extension)
+	}
+
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	stored := map[*Extension]bool{}
+	previous := map[string]*Extension{}
+	for _, extension := range manager.storedExtensions {
+		stored[extension] = true
+		previous[extension.ID] = extension
+	}
+	changed := []*Extension{}
+	for _, extension := range loaded {
+		old, ok := previous[extension.ID]
+		delete(previous, extension.ID)
+		if ok && old.equal(extension) {
+			continue
+		}
+		if ok {
+			changed = append(changed, old)
+		}
+		changed = append(changed, extension)
+	}
+	for _, extension := range manager.storedExtensions {
+		if _, removed := previous[extension.ID]; removed {
+			changed = append(changed, extension)
+		}
+	}
+
+	updated := []*Extension{}
+	for _, extension := range manager.Extensions {
+		if !stored[extension] {
+			updated = append(updated, extension)
+		}
+	}
+	manager.Extensions = append(updated, loaded...)
+	manager.storedExtensions = loaded
+	return changed, nil
+}
+
 //LoadNamespaces register namespaces by db object
 func (manager *Manager) LoadNamespaces(namespaces []*Resource) error {
 	manager.mu.Lock()
@@ -495,8 +628,35 @@ func (manager *Manager) LoadNamespaces(namespaces []*Resource) error {
 			return err
 		}
 		manager.registerNamespace(namespace)
+		manager.storedNamespaces = append(manager.storedNamespaces, namespace.ID)
+	}
+
+	return nil
+}
+
+//ReloadNamespaces replaces namespaces registered by db objects
+//Namespaces loaded from schema files are kept.
+func (manager *Manager) ReloadNamespaces(namespaces []*Resource) error {
+	loaded := []*Namespace{}
+	for _, namespaceData := range namespaces {
+		namespace, err := NewNamespace(namespaceData.Data())
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, namespace)
 	}
 
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	for _, namespaceID := range manager.storedNamespaces {
+		delete(manager.namespaces, namespaceID)
+	}
+	manager.storedNamespaces = []string{}
+	for _, namespace := range loaded {
+		manager.registerNamespace(namespace)
+		manager.storedNamespaces = append(manager.storedNamespaces, namespace.ID)
+	}
 	return nil
 }
 
@@ -504,6 +664,7 @@ func (manager *Manager) LoadNamespaces(namespaces []*Resource) error {
 func (manager *Manager) ClearExtensions() {
 	manager.mu.Lock()
 	manager.Extensions = manager.Extensions[:0]
+	manager.storedExtensions = nil
 	manager.mu.Unlock()
 }
 
@@ -568,6 +729,7 @@ func newManager() *Manager {
 		schemaOrder: []string{},
 		namespaces:  map[string]*Namespace{},
 		policies:    []*Policy{},
+		roles:       NewRoleHierarchy(),
 		Extensions:  []*Extension{},
 	}
 }
@@ -589,7 +751,23 @@ func ClearManager() {
 
 //PolicyValidate API request using policy statements
 func (manager *Manager) PolicyValidate(action, path string, auth Authorization) (*Policy, *Role) {
-	return PolicyValidate(action, path, auth, manager.policies)
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return policyValidate(action, path, auth, manager.policies, manager.roles)
+}
+
+//ExplainPolicy explains the decision on API request using policy statements
+func (manager *Manager) ExplainPolicy(action, path string, auth Authorization, data map[string]interface{}) *PolicyExplanation {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return explainPolicy(action, path, auth, manager.policies, manager.roles, data)
+}
+
+//Roles returns the role hierarchy used to match principals of policies
+func (manager *Manager) Roles() *RoleHierarchy {
+	manager.mu.RLock()
+	defer manager.mu.RUnlock()
+	return manager.roles
 }
 
 //NobodyResourcePaths returns a list of paths that do not require authorization
@@ -599,7 +777,7 @@ func (manager *Manager) NobodyResourcePaths() []*regexp.Regexp {
 
 	nobodyResourcePaths := []*regexp.Regexp{}
 	for _, policy := range manager.policies {
-		if policy.Principal == nobodyPrincipal {
+		if policy.Principal == nobodyPrincipal && !policy.IsDeny() {
 			log.Debug("Adding nobody resource path: " + policy.Resource.Path.String())
 			nobodyResourcePaths = append(nobodyResourcePaths, policy.Resource.Path)
 		}
@@ -0,0 +1,143 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"encoding/json"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+var openAPIFormats = []string{"uri", "uuid", "email", "int32", "int64", "float", "double",
+	"byte", "binary", "date", "date-time", "password"}
+
+//FixOpenAPIPropertyTree removes gohan specific keywords and keyword values
+//not supported by swagger and OpenAPI from JSON schema in place
+func FixOpenAPIPropertyTree(node map[string]interface{}) {
+	deleteGohanExtendedProperties(node)
+	fixEnumDefaultValue(node)
+	removeEmptyRequiredList(node)
+	removeNotSupportedFormat(node)
+
+	for _, value := range node {
+		switch childs := value.(type) {
+		case map[string]interface{}:
+			FixOpenAPIPropertyTree(childs)
+		case map[string]map[string]interface{}:
+			for _, value := range childs {
+				FixOpenAPIPropertyTree(value)
+			}
+		}
+	}
+}
+
+//OpenAPISchema returns a copy of JSON schema which can be used in OpenAPI 3 documents
+//Nullable types such as ["string", "null"] are converted to nullable properties
+func OpenAPISchema(jsonSchema interface{}) map[string]interface{} {
+	var copied map[string]interface{}
+	data, err := json.Marshal(jsonSchema)
+	if err != nil {
+		return map[string]interface{}{}
+	}
+	if err := json.Unmarshal(data, &copied); err != nil || copied == nil {
+		return map[string]interface{}{}
+	}
+	FixOpenAPIPropertyTree(copied)
+	fixNullableType(copied)
+	return copied
+}
+
+func deleteGohanExtendedProperties(node map[string]interface{}) {
+	extendedProperties := [...]string{"unique", "permission", "relation",
+		"relation_property", "view", "detail_view", "propertiesOrder",
+		"on_delete_cascade", "indexed", "relationColumn"}
+
+	for _, extendedProperty := range extendedProperties {
+		delete(node, extendedProperty)
+	}
+}
+
+func fixEnumDefaultValue(node map[string]interface{}) {
+	if defaultValue, ok := node["default"]; ok {
+		if enums, ok := node["enum"]; ok {
+			if defaultValueStr, ok := defaultValue.(string); ok {
+				enumsArr := util.MaybeStringList(enums)
+				if !util.ContainsString(enumsArr, defaultValueStr) {
+					delete(node, "default")
+				}
+			}
+		}
+	}
+}
+
+func removeEmptyRequiredList(node map[string]interface{}) {
+	const requiredProperty = "required"
+
+	if required, ok := node[requiredProperty]; ok {
+		switch list := required.(type) {
+		case []string:
+			if len(list) == 0 {
+				delete(node, requiredProperty)
+			}
+		case []interface{}:
+			if len(list) == 0 {
+				delete(node, requiredProperty)
+			}
+		}
+	}
+}
+
+func removeNotSupportedFormat(node map[string]interface{}) {
+	const formatProperty string = "format"
+
+	if format, ok := node[formatProperty]; ok {
+		if format, ok := format.(string); ok {
+			if !util.ContainsString(openAPIFormats, format) {
+				delete(node, formatProperty)
+			}
+		}
+	}
+}
+
+func fixNullableType(node map[string]interface{}) {
+	if types, ok := node["type"].([]interface{}); ok {
+		var nonNull []interface{}
+		for _, t := range types {
+			if t == "null" {
+				node["nullable"] = true
+				continue
+			}
+			nonNull = append(nonNull, t)
+		}
+		if len(nonNull) > 0 {
+			node["type"] = nonNull[0]
+		} else {
+			delete(node, "type")
+		}
+	}
+	for _, value := range node {
+		switch child := value.(type) {
+		case map[string]interface{}:
+			fixNullableType(child)
+		case []interface{}:
+			for _, item := range child {
+				if itemMap, ok := item.(map[string]interface{}); ok {
+					fixNullableType(itemMap)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("OpenAPI", func() {
+	It("should remove gohan keywords from a copy of the schema", func() {
+		original := map[string]interface{}{
+			"type":            "object",
+			"propertiesOrder": []interface{}{"id", "name", "status"},
+			"required":        []interface{}{},
+			"properties": map[string]interface{}{
+				"id": map[string]interface{}{
+					"type":       "string",
+					"format":     "uuid",
+					"permission": []interface{}{"create"},
+					"unique":     true,
+				},
+				"name": map[string]interface{}{
+					"type":   []interface{}{"string", "null"},
+					"format": "mac",
+				},
+				"status": map[string]interface{}{
+					"type":    "string",
+					"enum":    []interface{}{"UP", "DOWN"},
+					"default": "UNKNOWN",
+				},
+			},
+		}
+		result := OpenAPISchema(original)
+
+		Expect(result).NotTo(HaveKey("propertiesOrder"))
+		Expect(result).NotTo(HaveKey("required"))
+		properties := result["properties"].(map[string]interface{})
+		Expect(properties["id"]).To(Equal(map[string]interface{}{"type": "string", "format": "uuid"}))
+		Expect(properties["name"]).To(Equal(map[string]interface{}{"type": "string", "nullable": true}))
+		Expect(properties["status"]).NotTo(HaveKey("default"))
+
+		Expect(original).To(HaveKey("propertiesOrder"))
+		Expect(original["properties"].(map[string]interface{})["id"]).To(HaveKey("permission"))
+	})
+})
@@ -18,6 +18,7 @@ package schema
 import (
 	"fmt"
 	"regexp"
+	"strings"
 
 	"github.com/cloudwan/gohan/util"
 )
@@ -34,13 +35,24 @@ const (
 	// ActionDelete allows to delete a resource
 	ActionDelete = "delete"
 
+	// EffectAllow allows access
+	EffectAllow = "allow"
+	// EffectDeny denies access, overriding allow policies of the same priority
+	EffectDeny = "deny"
+
+	// PrincipalAny matches users with any role
+	PrincipalAny = "*"
+
 	conditionIsOwner       = "is_owner"
+	conditionIsDomainOwner = "is_domain_owner"
 	conditionTypeBelongsTo = "belongs_to"
 	conditionProperty      = "property"
+	conditionAttribute     = "attribute"
 
 	globalRegexp = ".*"
 
 	onlyOneOfTenantIDTenantNameError = "Only one of [tenant_id, tenant_name] should be specified"
+	onlyOneOfDomainIDDomainNameError = "Only one of [domain_id, domain_name] should be specified"
 )
 
 var AllActions = []string{ActionCreate, ActionRead, ActionUpdate, ActionDelete}
@@ -74,14 +86,19 @@ func (t Tenant) String() string {
 //Policy describes policy configuration for APIs
 type Policy struct {
 	ID, Description, Principal, Action, Effect string
+	Priority                                   int
 	Condition                                  []interface{}
 	Resource                                   *ResourcePolicy
 	RawData                                    interface{}
 	TenantID                                   *regexp.Regexp
 	TenantName                                 *regexp.Regexp
+	DomainID                                   *regexp.Regexp
+	DomainName                                 *regexp.Regexp
 	requireOwner                               bool
+	requireDomainOwner                         bool
 	actionTenantFilter                         map[string][]Tenant
 	actionPropertyConditionFilter              map[string][]map[string]interface{}
+	actionAttributeConditionFilter             map[string][]*AttributeCondition
 }
 
 //ResourcePolicy describes targe resources
@@ -94,18 +111,24 @@ type ResourcePolicy struct {
 type Authorization interface {
 	TenantID() string
 	TenantName() string
+	DomainID() string
+	DomainName() string
 	AuthToken() string
 	Roles() []*Role
 	Catalog() []*Catalog
+	Claims() map[string]interface{}
 }
 
 //BaseAuthorization is base struct for Authorization
 type BaseAuthorization struct {
 	tenantID   string
 	tenantName string
+	domainID   string
+	domainName string
 	authToken  string
 	roles      []*Role
 	catalog    []*Catalog
+	claims     map[string]interface{}
 }
 
 //NewAuthorization is a constructor for auth info
@@ -123,6 +146,21 @@ func NewAuthorization(tenantID, tenantName, authToken string, roleIDs []string,
 	}
 }
 
+//NewDomainAuthorization is a constructor for auth info scoped to a project of a domain
+func NewDomainAuthorization(tenantID, tenantName, domainID, domainName, authToken string, roleIDs []string, catalog []*Catalog) Authorization {
+	auth := NewAuthorization(tenantID, tenantName, authToken, roleIDs, catalog).(*BaseAuthorization)
+	auth.domainID = domainID
+	auth.domainName = domainName
+	return auth
+}
+
+//NewAuthorizationWithClaims is a constructor for auth info with token claims
+func NewAuthorizationWithClaims(tenantID, tenantName, authToken string, roleIDs []string, catalog []*Catalog, claims map[string]interface{}) Authorization {
+	auth := NewAuthorization(tenantID, tenantName, authToken, roleIDs, catalog).(*BaseAuthorization)
+	auth.claims = claims
+	return auth
+}
+
 //Roles returns authorized roles
 func (auth *BaseAuthorization) Roles() []*Role {
 	return auth.roles
@@ -138,6 +176,16 @@ func (auth *BaseAuthorization) TenantName() string {
 	return auth.tenantName
 }
 
+//DomainID returns authorized domain
+func (auth *BaseAuthorization) DomainID() string {
+	return auth.domainID
+}
+
+//DomainName returns authorized domain name
+func (auth *BaseAuthorization) DomainName() string {
+	return auth.domainName
+}
+
 //AuthToken returns X_AUTH_TOKEN
 func (auth *BaseAuthorization) AuthToken() string {
 	return auth.authToken
@@ -148,6 +196,11 @@ func (auth *BaseAuthorization) Catalog() []*Catalog {
 	return auth.catalog
 }
 
+//Claims returns claims of the token, nil if the token has no claims
+func (auth *BaseAuthorization) Claims() map[string]interface{} {
+	return auth.claims
+}
+
 //Role describes user role
 type Role struct {
 	Name string
@@ -191,6 +244,22 @@ func NewPolicy(raw interface{}) (*Policy, error) {
 	policy.Principal, _ = typeData["principal"].(string)
 	policy.Action, _ = typeData["action"].(string)
 	policy.Effect, _ = typeData["effect"].(string)
+	switch strings.ToLower(policy.Effect) {
+	case "", EffectAllow:
+		policy.Effect = EffectAllow
+	case EffectDeny:
+		policy.Effect = EffectDeny
+	default:
+		return nil, fmt.Errorf("Unknown effect '%s' for policy '%s'", policy.Effect, policy.ID)
+	}
+	switch priority := typeData["priority"].(type) {
+	case int:
+		policy.Priority = priority
+	case int64:
+		policy.Priority = int(priority)
+	case float64:
+		policy.Priority = int(priority)
+	}
 	policy.Condition, _ = typeData["condition"].([]interface{})
 	policy.RawData = raw
 	resourceData, _ := typeData["resource"].(map[string]interface{})
@@ -221,6 +290,24 @@ func NewPolicy(raw interface{}) (*Policy, error) {
 		return nil, fmt.Errorf(onlyOneOfTenantIDTenantNameError)
 	}
 
+	rawDomainID, _ := typeData["domain_id"].(string)
+	domainID, err := getRegexp(rawDomainID)
+	if err != nil {
+		return nil, err
+	}
+	policy.DomainID = domainID
+
+	rawDomainName, _ := typeData["domain_name"].(string)
+	domainName, err := getRegexp(rawDomainName)
+	if err != nil {
+		return nil, err
+	}
+	policy.DomainName = domainName
+
+	if domainName.String() != globalRegexp && domainID.String() != globalRegexp {
+		return nil, fmt.Errorf(onlyOneOfDomainIDDomainNameError)
+	}
+
 	properties, ok := resourceData["properties"]
 	resource.Properties = nil
 	if ok {
@@ -235,12 +322,15 @@ func NewPolicy(raw interface{}) (*Policy, error) {
 func (p *Policy) precomputeConditions() error {
 	p.actionTenantFilter = map[string][]Tenant{}
 	p.actionPropertyConditionFilter = map[string][]map[string]interface{}{}
+	p.actionAttributeConditionFilter = map[string][]*AttributeCondition{}
 	for _, condition := range p.Condition {
 		switch condition.(type) {
 		case string:
 			switch condition {
 			case conditionIsOwner:
 				p.requireOwner = true
+			case conditionIsDomainOwner:
+				p.requireDomainOwner = true
 			default:
 				return fmt.Errorf("Unknown condition '%s' for policy '%s'", condition, p.ID)
 			}
@@ -283,6 +373,18 @@ func (p *Policy) precomputeConditions() error {
 				for _, action := range actions {
 					p.AddPropertyConditionFilter(action, match)
 				}
+			case conditionAttribute:
+				actions := AllActions
+				if action, ok := conditionObject["action"]; ok && action != ActionGlob {
+					actions = []string{action.(string)}
+				}
+				attributeCondition, err := NewAttributeCondition(conditionObject["match"])
+				if err != nil {
+					return fmt.Errorf("Invalid attribute condition for policy '%s': %s", p.ID, err)
+				}
+				for _, action := range actions {
+					p.AddAttributeConditionFilter(action, attributeCondition)
+				}
 			default:
 				return fmt.Errorf("Unknown condition type '%s' for policy '%s'", conditionObject["type"], p.ID)
 			}
@@ -299,33 +401,49 @@ func NewEmptyPolicy() *Policy {
 	return &Policy{Resource: &ResourcePolicy{}}
 }
 
-func (p *Policy) match(action, path string, auth Authorization) *Role {
+func (p *Policy) match(action, path string, auth Authorization, roles *RoleHierarchy) *Role {
+	role, _ := p.matchCriterion(action, path, auth, roles)
+	return role
+}
+
+//matchCriterion returns the matching role, or the name of the first criterion the request fails
+//A role matches if it is the principal or implies it in the role hierarchy.
+//Any role matches the "*" principal.
+func (p *Policy) matchCriterion(action, path string, auth Authorization, roles *RoleHierarchy) (*Role, string) {
 	if p.Action != "*" && action != p.Action {
-		return nil
+		return nil, "action"
 	}
 	if !p.Resource.Path.MatchString(path) {
-		return nil
+		return nil, "path"
 	}
 
 	if !p.TenantID.MatchString(auth.TenantID()) {
-		return nil
+		return nil, "tenant_id"
 	}
 
 	if !p.TenantName.MatchString(auth.TenantName()) {
-		return nil
+		return nil, "tenant_name"
+	}
+
+	if !p.DomainID.MatchString(auth.DomainID()) {
+		return nil, "domain_id"
+	}
+
+	if !p.DomainName.MatchString(auth.DomainName()) {
+		return nil, "domain_name"
 	}
 
-	roles := auth.Roles()
-	for _, role := range roles {
-		if role.Match(p.Principal) {
-			return role
+	for _, role := range auth.Roles() {
+		if p.Principal == PrincipalAny || role.Match(p.Principal) || roles.Grants(role.Name, p.Principal) != nil {
+			return role, ""
 		}
 	}
-	return nil
+	return nil, "principal"
 }
 
-func (p *Policy) isAllow() bool {
-	return p.Effect == "Allow"
+//IsDeny checks if the policy denies access
+func (p *Policy) IsDeny() bool {
+	return p.Effect == EffectDeny
 }
 
 //RequireOwner ...
@@ -333,6 +451,11 @@ func (p *Policy) RequireOwner() bool {
 	return p.requireOwner
 }
 
+//RequireDomainOwner checks if access is limited to resources of the caller's domain
+func (p *Policy) RequireDomainOwner() bool {
+	return p.requireDomainOwner
+}
+
 //RemoveHiddenProperty removes hidden data from data by Policy
 // This method returns nil if all data get filtered out
 func (p *Policy) RemoveHiddenProperty(data map[string]interface{}) map[string]interface{} {
@@ -408,6 +531,9 @@ func (p *Policy) Check(action string, authorization Authorization, data map[stri
 			return fmt.Errorf("Tenant '%s' is prohibited from operating on resources of tenant '%s'", caller, owner)
 		}
 	}
+	if err := p.checkDomainOwner(authorization, data, false); err != nil {
+		return err
+	}
 
 	properties := p.Resource.Properties
 	if properties == nil {
@@ -436,6 +562,34 @@ func (p *Policy) AddPropertyConditionFilter(action string, match map[string]inte
 	p.actionPropertyConditionFilter[action] = append(p.actionPropertyConditionFilter[action], match)
 }
 
+// AddAttributeConditionFilter adds attribute based filter for action
+func (p *Policy) AddAttributeConditionFilter(action string, condition *AttributeCondition) {
+	p.actionAttributeConditionFilter[action] = append(p.actionAttributeConditionFilter[action], condition)
+}
+
+// ApplyConditionFilter applies property and attribute based filters for the action
+// performed by the caller. Parameters are the same as in ApplyPropertyConditionFilter.
+func (p *Policy) ApplyConditionFilter(action string, auth Authorization, data map[string]interface{}, updateCandidateData map[string]interface{}) error {
+	if err := p.checkDomainOwner(auth, data, true); err != nil {
+		return err
+	}
+	if err := p.ApplyPropertyConditionFilter(action, data, updateCandidateData); err != nil {
+		return err
+	}
+	return p.ApplyAttributeConditionFilter(action, auth, data, updateCandidateData)
+}
+
+// ApplyAttributeConditionFilter applies filter based on attribute conditions
+// All conditions of the action have to be satisfied by the resource and the caller.
+func (p *Policy) ApplyAttributeConditionFilter(action string, auth Authorization, data map[string]interface{}, updateCandidateData map[string]interface{}) error {
+	for _, condition := range p.actionAttributeConditionFilter[action] {
+		if err := condition.Evaluate(auth, data, updateCandidateData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ApplyPropertyConditionFilter applies filter based on Property
 // You need to pass candidate update value in updateCandidateData on update API, so
 // that we can limit allowed update value.
@@ -513,6 +667,27 @@ func (p *Policy) GetTenantFilter(action string, tenant Tenant) []Tenant {
 	return append(p.actionTenantFilter[action], tenant)
 }
 
+// GetDomainIDFilter returns domains filter for the caller's domain, nil if access isn't limited to a domain
+func (p *Policy) GetDomainIDFilter(domainID string) []string {
+	if !p.requireDomainOwner {
+		return nil
+	}
+	return []string{domainID}
+}
+
+//checkDomainOwner checks if the resource belongs to the caller's domain
+//If strict is false, resources without domain_id, e.g. update requests, pass.
+func (p *Policy) checkDomainOwner(auth Authorization, data map[string]interface{}, strict bool) error {
+	if !p.requireDomainOwner || auth == nil {
+		return nil
+	}
+	domainID, ok := data["domain_id"]
+	if (!ok && !strict) || domainID == auth.DomainID() {
+		return nil
+	}
+	return fmt.Errorf("Domain '%s' is prohibited from operating on resources of domain '%v'", auth.DomainID(), domainID)
+}
+
 func (p *Policy) isTenantAllowed(action string, owner, tenant Tenant) bool {
 	for _, allowedTenant := range p.GetTenantFilter(action, tenant) {
 		if owner.equal(allowedTenant) {
@@ -523,13 +698,38 @@ func (p *Policy) isTenantAllowed(action string, owner, tenant Tenant) bool {
 }
 
 //PolicyValidate validates api request using policy validation
+//Only policies with the highest priority among matching ones are considered.
+//Among them deny overrides allow, otherwise the first matching allow policy is returned.
+//nil is returned if access is denied or no policy matches.
 func PolicyValidate(action, path string, auth Authorization, policies []*Policy) (*Policy, *Role) {
+	return policyValidate(action, path, auth, policies, nil)
+}
+
+//policyValidate validates api request expanding user's roles with the role hierarchy
+func policyValidate(action, path string, auth Authorization, policies []*Policy, roles *RoleHierarchy) (*Policy, *Role) {
+	var allowed *Policy
+	var allowedRole *Role
+	matched, denied := false, false
+	priority := 0
 	for _, policy := range policies {
-		if role := policy.match(action, path, auth); role != nil {
-			return policy, role
+		role := policy.match(action, path, auth, roles)
+		if role == nil || (matched && policy.Priority < priority) {
+			continue
+		}
+		if !matched || policy.Priority > priority {
+			allowed, allowedRole, denied = nil, nil, false
+			matched, priority = true, policy.Priority
 		}
+		if policy.IsDeny() {
+			denied = true
+		} else if allowed == nil {
+			allowed, allowedRole = policy, role
+		}
+	}
+	if denied {
+		return nil, nil
 	}
-	return nil, nil
+	return allowed, allowedRole
 }
 
 func getRegexp(input string) (*regexp.Regexp, error) {
@@ -0,0 +1,506 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+const (
+	operandProperty    = "property"
+	operandNewProperty = "new_property"
+	operandCaller      = "caller"
+
+	callerTenantID     = "tenant_id"
+	callerTenantName   = "tenant_name"
+	callerDomainID     = "domain_id"
+	callerDomainName   = "domain_name"
+	callerRoles        = "roles"
+	callerClaimsPrefix = "claims."
+)
+
+var (
+	operandKeys    = []string{operandProperty, operandNewProperty, operandCaller}
+	comparisonKeys = []string{"eq", "ne", "lt", "le", "gt", "ge", "regex", "in", "not_in", "contains"}
+
+	weekdays = map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+
+	//timeNow returns current time used by time windows, replaced in tests
+	timeNow = time.Now
+)
+
+//attributeEnv holds values attribute conditions are evaluated against
+type attributeEnv struct {
+	auth                Authorization
+	data                map[string]interface{}
+	updateCandidateData map[string]interface{}
+	now                 time.Time
+}
+
+//AttributeCondition is an attribute based condition of a policy
+//
+//   - type: attribute
+//     action: update
+//     match:
+//       all:
+//       - property: status
+//         in: [ACTIVE, ERROR]
+//       - property: owner
+//         eq:
+//           caller: claims.sub
+//       - time:
+//           from: "09:00"
+//           to: "17:00"
+type AttributeCondition struct {
+	//Raw is the condition as defined in the policy
+	Raw        interface{}
+	expression attributeExpression
+}
+
+//NewAttributeCondition parses attribute condition expression
+func NewAttributeCondition(raw interface{}) (*AttributeCondition, error) {
+	expression, err := parseAttributeExpression(raw)
+	if err != nil {
+		return nil, err
+	}
+	return &AttributeCondition{Raw: raw, expression: expression}, nil
+}
+
+//Evaluate checks the condition for the caller against a resource
+//updateCandidateData holds update candidate values on update, nil otherwise.
+func (c *AttributeCondition) Evaluate(auth Authorization, data, updateCandidateData map[string]interface{}) error {
+	env := &attributeEnv{auth: auth, data: data, updateCandidateData: updateCandidateData, now: timeNow()}
+	ok, err := c.expression.evaluate(env)
+	if err != nil {
+		return fmt.Errorf("Rejected by attribute condition %v: %s", c.Raw, err)
+	}
+	if !ok {
+		return fmt.Errorf("Rejected by attribute condition %v", c.Raw)
+	}
+	return nil
+}
+
+type attributeExpression interface {
+	evaluate(env *attributeEnv) (bool, error)
+}
+
+func parseAttributeExpression(raw interface{}) (attributeExpression, error) {
+	if list, ok := raw.([]interface{}); ok {
+		return parseAttributeExpressionList(list)
+	}
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("condition should be dict or list, got %v", raw)
+	}
+	if all, ok := object["all"]; ok {
+		list, ok := all.([]interface{})
+		if !ok || len(object) != 1 {
+			return nil, fmt.Errorf("all should be the only key with a list")
+		}
+		return parseAttributeExpressionList(list)
+	}
+	if any, ok := object["any"]; ok {
+		list, ok := any.([]interface{})
+		if !ok || len(object) != 1 {
+			return nil, fmt.Errorf("any should be the only key with a list")
+		}
+		expressions, err := parseAttributeExpressionList(list)
+		if err != nil {
+			return nil, err
+		}
+		return anyExpression(expressions), nil
+	}
+	if not, ok := object["not"]; ok {
+		if len(object) != 1 {
+			return nil, fmt.Errorf("not should be the only key")
+		}
+		expression, err := parseAttributeExpression(not)
+		if err != nil {
+			return nil, err
+		}
+		return notExpression{expression}, nil
+	}
+	if window, ok := object["time"]; ok {
+		if len(object) != 1 {
+			return nil, fmt.Errorf("time should be the only key")
+		}
+		return newTimeWindowExpression(window)
+	}
+	return newComparisonExpression(object)
+}
+
+func parseAttributeExpressionList(list []interface{}) (allExpression, error) {
+	expressions := allExpression{}
+	for _, item := range list {
+		expression, err := parseAttributeExpression(item)
+		if err != nil {
+			return nil, err
+		}
+		expressions = append(expressions, expression)
+	}
+	return expressions, nil
+}
+
+type allExpression []attributeExpression
+
+func (e allExpression) evaluate(env *attributeEnv) (bool, error) {
+	for _, expression := range e {
+		if ok, err := expression.evaluate(env); !ok || err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+type anyExpression []attributeExpression
+
+func (e anyExpression) evaluate(env *attributeEnv) (bool, error) {
+	for _, expression := range e {
+		if ok, _ := expression.evaluate(env); ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type notExpression struct {
+	expression attributeExpression
+}
+
+func (e notExpression) evaluate(env *attributeEnv) (bool, error) {
+	ok, err := e.expression.evaluate(env)
+	if err != nil {
+		return false, err
+	}
+	return !ok, nil
+}
+
+//attributeOperand is either a literal value or a reference to a property or caller's attribute
+type attributeOperand struct {
+	source string
+	name   string
+	value  interface{}
+}
+
+func newAttributeReference(source string, rawName interface{}) (*attributeOperand, error) {
+	name, ok := rawName.(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("%s should be a name", source)
+	}
+	if source == operandCaller {
+		switch {
+		case name == callerTenantID, name == callerTenantName, name == callerRoles:
+		case name == callerDomainID, name == callerDomainName:
+		case strings.HasPrefix(name, callerClaimsPrefix) && len(name) > len(callerClaimsPrefix):
+		default:
+			return nil, fmt.Errorf("Unknown caller attribute '%s'", name)
+		}
+	}
+	return &attributeOperand{source: source, name: name}, nil
+}
+
+func newAttributeOperand(raw interface{}) (*attributeOperand, error) {
+	if object, ok := raw.(map[string]interface{}); ok && len(object) == 1 {
+		for _, source := range operandKeys {
+			if name, ok := object[source]; ok {
+				return newAttributeReference(source, name)
+			}
+		}
+	}
+	return &attributeOperand{value: raw}, nil
+}
+
+func (o *attributeOperand) resolve(env *attributeEnv) interface{} {
+	switch o.source {
+	case operandProperty:
+		return lookupPath(env.data, o.name)
+	case operandNewProperty:
+		if value := lookupPath(env.updateCandidateData, o.name); value != nil {
+			return value
+		}
+		return lookupPath(env.data, o.name)
+	case operandCaller:
+		if env.auth == nil {
+			return nil
+		}
+		switch o.name {
+		case callerTenantID:
+			return env.auth.TenantID()
+		case callerTenantName:
+			return env.auth.TenantName()
+		case callerDomainID:
+			return env.auth.DomainID()
+		case callerDomainName:
+			return env.auth.DomainName()
+		case callerRoles:
+			roles := []interface{}{}
+			for _, role := range env.auth.Roles() {
+				roles = append(roles, role.Name)
+			}
+			return roles
+		default:
+			return lookupPath(env.auth.Claims(), strings.TrimPrefix(o.name, callerClaimsPrefix))
+		}
+	}
+	return o.value
+}
+
+func lookupPath(data map[string]interface{}, path string) interface{} {
+	var value interface{} = data
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[key]
+	}
+	return value
+}
+
+type comparisonExpression struct {
+	left, right *attributeOperand
+	operator    string
+	regexp      *regexp.Regexp
+}
+
+func newComparisonExpression(object map[string]interface{}) (attributeExpression, error) {
+	expression := &comparisonExpression{}
+	for key, value := range object {
+		switch {
+		case util.ContainsString(operandKeys, key):
+			if expression.left != nil {
+				return nil, fmt.Errorf("only one of %v should be specified", operandKeys)
+			}
+			left, err := newAttributeReference(key, value)
+			if err != nil {
+				return nil, err
+			}
+			expression.left = left
+		case util.ContainsString(comparisonKeys, key):
+			if expression.right != nil {
+				return nil, fmt.Errorf("only one of %v should be specified", comparisonKeys)
+			}
+			right, err := newAttributeOperand(value)
+			if err != nil {
+				return nil, err
+			}
+			expression.operator, expression.right = key, right
+		default:
+			return nil, fmt.Errorf("Unknown key '%s' in condition", key)
+		}
+	}
+	if expression.left == nil {
+		return nil, fmt.Errorf("one of %v should be specified", operandKeys)
+	}
+	if expression.right == nil {
+		return nil, fmt.Errorf("one of %v should be specified", comparisonKeys)
+	}
+	switch expression.operator {
+	case "regex":
+		pattern, ok := expression.right.value.(string)
+		if !ok || expression.right.source != "" {
+			return nil, fmt.Errorf("regex should be a string")
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		expression.regexp = compiled
+	case "in", "not_in":
+		if _, ok := expression.right.value.([]interface{}); !ok && expression.right.source == "" {
+			return nil, fmt.Errorf("%s should be a list", expression.operator)
+		}
+	}
+	return expression, nil
+}
+
+func (e *comparisonExpression) evaluate(env *attributeEnv) (bool, error) {
+	left := e.left.resolve(env)
+	right := e.right.resolve(env)
+	switch e.operator {
+	case "eq":
+		return attributeEqual(left, right), nil
+	case "ne":
+		return !attributeEqual(left, right), nil
+	case "lt", "le", "gt", "ge":
+		if left == nil || right == nil {
+			return false, nil
+		}
+		result, err := attributeCompare(left, right)
+		if err != nil {
+			return false, err
+		}
+		switch e.operator {
+		case "lt":
+			return result < 0, nil
+		case "le":
+			return result <= 0, nil
+		case "gt":
+			return result > 0, nil
+		default:
+			return result >= 0, nil
+		}
+	case "regex":
+		value, ok := left.(string)
+		return ok && e.regexp.MatchString(value), nil
+	case "in":
+		return attributeContains(right, left), nil
+	case "not_in":
+		return !attributeContains(right, left), nil
+	case "contains":
+		if value, ok := left.(string); ok {
+			substring, ok := right.(string)
+			return ok && strings.Contains(value, substring), nil
+		}
+		return attributeContains(left, right), nil
+	}
+	return false, fmt.Errorf("Unknown operator '%s'", e.operator)
+}
+
+func attributeNumber(value interface{}) (float64, bool) {
+	switch value := value.(type) {
+	case int:
+		return float64(value), true
+	case int64:
+		return float64(value), true
+	case float64:
+		return value, true
+	}
+	return 0, false
+}
+
+func attributeEqual(a, b interface{}) bool {
+	if x, ok := attributeNumber(a); ok {
+		y, ok := attributeNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func attributeCompare(a, b interface{}) (int, error) {
+	if x, ok := attributeNumber(a); ok {
+		if y, ok := attributeNumber(b); ok {
+			switch {
+			case x < y:
+				return -1, nil
+			case x > y:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	x, okA := a.(string)
+	y, okB := b.(string)
+	if !okA || !okB {
+		return 0, fmt.Errorf("Can't compare %v with %v", a, b)
+	}
+	return strings.Compare(x, y), nil
+}
+
+func attributeContains(list, value interface{}) bool {
+	items, ok := list.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, item := range items {
+		if attributeEqual(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+//timeWindowExpression matches if current time of day is in [from, to)
+//The window may span midnight, e.g. from 22:00 to 06:00.
+type timeWindowExpression struct {
+	from, to time.Duration
+	location *time.Location
+	days     map[time.Weekday]bool
+}
+
+func newTimeWindowExpression(raw interface{}) (attributeExpression, error) {
+	object, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("time should be dict")
+	}
+	expression := &timeWindowExpression{location: time.UTC}
+	var err error
+	if expression.from, err = parseTimeOfDay(object["from"], 0); err != nil {
+		return nil, err
+	}
+	if expression.to, err = parseTimeOfDay(object["to"], 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if rawTimezone, ok := object["timezone"]; ok {
+		timezone, _ := rawTimezone.(string)
+		if expression.location, err = time.LoadLocation(timezone); err != nil || timezone == "" {
+			return nil, fmt.Errorf("Invalid timezone '%v'", rawTimezone)
+		}
+	}
+	if rawDays, ok := object["days"]; ok {
+		days, err := stringList(rawDays)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid days: %s", err)
+		}
+		expression.days = map[time.Weekday]bool{}
+		for _, day := range days {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("Invalid day '%s'", day)
+			}
+			expression.days[weekday] = true
+		}
+	}
+	for key := range object {
+		if !util.ContainsString([]string{"from", "to", "timezone", "days"}, key) {
+			return nil, fmt.Errorf("Unknown key '%s' in time condition", key)
+		}
+	}
+	return expression, nil
+}
+
+func parseTimeOfDay(raw interface{}, defaultValue time.Duration) (time.Duration, error) {
+	if raw == nil {
+		return defaultValue, nil
+	}
+	value, _ := raw.(string)
+	parsed, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day '%v', expected HH:MM", raw)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+func (e *timeWindowExpression) evaluate(env *attributeEnv) (bool, error) {
+	now := env.now.In(e.location)
+	if e.days != nil && !e.days[now.Weekday()] {
+		return false, nil
+	}
+	timeOfDay := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if e.from <= e.to {
+		return e.from <= timeOfDay && timeOfDay < e.to, nil
+	}
+	return timeOfDay >= e.from || timeOfDay < e.to, nil
+}
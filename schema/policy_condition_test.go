@@ -0,0 +1,240 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Attribute conditions", func() {
+	var (
+		auth     Authorization
+		resource map[string]interface{}
+	)
+
+	BeforeEach(func() {
+		auth = NewAuthorizationWithClaims("tenant", "tenant-name", "token", []string{"Member", "netadmin"}, nil,
+			map[string]interface{}{
+				"sub":        "alice",
+				"department": map[string]interface{}{"name": "network"},
+			})
+		resource = map[string]interface{}{
+			"id":        "net",
+			"name":      "net-red",
+			"tenant_id": "tenant",
+			"owner":     "alice",
+			"status":    "ACTIVE",
+			"mtu":       float64(1500),
+			"tags":      []interface{}{"prod", "red"},
+			"config":    map[string]interface{}{"shared": true},
+		}
+	})
+
+	AfterEach(func() {
+		timeNow = time.Now
+	})
+
+	evaluate := func(raw interface{}) error {
+		condition, err := NewAttributeCondition(raw)
+		Expect(err).ToNot(HaveOccurred())
+		return condition.Evaluate(auth, resource, nil)
+	}
+
+	DescribeTable("evaluates",
+		func(raw map[string]interface{}, expected bool) {
+			if expected {
+				Expect(evaluate(raw)).To(Succeed())
+			} else {
+				Expect(evaluate(raw)).NotTo(Succeed())
+			}
+		},
+		Entry("eq", map[string]interface{}{"property": "status", "eq": "ACTIVE"}, true),
+		Entry("eq mismatch", map[string]interface{}{"property": "status", "eq": "ERROR"}, false),
+		Entry("ne", map[string]interface{}{"property": "status", "ne": "ERROR"}, true),
+		Entry("eq of numbers", map[string]interface{}{"property": "mtu", "eq": 1500}, true),
+		Entry("nested property", map[string]interface{}{"property": "config.shared", "eq": true}, true),
+		Entry("lt", map[string]interface{}{"property": "mtu", "lt": 9000}, true),
+		Entry("ge", map[string]interface{}{"property": "mtu", "ge": 9000}, false),
+		Entry("string comparison", map[string]interface{}{"property": "name", "gt": "net-a"}, true),
+		Entry("comparison with missing value", map[string]interface{}{"property": "missing", "lt": 1}, false),
+		Entry("comparison of different types", map[string]interface{}{"property": "name", "lt": 1}, false),
+		Entry("regex", map[string]interface{}{"property": "name", "regex": "^net-"}, true),
+		Entry("regex mismatch", map[string]interface{}{"property": "name", "regex": "^vm-"}, false),
+		Entry("in", map[string]interface{}{"property": "status", "in": []interface{}{"ACTIVE", "ERROR"}}, true),
+		Entry("not_in", map[string]interface{}{"property": "status", "not_in": []interface{}{"ACTIVE"}}, false),
+		Entry("contains", map[string]interface{}{"property": "tags", "contains": "prod"}, true),
+		Entry("contains substring", map[string]interface{}{"property": "name", "contains": "red"}, true),
+		Entry("caller tenant", map[string]interface{}{"property": "tenant_id", "eq": map[string]interface{}{"caller": "tenant_id"}}, true),
+		Entry("caller tenant name", map[string]interface{}{"caller": "tenant_name", "eq": "other"}, false),
+		Entry("caller roles", map[string]interface{}{"caller": "roles", "contains": "netadmin"}, true),
+		Entry("caller claim", map[string]interface{}{"property": "owner", "eq": map[string]interface{}{"caller": "claims.sub"}}, true),
+		Entry("nested caller claim", map[string]interface{}{"caller": "claims.department.name", "in": []interface{}{"network"}}, true),
+		Entry("property in caller roles", map[string]interface{}{"property": "status", "in": map[string]interface{}{"caller": "roles"}}, false),
+		Entry("all", map[string]interface{}{"all": []interface{}{
+			map[string]interface{}{"property": "status", "eq": "ACTIVE"},
+			map[string]interface{}{"property": "mtu", "eq": 9000},
+		}}, false),
+		Entry("any", map[string]interface{}{"any": []interface{}{
+			map[string]interface{}{"property": "status", "eq": "ERROR"},
+			map[string]interface{}{"caller": "roles", "contains": "netadmin"},
+		}}, true),
+		Entry("not", map[string]interface{}{"not": map[string]interface{}{"property": "status", "eq": "ERROR"}}, true),
+	)
+
+	It("treats a list as all", func() {
+		Expect(evaluate([]interface{}{
+			map[string]interface{}{"property": "status", "eq": "ACTIVE"},
+			map[string]interface{}{"property": "owner", "eq": "bob"},
+		})).NotTo(Succeed())
+	})
+
+	It("compares update candidate values", func() {
+		condition, err := NewAttributeCondition(map[string]interface{}{
+			"new_property": "mtu", "le": map[string]interface{}{"property": "mtu"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(condition.Evaluate(auth, resource, map[string]interface{}{"mtu": 1400})).To(Succeed())
+		Expect(condition.Evaluate(auth, resource, map[string]interface{}{"mtu": 9000})).NotTo(Succeed())
+		Expect(condition.Evaluate(auth, resource, map[string]interface{}{"name": "net-blue"})).To(Succeed())
+	})
+
+	Describe("Time windows", func() {
+		at := func(value string) {
+			now, err := time.Parse(time.RFC3339, value)
+			Expect(err).ToNot(HaveOccurred())
+			timeNow = func() time.Time { return now }
+		}
+
+		window := func(raw map[string]interface{}) error {
+			return evaluate(map[string]interface{}{"time": raw})
+		}
+
+		It("matches time of day", func() {
+			working := map[string]interface{}{"from": "09:00", "to": "17:00"}
+			at("2017-03-01T08:59:00Z")
+			Expect(window(working)).NotTo(Succeed())
+			at("2017-03-01T09:00:00Z")
+			Expect(window(working)).To(Succeed())
+			at("2017-03-01T17:00:00Z")
+			Expect(window(working)).NotTo(Succeed())
+		})
+
+		It("matches windows spanning midnight", func() {
+			night := map[string]interface{}{"from": "22:00", "to": "06:00"}
+			at("2017-03-01T23:30:00Z")
+			Expect(window(night)).To(Succeed())
+			at("2017-03-01T05:59:00Z")
+			Expect(window(night)).To(Succeed())
+			at("2017-03-01T12:00:00Z")
+			Expect(window(night)).NotTo(Succeed())
+		})
+
+		It("uses timezone and days", func() {
+			at("2017-03-01T01:30:00Z")
+			Expect(window(map[string]interface{}{"from": "09:00", "to": "17:00", "timezone": "Asia/Tokyo"})).To(Succeed())
+			Expect(window(map[string]interface{}{"from": "09:00", "to": "17:00"})).NotTo(Succeed())
+			Expect(window(map[string]interface{}{"days": []interface{}{"wed"}})).To(Succeed())
+			Expect(window(map[string]interface{}{"days": []interface{}{"mon", "tue"}})).NotTo(Succeed())
+		})
+	})
+
+	DescribeTable("rejects invalid conditions",
+		func(raw interface{}) {
+			_, err := NewAttributeCondition(raw)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("not a dict", "status"),
+		Entry("no operand", map[string]interface{}{"eq": "ACTIVE"}),
+		Entry("no operator", map[string]interface{}{"property": "status"}),
+		Entry("two operators", map[string]interface{}{"property": "status", "eq": "ACTIVE", "ne": "ERROR"}),
+		Entry("unknown key", map[string]interface{}{"property": "status", "like": "ACT%"}),
+		Entry("unknown caller attribute", map[string]interface{}{"caller": "password", "eq": "secret"}),
+		Entry("invalid regex", map[string]interface{}{"property": "name", "regex": "("}),
+		Entry("in without list", map[string]interface{}{"property": "status", "in": "ACTIVE"}),
+		Entry("invalid time", map[string]interface{}{"time": map[string]interface{}{"from": "9am"}}),
+		Entry("invalid timezone", map[string]interface{}{"time": map[string]interface{}{"timezone": "Mars/Olympus"}}),
+		Entry("invalid day", map[string]interface{}{"time": map[string]interface{}{"days": []interface{}{"someday"}}}),
+	)
+
+	Describe("Policy", func() {
+		var policy *Policy
+
+		BeforeEach(func() {
+			var err error
+			policy, err = NewPolicy(map[string]interface{}{
+				"id":        "member_networks",
+				"principal": "Member",
+				"action":    "*",
+				"resource":  map[string]interface{}{"path": "/v2.0/networks.*"},
+				"condition": []interface{}{
+					map[string]interface{}{
+						"type": "attribute",
+						"match": map[string]interface{}{
+							"property": "owner", "eq": map[string]interface{}{"caller": "claims.sub"},
+						},
+					},
+					map[string]interface{}{
+						"type":   "attribute",
+						"action": "update",
+						"match": map[string]interface{}{
+							"new_property": "status", "in": []interface{}{"ACTIVE", "DOWN"},
+						},
+					},
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("applies conditions of the action", func() {
+			Expect(policy.ApplyConditionFilter("read", auth, resource, nil)).To(Succeed())
+			resource["owner"] = "bob"
+			Expect(policy.ApplyConditionFilter("read", auth, resource, nil)).NotTo(Succeed())
+		})
+
+		It("checks update candidate values", func() {
+			Expect(policy.ApplyConditionFilter("update", auth, resource, map[string]interface{}{"status": "DOWN"})).To(Succeed())
+			Expect(policy.ApplyConditionFilter("update", auth, resource, map[string]interface{}{"status": "ERROR"})).NotTo(Succeed())
+			Expect(policy.ApplyConditionFilter("read", auth, resource, map[string]interface{}{"status": "ERROR"})).To(Succeed())
+		})
+
+		It("explains conditions", func() {
+			resource["owner"] = "bob"
+			explanation := ExplainPolicy("read", "/v2.0/networks/net", auth, []*Policy{policy}, resource)
+			Expect(explanation.Decision).To(Equal(EffectDeny))
+			Expect(explanation.AttributeFilter).To(HaveLen(1))
+			conditions := explanation.Policies[0].Conditions
+			Expect(conditions).To(HaveLen(1))
+			Expect(conditions[0].Type).To(Equal("attribute"))
+			Expect(conditions[0].Passed).To(BeFalse())
+		})
+
+		It("rejects invalid condition", func() {
+			_, err := NewPolicy(map[string]interface{}{
+				"id":        "invalid",
+				"principal": "Member",
+				"resource":  map[string]interface{}{"path": ".*"},
+				"condition": []interface{}{
+					map[string]interface{}{"type": "attribute", "match": map[string]interface{}{"property": "status"}},
+				},
+			})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,224 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import "fmt"
+
+const failedOnPriority = "priority"
+
+//PolicyExplanation describes how a policy decision was made
+type PolicyExplanation struct {
+	Action     string   `json:"action"`
+	Path       string   `json:"path"`
+	TenantID   string   `json:"tenant_id"`
+	TenantName string   `json:"tenant_name"`
+	DomainID   string   `json:"domain_id,omitempty"`
+	DomainName string   `json:"domain_name,omitempty"`
+	Roles      []string `json:"roles"`
+	//EffectiveRoles lists user's roles together with roles they imply
+	EffectiveRoles []string `json:"effective_roles"`
+	//Policies lists evaluation of every considered policy in order
+	Policies []*PolicyEvaluation `json:"policies"`
+	//Decision is either EffectAllow or EffectDeny
+	Decision string `json:"decision"`
+	Reason   string `json:"reason,omitempty"`
+	//PolicyID is an ID of the policy which decided
+	PolicyID string `json:"policy_id,omitempty"`
+	//Role is the user's role which granted access
+	Role string `json:"role,omitempty"`
+	//RoleChain lists roles through which Role implies the principal of the policy
+	RoleChain []string `json:"role_chain,omitempty"`
+	//TenantFilter lists tenants whose resources are accessible, nil means all tenants
+	TenantFilter []string `json:"tenant_filter"`
+	//DomainFilter lists domains whose resources are accessible, nil means all domains
+	DomainFilter []string `json:"domain_filter"`
+	//PropertyFilter lists property conditions resources have to match
+	PropertyFilter []map[string]interface{} `json:"property_filter"`
+	//AttributeFilter lists attribute conditions resources and the caller have to match
+	AttributeFilter []interface{} `json:"attribute_filter"`
+	//Properties lists accessible properties, nil means all properties
+	Properties []interface{} `json:"properties"`
+}
+
+//PolicyEvaluation describes how a single policy was evaluated
+type PolicyEvaluation struct {
+	ID        string `json:"id"`
+	Principal string `json:"principal"`
+	Action    string `json:"action"`
+	Effect    string `json:"effect"`
+	Priority  int    `json:"priority"`
+	Path      string `json:"path"`
+	Matched   bool   `json:"matched"`
+	//Role is the user's role matching the principal
+	Role string `json:"role,omitempty"`
+	//FailedOn names the criterion the request failed: action, path, tenant_id, tenant_name,
+	//domain_id, domain_name, principal or priority if a matching policy was overridden
+	FailedOn string `json:"failed_on,omitempty"`
+	//Applied is true for the policy which decided
+	Applied    bool                   `json:"applied"`
+	Conditions []*ConditionEvaluation `json:"conditions,omitempty"`
+}
+
+//ConditionEvaluation describes a result of a policy condition checked against a resource
+type ConditionEvaluation struct {
+	Type   string `json:"type"`
+	Passed bool   `json:"passed"`
+	Error  string `json:"error,omitempty"`
+}
+
+//ExplainPolicy explains the decision PolicyValidate makes for the request
+//If data is not nil, conditions of the deciding policy are checked against it.
+func ExplainPolicy(action, path string, auth Authorization, policies []*Policy, data map[string]interface{}) *PolicyExplanation {
+	return explainPolicy(action, path, auth, policies, nil, data)
+}
+
+func explainPolicy(action, path string, auth Authorization, policies []*Policy, roles *RoleHierarchy, data map[string]interface{}) *PolicyExplanation {
+	explanation := &PolicyExplanation{
+		Action:     action,
+		Path:       path,
+		TenantID:   auth.TenantID(),
+		TenantName: auth.TenantName(),
+		DomainID:   auth.DomainID(),
+		DomainName: auth.DomainName(),
+		Roles:      []string{},
+		Policies:   []*PolicyEvaluation{},
+		Decision:   EffectDeny,
+	}
+	for _, role := range auth.Roles() {
+		explanation.Roles = append(explanation.Roles, role.Name)
+	}
+	explanation.EffectiveRoles = roles.Expand(explanation.Roles)
+
+	matched := false
+	priority := 0
+	for _, policy := range policies {
+		role, failedOn := policy.matchCriterion(action, path, auth, roles)
+		evaluation := &PolicyEvaluation{
+			ID:        policy.ID,
+			Principal: policy.Principal,
+			Action:    policy.Action,
+			Effect:    policy.Effect,
+			Priority:  policy.Priority,
+			Path:      policy.Resource.Path.String(),
+			Matched:   role != nil,
+			FailedOn:  failedOn,
+		}
+		if role != nil {
+			evaluation.Role = role.Name
+		}
+		explanation.Policies = append(explanation.Policies, evaluation)
+		if role != nil && (!matched || policy.Priority > priority) {
+			matched, priority = true, policy.Priority
+		}
+	}
+	if !matched {
+		explanation.Reason = fmt.Sprintf("No matching policy: %s %s", action, path)
+		return explanation
+	}
+
+	selected, role := policyValidate(action, path, auth, policies, roles)
+	var applied *PolicyEvaluation
+	for i, policy := range policies {
+		evaluation := explanation.Policies[i]
+		if !evaluation.Matched {
+			continue
+		}
+		if policy.Priority < priority {
+			evaluation.FailedOn = failedOnPriority
+			continue
+		}
+		if applied == nil && (policy == selected || (selected == nil && policy.IsDeny())) {
+			evaluation.Applied = true
+			applied = evaluation
+		}
+	}
+	explanation.PolicyID = applied.ID
+	if selected == nil {
+		explanation.Reason = fmt.Sprintf("Denied by policy '%s'", applied.ID)
+		return explanation
+	}
+
+	explanation.Role = role.Name
+	explanation.RoleChain = roles.Grants(role.Name, selected.Principal)
+	explanation.TenantFilter = selected.GetTenantIDFilter(action, auth.TenantID())
+	explanation.DomainFilter = selected.GetDomainIDFilter(auth.DomainID())
+	explanation.PropertyFilter = selected.actionPropertyConditionFilter[action]
+	for _, condition := range selected.actionAttributeConditionFilter[action] {
+		explanation.AttributeFilter = append(explanation.AttributeFilter, condition.Raw)
+	}
+	explanation.Properties = selected.Resource.Properties
+	if data != nil {
+		applied.Conditions = selected.explainConditions(action, auth, data)
+		if err := selected.Check(action, auth, data); err != nil {
+			explanation.Reason = err.Error()
+			return explanation
+		}
+		if err := selected.ApplyConditionFilter(action, auth, data, nil); err != nil {
+			explanation.Reason = err.Error()
+			return explanation
+		}
+	}
+	explanation.Decision = EffectAllow
+	return explanation
+}
+
+func (p *Policy) explainConditions(action string, auth Authorization, data map[string]interface{}) []*ConditionEvaluation {
+	conditions := []*ConditionEvaluation{}
+	if p.requireOwner {
+		ownerID, _ := data["tenant_id"].(string)
+		ownerName, _ := data["tenant_name"].(string)
+		owner := newTenant(ownerID, ownerName)
+		caller := newTenant(auth.TenantID(), auth.TenantName())
+		isOwner := caller.equal(owner)
+		condition := &ConditionEvaluation{Type: conditionIsOwner, Passed: isOwner}
+		if !isOwner {
+			condition.Error = fmt.Sprintf("Tenant '%s' doesn't own resource of tenant '%s'", caller, owner)
+		}
+		conditions = append(conditions, condition)
+		if len(p.actionTenantFilter[action]) > 0 {
+			condition := &ConditionEvaluation{Type: conditionTypeBelongsTo, Passed: isOwner || p.isTenantAllowed(action, owner, caller)}
+			if !condition.Passed {
+				condition.Error = fmt.Sprintf("Tenant '%s' is prohibited from operating on resources of tenant '%s'", caller, owner)
+			}
+			conditions = append(conditions, condition)
+		}
+	}
+	if p.requireDomainOwner {
+		condition := &ConditionEvaluation{Type: conditionIsDomainOwner, Passed: true}
+		if err := p.checkDomainOwner(auth, data, true); err != nil {
+			condition.Passed = false
+			condition.Error = err.Error()
+		}
+		conditions = append(conditions, condition)
+	}
+	if len(p.actionPropertyConditionFilter[action]) > 0 {
+		condition := &ConditionEvaluation{Type: conditionProperty, Passed: true}
+		if err := p.ApplyPropertyConditionFilter(action, data, nil); err != nil {
+			condition.Passed = false
+			condition.Error = err.Error()
+		}
+		conditions = append(conditions, condition)
+	}
+	for _, attributeCondition := range p.actionAttributeConditionFilter[action] {
+		condition := &ConditionEvaluation{Type: conditionAttribute, Passed: true}
+		if err := attributeCondition.Evaluate(auth, data, nil); err != nil {
+			condition.Passed = false
+			condition.Error = err.Error()
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions
+}
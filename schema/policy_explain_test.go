@@ -0,0 +1,194 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Policy explain", func() {
+	var policies []*Policy
+
+	newPolicy := func(raw map[string]interface{}) *Policy {
+		policy, err := NewPolicy(raw)
+		Expect(err).ToNot(HaveOccurred())
+		return policy
+	}
+
+	BeforeEach(func() {
+		policies = []*Policy{
+			newPolicy(map[string]interface{}{
+				"id":        "admin_statement",
+				"principal": "admin",
+				"action":    "*",
+				"resource":  map[string]interface{}{"path": ".*"},
+			}),
+			newPolicy(map[string]interface{}{
+				"id":        "member_networks",
+				"principal": "Member",
+				"action":    "*",
+				"resource": map[string]interface{}{
+					"path":       "/v2.0/networks.*",
+					"properties": []interface{}{"id", "name", "tenant_id", "status"},
+				},
+				"condition": []interface{}{
+					"is_owner",
+					map[string]interface{}{
+						"type":      "belongs_to",
+						"action":    "read",
+						"tenant_id": "shared",
+					},
+					map[string]interface{}{
+						"type":   "property",
+						"action": "update",
+						"match":  map[string]interface{}{"status": "ACTIVE"},
+					},
+				},
+			}),
+			newPolicy(map[string]interface{}{
+				"id":        "member_no_delete",
+				"principal": "Member",
+				"action":    "delete",
+				"effect":    "deny",
+				"resource":  map[string]interface{}{"path": "/v2.0/networks.*"},
+			}),
+			newPolicy(map[string]interface{}{
+				"id":        "demo_read_only",
+				"principal": "Member",
+				"action":    "update",
+				"effect":    "deny",
+				"priority":  -1,
+				"tenant_id": "demo",
+				"resource":  map[string]interface{}{"path": "/v2.0/networks.*"},
+			}),
+		}
+	})
+
+	memberAuth := func(tenantID string) Authorization {
+		return NewAuthorization(tenantID, tenantID+"-name", "token", []string{"Member"}, nil)
+	}
+
+	evaluation := func(explanation *PolicyExplanation, id string) *PolicyEvaluation {
+		for _, evaluation := range explanation.Policies {
+			if evaluation.ID == id {
+				return evaluation
+			}
+		}
+		Fail("No evaluation of policy " + id)
+		return nil
+	}
+
+	It("explains allowed request", func() {
+		explanation := ExplainPolicy("read", "/v2.0/networks", memberAuth("tenant"), policies, nil)
+		Expect(explanation.Decision).To(Equal(EffectAllow))
+		Expect(explanation.PolicyID).To(Equal("member_networks"))
+		Expect(explanation.Role).To(Equal("Member"))
+		Expect(explanation.Roles).To(Equal([]string{"Member"}))
+		Expect(explanation.Policies).To(HaveLen(4))
+		Expect(explanation.TenantFilter).To(ConsistOf("shared", "tenant"))
+		Expect(explanation.PropertyFilter).To(BeEmpty())
+		Expect(explanation.Properties).To(HaveLen(4))
+
+		Expect(evaluation(explanation, "admin_statement").FailedOn).To(Equal("principal"))
+		Expect(evaluation(explanation, "member_networks").Applied).To(BeTrue())
+		Expect(evaluation(explanation, "member_no_delete").FailedOn).To(Equal("action"))
+		Expect(evaluation(explanation, "demo_read_only").FailedOn).To(Equal("action"))
+	})
+
+	It("explains missing policy", func() {
+		explanation := ExplainPolicy("read", "/v2.0/subnets", memberAuth("tenant"), policies, nil)
+		Expect(explanation.Decision).To(Equal(EffectDeny))
+		Expect(explanation.Reason).To(Equal("No matching policy: read /v2.0/subnets"))
+		Expect(explanation.PolicyID).To(BeEmpty())
+		Expect(evaluation(explanation, "member_networks").FailedOn).To(Equal("path"))
+	})
+
+	It("explains deny policy", func() {
+		explanation := ExplainPolicy("delete", "/v2.0/networks/id", memberAuth("tenant"), policies, nil)
+		Expect(explanation.Decision).To(Equal(EffectDeny))
+		Expect(explanation.PolicyID).To(Equal("member_no_delete"))
+		Expect(evaluation(explanation, "member_no_delete").Applied).To(BeTrue())
+		Expect(evaluation(explanation, "member_networks").Matched).To(BeTrue())
+		Expect(evaluation(explanation, "member_networks").Applied).To(BeFalse())
+	})
+
+	It("explains policies overridden by priority", func() {
+		explanation := ExplainPolicy("update", "/v2.0/networks/id", memberAuth("demo"), policies, nil)
+		Expect(explanation.Decision).To(Equal(EffectAllow))
+		Expect(explanation.PolicyID).To(Equal("member_networks"))
+		Expect(explanation.PropertyFilter).To(HaveLen(1))
+		demo := evaluation(explanation, "demo_read_only")
+		Expect(demo.Matched).To(BeTrue())
+		Expect(demo.FailedOn).To(Equal("priority"))
+	})
+
+	It("explains failed tenant criterion", func() {
+		explanation := ExplainPolicy("update", "/v2.0/networks/id", memberAuth("tenant"), policies, nil)
+		Expect(evaluation(explanation, "demo_read_only").FailedOn).To(Equal("tenant_id"))
+	})
+
+	Describe("With resource", func() {
+		It("explains conditions of own resource", func() {
+			resource := map[string]interface{}{"id": "network", "tenant_id": "tenant", "status": "ACTIVE"}
+			explanation := ExplainPolicy("update", "/v2.0/networks/network", memberAuth("tenant"), policies, resource)
+			Expect(explanation.Decision).To(Equal(EffectAllow))
+			conditions := evaluation(explanation, "member_networks").Conditions
+			Expect(conditions).To(HaveLen(2))
+			Expect(conditions[0].Type).To(Equal("is_owner"))
+			Expect(conditions[0].Passed).To(BeTrue())
+			Expect(conditions[1].Type).To(Equal("property"))
+			Expect(conditions[1].Passed).To(BeTrue())
+		})
+
+		It("explains failed property condition", func() {
+			resource := map[string]interface{}{"id": "network", "tenant_id": "tenant", "status": "ERROR"}
+			explanation := ExplainPolicy("update", "/v2.0/networks/network", memberAuth("tenant"), policies, resource)
+			Expect(explanation.Decision).To(Equal(EffectDeny))
+			Expect(explanation.Reason).To(ContainSubstring("Rejected by property filter"))
+			conditions := evaluation(explanation, "member_networks").Conditions
+			Expect(conditions[1].Passed).To(BeFalse())
+		})
+
+		It("explains belongs_to condition", func() {
+			resource := map[string]interface{}{"id": "network", "tenant_id": "shared"}
+			explanation := ExplainPolicy("read", "/v2.0/networks/network", memberAuth("tenant"), policies, resource)
+			Expect(explanation.Decision).To(Equal(EffectAllow))
+			conditions := evaluation(explanation, "member_networks").Conditions
+			Expect(conditions).To(HaveLen(2))
+			Expect(conditions[0].Type).To(Equal("is_owner"))
+			Expect(conditions[0].Passed).To(BeFalse())
+			Expect(conditions[1].Type).To(Equal("belongs_to"))
+			Expect(conditions[1].Passed).To(BeTrue())
+		})
+
+		It("explains resource of other tenant", func() {
+			resource := map[string]interface{}{"id": "network", "tenant_id": "other"}
+			explanation := ExplainPolicy("read", "/v2.0/networks/network", memberAuth("tenant"), policies, resource)
+			Expect(explanation.Decision).To(Equal(EffectDeny))
+			Expect(explanation.Reason).To(Equal(getProhibitedError("tenant-name (tenant)", ".* (other)")))
+			conditions := evaluation(explanation, "member_networks").Conditions
+			Expect(conditions[1].Passed).To(BeFalse())
+		})
+
+		It("explains prohibited properties", func() {
+			resource := map[string]interface{}{"id": "network", "tenant_id": "tenant", "secret": "x"}
+			explanation := ExplainPolicy("read", "/v2.0/networks/network", memberAuth("tenant"), policies, resource)
+			Expect(explanation.Decision).To(Equal(EffectDeny))
+			Expect(explanation.Reason).To(Equal("secret is prohibited for this user"))
+		})
+	})
+})
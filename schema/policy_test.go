@@ -19,6 +19,7 @@ import (
 	"fmt"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -89,6 +90,36 @@ var _ = Describe("Policies", func() {
 			}
 		})
 
+		It("should show error - unknown effect", func() {
+			testPolicy["effect"] = "dney"
+			_, err := NewPolicy(testPolicy)
+			Expect(err).To(MatchError("Unknown effect 'dney' for policy 'policy1'"))
+		})
+
+		It("should normalize effect", func() {
+			testPolicy["effect"] = "Deny"
+			policy, err := NewPolicy(testPolicy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy.IsDeny()).To(BeTrue())
+
+			delete(testPolicy, "effect")
+			policy, err = NewPolicy(testPolicy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy.Effect).To(Equal(EffectAllow))
+		})
+
+		It("should parse priority", func() {
+			testPolicy["priority"] = 10
+			policy, err := NewPolicy(testPolicy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy.Priority).To(Equal(10))
+
+			testPolicy["priority"] = float64(-5)
+			policy, err = NewPolicy(testPolicy)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(policy.Priority).To(Equal(-5))
+		})
+
 		It("should show error - invalid condition", func() {
 			testPolicy["condition"] = []interface{}{
 				"is_owner",
@@ -415,6 +446,201 @@ var _ = Describe("Policies", func() {
 			})
 		})
 	})
+
+	Describe("Deny and priority", func() {
+		const (
+			demoTenantID  = "demo-tenant"
+			otherTenantID = "other-tenant"
+		)
+
+		// policy builds a statement; extra keys such as priority or tenant_id are merged in
+		policy := func(id, principal, action, effect, path string, extra map[string]interface{}) *Policy {
+			raw := map[string]interface{}{
+				"id":        id,
+				"principal": principal,
+				"action":    action,
+				"effect":    effect,
+				"resource":  map[string]interface{}{"path": path},
+			}
+			for key, value := range extra {
+				raw[key] = value
+			}
+			p, err := NewPolicy(raw)
+			Expect(err).ToNot(HaveOccurred())
+			return p
+		}
+
+		auth := func(tenantID string, roles ...string) Authorization {
+			return NewAuthorization(tenantID, tenantID, "token", roles, nil)
+		}
+
+		// allowedBy returns ID of the policy granting access or "" if access is denied
+		allowedBy := func(policies []*Policy, action, path string, auth Authorization) string {
+			p, role := PolicyValidate(action, path, auth, policies)
+			if p == nil {
+				Expect(role).To(BeNil())
+				return ""
+			}
+			Expect(role).ToNot(BeNil())
+			return p.ID
+		}
+
+		Describe("Members can do everything except deleting routers", func() {
+			var policies []*Policy
+
+			BeforeEach(func() {
+				policies = []*Policy{
+					policy("admin_all", "admin", "*", "allow", ".*", nil),
+					policy("member_all", "Member", "*", "allow", "/v2.0/.*", nil),
+					policy("member_no_router_delete", "Member", "delete", "deny", "/v2.0/routers.*", nil),
+				}
+			})
+
+			DescribeTable("access",
+				func(action, path string, roles []string, expected string) {
+					Expect(allowedBy(policies, action, path, auth(demoTenantID, roles...))).To(Equal(expected))
+				},
+				Entry("member reads routers", "read", "/v2.0/routers", []string{"Member"}, "member_all"),
+				Entry("member updates a router", "update", "/v2.0/routers/r1", []string{"Member"}, "member_all"),
+				Entry("member can't delete a router", "delete", "/v2.0/routers/r1", []string{"Member"}, ""),
+				Entry("member deletes a network", "delete", "/v2.0/networks/n1", []string{"Member"}, "member_all"),
+				Entry("admin deletes a router", "delete", "/v2.0/routers/r1", []string{"admin"}, "admin_all"),
+				Entry("deny applies if any role matches", "delete", "/v2.0/routers/r1", []string{"admin", "Member"}, ""),
+				Entry("unknown role has no access", "read", "/v2.0/routers", []string{"guest"}, ""),
+			)
+
+			It("doesn't depend on order of policies", func() {
+				reversed := []*Policy{policies[2], policies[1], policies[0]}
+				Expect(allowedBy(reversed, "delete", "/v2.0/routers/r1", auth(demoTenantID, "Member"))).To(BeEmpty())
+				Expect(allowedBy(reversed, "read", "/v2.0/routers", auth(demoTenantID, "Member"))).To(Equal("member_all"))
+			})
+		})
+
+		Describe("Priority", func() {
+			It("lets a higher priority allow override a deny", func() {
+				policies := []*Policy{
+					policy("member_all", "Member", "*", "allow", "/v2.0/.*", nil),
+					policy("member_no_router_delete", "Member", "delete", "deny", "/v2.0/routers.*", nil),
+					policy("member_shared_router_delete", "Member", "delete", "allow", "/v2.0/routers/shared-.*",
+						map[string]interface{}{"priority": 10}),
+				}
+				member := auth(demoTenantID, "Member")
+				Expect(allowedBy(policies, "delete", "/v2.0/routers/shared-1", member)).To(Equal("member_shared_router_delete"))
+				Expect(allowedBy(policies, "delete", "/v2.0/routers/r1", member)).To(BeEmpty())
+			})
+
+			It("lets a higher priority deny override an allow", func() {
+				policies := []*Policy{
+					policy("admin_all", "admin", "*", "allow", ".*", map[string]interface{}{"priority": 1}),
+					policy("nobody_deletes_schemas", "admin", "delete", "deny", "/gohan/v0.1/schemas.*",
+						map[string]interface{}{"priority": 100}),
+				}
+				admin := auth(demoTenantID, "admin")
+				Expect(allowedBy(policies, "delete", "/gohan/v0.1/schemas/network", admin)).To(BeEmpty())
+				Expect(allowedBy(policies, "read", "/gohan/v0.1/schemas/network", admin)).To(Equal("admin_all"))
+			})
+
+			It("lets a policy with any principal restrict a path to one role", func() {
+				policies := []*Policy{
+					policy("member_all", "Member", "*", "allow", ".*", nil),
+					policy("admin_api_keys", "admin", "*", "allow", "/gohan/v0.1/api_keys.*",
+						map[string]interface{}{"priority": 100}),
+					policy("api_keys_admin_only", "*", "*", "deny", "/gohan/v0.1/api_keys.*",
+						map[string]interface{}{"priority": 99}),
+				}
+				Expect(allowedBy(policies, "read", "/gohan/v0.1/api_keys", auth(demoTenantID, "Member"))).To(BeEmpty())
+				Expect(allowedBy(policies, "read", "/gohan/v0.1/api_keys", auth(demoTenantID, "admin"))).To(Equal("admin_api_keys"))
+				Expect(allowedBy(policies, "read", "/v2.0/networks", auth(demoTenantID, "Member"))).To(Equal("member_all"))
+			})
+
+			It("ignores lower priority policies even if they are more specific", func() {
+				policies := []*Policy{
+					policy("member_specific", "Member", "read", "allow", "/v2.0/networks/red", nil),
+					policy("member_no_networks", "Member", "*", "deny", "/v2.0/networks.*",
+						map[string]interface{}{"priority": 1}),
+				}
+				Expect(allowedBy(policies, "read", "/v2.0/networks/red", auth(demoTenantID, "Member"))).To(BeEmpty())
+			})
+
+			It("returns the first matching allow policy among policies of the same priority", func() {
+				policies := []*Policy{
+					policy("low", "Member", "*", "allow", ".*", map[string]interface{}{"priority": -1}),
+					policy("first", "Member", "read", "allow", "/v2.0/.*", nil),
+					policy("second", "Member", "*", "allow", ".*", nil),
+				}
+				Expect(allowedBy(policies, "read", "/v2.0/networks", auth(demoTenantID, "Member"))).To(Equal("first"))
+				Expect(allowedBy(policies, "delete", "/v2.0/networks", auth(demoTenantID, "Member"))).To(Equal("second"))
+			})
+		})
+
+		Describe("Domains", func() {
+			domainAuth := func(domainID string, roles ...string) Authorization {
+				return NewDomainAuthorization(demoTenantID, demoTenantID, domainID, domainID+"-name", "token", roles, nil)
+			}
+
+			It("matches domain of the policy", func() {
+				policies := []*Policy{
+					policy("domain_admin", "admin", "*", "allow", ".*",
+						map[string]interface{}{"domain_id": "red", "condition": []interface{}{"is_domain_owner"}}),
+				}
+				Expect(allowedBy(policies, "read", "/v2.0/networks", domainAuth("red", "admin"))).To(Equal("domain_admin"))
+				Expect(allowedBy(policies, "read", "/v2.0/networks", domainAuth("blue", "admin"))).To(BeEmpty())
+			})
+
+			It("rejects both domain_id and domain_name", func() {
+				_, err := NewPolicy(map[string]interface{}{
+					"id":          "invalid",
+					"principal":   "admin",
+					"domain_id":   "red",
+					"domain_name": "red-name",
+					"resource":    map[string]interface{}{"path": ".*"},
+				})
+				Expect(err).To(MatchError(onlyOneOfDomainIDDomainNameError))
+			})
+
+			It("limits access to resources of the caller's domain", func() {
+				p := policy("domain_admin", "admin", "*", "allow", ".*",
+					map[string]interface{}{"condition": []interface{}{"is_domain_owner"}})
+				auth := domainAuth("red", "admin")
+				Expect(p.RequireDomainOwner()).To(BeTrue())
+				Expect(p.GetDomainIDFilter("red")).To(Equal([]string{"red"}))
+
+				Expect(p.ApplyConditionFilter("read", auth, map[string]interface{}{"tenant_id": otherTenantID, "domain_id": "red"}, nil)).To(Succeed())
+				Expect(p.ApplyConditionFilter("read", auth, map[string]interface{}{"domain_id": "blue"}, nil)).NotTo(Succeed())
+				Expect(p.ApplyConditionFilter("read", auth, map[string]interface{}{"tenant_id": otherTenantID}, nil)).NotTo(Succeed())
+
+				Expect(p.Check("update", auth, map[string]interface{}{"name": "network"})).To(Succeed())
+				Expect(p.Check("update", auth, map[string]interface{}{"domain_id": "blue"})).NotTo(Succeed())
+			})
+
+			It("doesn't limit access without is_domain_owner", func() {
+				p := policy("admin_all", "admin", "*", "allow", ".*", nil)
+				Expect(p.GetDomainIDFilter("red")).To(BeNil())
+				Expect(p.ApplyConditionFilter("read", domainAuth("red", "admin"), map[string]interface{}{"domain_id": "blue"}, nil)).To(Succeed())
+			})
+		})
+
+		Describe("Tenants", func() {
+			var policies []*Policy
+
+			BeforeEach(func() {
+				policies = []*Policy{
+					policy("member_all", "Member", "*", "allow", "/v2.0/.*", nil),
+					policy("demo_read_only", "Member", "create", "deny", "/v2.0/.*",
+						map[string]interface{}{"tenant_id": demoTenantID}),
+				}
+			})
+
+			DescribeTable("access",
+				func(tenantID, action string, expected string) {
+					Expect(allowedBy(policies, action, "/v2.0/networks", auth(tenantID, "Member"))).To(Equal(expected))
+				},
+				Entry("denied tenant can't create", demoTenantID, "create", ""),
+				Entry("denied tenant can read", demoTenantID, "read", "member_all"),
+				Entry("other tenant can create", otherTenantID, "create", "member_all"),
+			)
+		})
+	})
 })
 
 func getProhibitedError(caller, owner string) string {
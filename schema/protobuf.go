@@ -0,0 +1,264 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cloudwan/gohan/util"
+)
+
+//ProtoPackage is a protocol buffers package used for generated services
+const ProtoPackage = "gohan"
+
+//Protocol buffers scalar types used in generated messages
+const (
+	ProtoString = "string"
+	ProtoInt64  = "int64"
+	ProtoDouble = "double"
+	ProtoBool   = "bool"
+)
+
+//Protocol buffers methods generated for every schema
+const (
+	ProtoMethodList   = "list"
+	ProtoMethodGet    = "get"
+	ProtoMethodCreate = "create"
+	ProtoMethodUpdate = "update"
+	ProtoMethodDelete = "delete"
+)
+
+//ProtoField describes a field of a protocol buffers message
+//Objects and arrays of objects can't be described statically,
+//so they are sent as JSON encoded strings (JSON is true)
+type ProtoField struct {
+	Name     string
+	Number   int
+	Type     string
+	Repeated bool
+	Map      bool
+	JSON     bool
+}
+
+//ProtoMessage describes a protocol buffers message
+type ProtoMessage struct {
+	Name   string
+	Fields []*ProtoField
+}
+
+//ProtoMethod describes a rpc of a protocol buffers service
+//Action is one of ProtoMethod* constants or a custom action ID
+type ProtoMethod struct {
+	Name   string
+	Action string
+	Input  *ProtoMessage
+	Output *ProtoMessage
+}
+
+//ProtoService describes a protocol buffers service derived from a schema
+type ProtoService struct {
+	Name     string
+	Schema   *Schema
+	Messages []*ProtoMessage
+	Methods  []*ProtoMethod
+}
+
+//Field returns a field by number
+func (message *ProtoMessage) Field(number int) (*ProtoField, bool) {
+	for _, field := range message.Fields {
+		if field.Number == number {
+			return field, true
+		}
+	}
+	return nil, false
+}
+
+//Message returns a message by name
+func (service *ProtoService) Message(name string) (*ProtoMessage, bool) {
+	for _, message := range service.Messages {
+		if message.Name == name {
+			return message, true
+		}
+	}
+	return nil, false
+}
+
+//FullName returns a service name including package
+func (service *ProtoService) FullName() string {
+	return ProtoPackage + "." + service.Name
+}
+
+//ProtoService derives protocol buffers service definition from schema
+//Fields are numbered using the properties order, so new properties should be
+//appended to propertiesOrder in order to keep clients compatible
+func (schema *Schema) ProtoService() *ProtoService {
+	resourceName := protoName(schema.Singular)
+	pluralName := protoName(schema.Plural)
+	service := &ProtoService{
+		Name:   resourceName + "Service",
+		Schema: schema,
+	}
+
+	resource := &ProtoMessage{Name: resourceName}
+	rawProperties := util.MaybeMap(schema.JSONSchema["properties"])
+	for i, property := range schema.Properties {
+		rawProperty := util.MaybeMap(rawProperties[property.ID])
+		resource.Fields = append(resource.Fields, newProtoField(property, rawProperty, i+1))
+	}
+	resourceField := func(number int) *ProtoField {
+		return &ProtoField{Name: schema.Singular, Number: number, Type: resource.Name}
+	}
+	idField := &ProtoField{Name: "id", Number: 1, Type: ProtoString}
+
+	listRequest := &ProtoMessage{
+		Name: "List" + pluralName + "Request",
+		Fields: []*ProtoField{
+			{Name: "filter", Number: 1, Type: ProtoString, Map: true},
+			{Name: "limit", Number: 2, Type: ProtoInt64},
+			{Name: "offset", Number: 3, Type: ProtoInt64},
+			{Name: "sort_key", Number: 4, Type: ProtoString},
+			{Name: "sort_order", Number: 5, Type: ProtoString},
+		},
+	}
+	listResponse := &ProtoMessage{
+		Name: "List" + pluralName + "Response",
+		Fields: []*ProtoField{
+			{Name: schema.Plural, Number: 1, Type: resource.Name, Repeated: true},
+			{Name: "total", Number: 2, Type: ProtoInt64},
+		},
+	}
+	getRequest := &ProtoMessage{Name: "Get" + resourceName + "Request", Fields: []*ProtoField{idField}}
+	createRequest := &ProtoMessage{Name: "Create" + resourceName + "Request", Fields: []*ProtoField{resourceField(1)}}
+	updateRequest := &ProtoMessage{Name: "Update" + resourceName + "Request", Fields: []*ProtoField{idField, resourceField(2)}}
+	deleteRequest := &ProtoMessage{Name: "Delete" + resourceName + "Request", Fields: []*ProtoField{idField}}
+	deleteResponse := &ProtoMessage{Name: "Delete" + resourceName + "Response"}
+	service.Messages = []*ProtoMessage{
+		resource, listRequest, listResponse, getRequest, createRequest, updateRequest, deleteRequest, deleteResponse,
+	}
+	service.Methods = []*ProtoMethod{
+		{Name: "List" + pluralName, Action: ProtoMethodList, Input: listRequest, Output: listResponse},
+		{Name: "Get" + resourceName, Action: ProtoMethodGet, Input: getRequest, Output: resource},
+		{Name: "Create" + resourceName, Action: ProtoMethodCreate, Input: createRequest, Output: resource},
+		{Name: "Update" + resourceName, Action: ProtoMethodUpdate, Input: updateRequest, Output: resource},
+		{Name: "Delete" + resourceName, Action: ProtoMethodDelete, Input: deleteRequest, Output: deleteResponse},
+	}
+	if len(schema.Actions) == 0 {
+		return service
+	}
+
+	actionRequest := &ProtoMessage{
+		Name: resourceName + "ActionRequest",
+		Fields: []*ProtoField{
+			idField,
+			{Name: "input", Number: 2, Type: ProtoString, JSON: true},
+		},
+	}
+	actionResponse := &ProtoMessage{
+		Name: resourceName + "ActionResponse",
+		Fields: []*ProtoField{
+			{Name: "output", Number: 1, Type: ProtoString, JSON: true},
+		},
+	}
+	service.Messages = append(service.Messages, actionRequest, actionResponse)
+	actions := make([]Action, len(schema.Actions))
+	copy(actions, schema.Actions)
+	sort.Sort(actionsByID(actions))
+	for _, action := range actions {
+		service.Methods = append(service.Methods, &ProtoMethod{
+			Name:   protoName(action.ID),
+			Action: action.ID,
+			Input:  actionRequest,
+			Output: actionResponse,
+		})
+	}
+	return service
+}
+
+//Proto returns protocol buffers definition of the service
+func (service *ProtoService) Proto() string {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "// Generated by gohan from schema %s. DO NOT EDIT.\n", service.Schema.ID)
+	fmt.Fprintf(&buffer, "syntax = \"proto3\";\n\npackage %s;\n", ProtoPackage)
+	for _, message := range service.Messages {
+		fmt.Fprintf(&buffer, "\nmessage %s {\n", message.Name)
+		for _, field := range message.Fields {
+			fmt.Fprintf(&buffer, "  %s %s = %d;\n", field.protoType(), field.Name, field.Number)
+		}
+		buffer.WriteString("}\n")
+	}
+	fmt.Fprintf(&buffer, "\nservice %s {\n", service.Name)
+	for _, method := range service.Methods {
+		fmt.Fprintf(&buffer, "  rpc %s(%s) returns (%s);\n", method.Name, method.Input.Name, method.Output.Name)
+	}
+	buffer.WriteString("}\n")
+	return buffer.String()
+}
+
+type actionsByID []Action
+
+func (a actionsByID) Len() int           { return len(a) }
+func (a actionsByID) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a actionsByID) Less(i, j int) bool { return a[i].ID < a[j].ID }
+
+func (field *ProtoField) protoType() string {
+	switch {
+	case field.Map:
+		return fmt.Sprintf("map<string, %s>", field.Type)
+	case field.Repeated:
+		return "repeated " + field.Type
+	}
+	return field.Type
+}
+
+func newProtoField(property Property, rawProperty map[string]interface{}, number int) *ProtoField {
+	field := &ProtoField{Name: property.ID, Number: number}
+	switch property.Type {
+	case "array":
+		field.Repeated = true
+		items := util.MaybeMap(rawProperty["items"])
+		field.Type, field.JSON = protoScalarType(util.MaybeString(items["type"]))
+	default:
+		field.Type, field.JSON = protoScalarType(property.Type)
+	}
+	return field
+}
+
+func protoScalarType(jsonType string) (string, bool) {
+	switch jsonType {
+	case "string":
+		return ProtoString, false
+	case "integer":
+		return ProtoInt64, false
+	case "number":
+		return ProtoDouble, false
+	case "boolean":
+		return ProtoBool, false
+	}
+	return ProtoString, true
+}
+
+func protoName(id string) string {
+	words := strings.FieldsFunc(id, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || r == ' '
+	})
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, "")
+}
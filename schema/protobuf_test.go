@@ -0,0 +1,88 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Protocol buffers", func() {
+	var manager *Manager
+
+	BeforeEach(func() {
+		manager = GetManager()
+		Expect(manager.LoadSchemasFromFiles(
+			"../tests/test_abstract_schema.yaml", "../tests/test_schema.yaml")).To(Succeed())
+	})
+
+	AfterEach(func() {
+		ClearManager()
+	})
+
+	It("should derive CRUD methods and custom actions", func() {
+		s, ok := manager.Schema("responder")
+		Expect(ok).To(BeTrue())
+		service := s.ProtoService()
+		Expect(service.FullName()).To(Equal("gohan.ReponderService"))
+
+		methods := []string{}
+		for _, method := range service.Methods {
+			methods = append(methods, method.Name)
+		}
+		Expect(methods).To(Equal([]string{
+			"ListResponders", "GetReponder", "CreateReponder", "UpdateReponder", "DeleteReponder",
+			"Dobranoc", "Hello", "Hi",
+		}))
+		Expect(service.Methods[5].Action).To(Equal("dobranoc"))
+		Expect(service.Methods[5].Input.Name).To(Equal("ReponderActionRequest"))
+	})
+
+	It("should number resource fields using properties order", func() {
+		s, ok := manager.Schema("responder")
+		Expect(ok).To(BeTrue())
+		resource, ok := s.ProtoService().Message("Reponder")
+		Expect(ok).To(BeTrue())
+		Expect(resource.Fields[0]).To(Equal(&ProtoField{Name: "id", Number: 1, Type: ProtoString}))
+		Expect(resource.Fields[1]).To(Equal(&ProtoField{Name: "pattern", Number: 2, Type: ProtoString}))
+		Expect(resource.Fields[2]).To(Equal(&ProtoField{Name: "tenant_id", Number: 3, Type: ProtoString}))
+	})
+
+	It("should map JSON schema types to protocol buffers types", func() {
+		s, ok := manager.Schema("test")
+		Expect(ok).To(BeTrue())
+		resource, _ := s.ProtoService().Message("Test")
+		types := map[string]*ProtoField{}
+		for _, field := range resource.Fields {
+			types[field.Name] = field
+		}
+		Expect(types["test_string"].Type).To(Equal(ProtoString))
+		Expect(types["test_number"].Type).To(Equal(ProtoDouble))
+		Expect(types["test_integer"].Type).To(Equal(ProtoInt64))
+		Expect(types["test_bool"].Type).To(Equal(ProtoBool))
+	})
+
+	It("should render proto3 definition", func() {
+		s, ok := manager.Schema("responder")
+		Expect(ok).To(BeTrue())
+		proto := s.ProtoService().Proto()
+		Expect(proto).To(ContainSubstring("syntax = \"proto3\";"))
+		Expect(proto).To(ContainSubstring("package gohan;"))
+		Expect(proto).To(ContainSubstring("message ListRespondersRequest {\n  map<string, string> filter = 1;"))
+		Expect(proto).To(ContainSubstring("  repeated Reponder responders = 1;"))
+		Expect(proto).To(ContainSubstring("  rpc Hello(ReponderActionRequest) returns (ReponderActionResponse);"))
+	})
+})
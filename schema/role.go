@@ -0,0 +1,180 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import "fmt"
+
+//RoleHierarchy describes roles implied by other roles and aliases of roles
+//A user having a role is granted every role it implies, directly or transitively.
+//An alias is an alternative name of a role, e.g. _member_ for Member.
+type RoleHierarchy struct {
+	implies   map[string][]string
+	aliases   map[string]string
+	aliasesOf map[string][]string
+}
+
+//NewRoleHierarchy returns an empty role hierarchy
+func NewRoleHierarchy() *RoleHierarchy {
+	return &RoleHierarchy{
+		implies:   map[string][]string{},
+		aliases:   map[string]string{},
+		aliasesOf: map[string][]string{},
+	}
+}
+
+//AddRole registers role definition from object
+//
+//   - name: netadmin
+//     implies:
+//     - member
+//     aliases:
+//     - network_admin
+func (h *RoleHierarchy) AddRole(raw interface{}) error {
+	typeData, ok := raw.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("Invalid role format")
+	}
+	name, _ := typeData["name"].(string)
+	if name == "" {
+		return fmt.Errorf("Role name is required")
+	}
+	if canonical, ok := h.aliases[name]; ok {
+		return fmt.Errorf("Role '%s' is already an alias of role '%s'", name, canonical)
+	}
+	implies, err := stringList(typeData["implies"])
+	if err != nil {
+		return fmt.Errorf("Invalid implies of role '%s': %s", name, err)
+	}
+	aliases, err := stringList(typeData["aliases"])
+	if err != nil {
+		return fmt.Errorf("Invalid aliases of role '%s': %s", name, err)
+	}
+	for _, alias := range aliases {
+		if canonical, ok := h.aliases[alias]; ok && canonical != name {
+			return fmt.Errorf("Role alias '%s' is already defined for role '%s'", alias, canonical)
+		}
+		if _, ok := h.implies[alias]; ok {
+			return fmt.Errorf("Role alias '%s' is already defined as a role", alias)
+		}
+	}
+	for _, alias := range aliases {
+		if _, ok := h.aliases[alias]; !ok {
+			h.aliases[alias] = name
+			h.aliasesOf[name] = append(h.aliasesOf[name], alias)
+		}
+	}
+	h.implies[name] = append(h.implies[name], implies...)
+	return nil
+}
+
+func (h *RoleHierarchy) canonical(role string) string {
+	if canonical, ok := h.aliases[role]; ok {
+		return canonical
+	}
+	return role
+}
+
+//Grants returns a chain of roles through which role grants principal
+//The chain starts with role and ends with principal. nil is returned
+//if role doesn't grant principal.
+func (h *RoleHierarchy) Grants(role, principal string) []string {
+	if role == principal {
+		return []string{role}
+	}
+	if h == nil {
+		return nil
+	}
+	target := h.canonical(principal)
+	start := h.canonical(role)
+	previous := map[string]string{start: ""}
+	queue := []string{start}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		if current == target {
+			chain := []string{}
+			for name := current; name != ""; name = previous[name] {
+				chain = append([]string{name}, chain...)
+			}
+			if len(chain) == 1 {
+				return []string{role, principal}
+			}
+			chain[0], chain[len(chain)-1] = role, principal
+			return chain
+		}
+		for _, implied := range h.implies[current] {
+			implied = h.canonical(implied)
+			if _, visited := previous[implied]; !visited {
+				previous[implied] = current
+				queue = append(queue, implied)
+			}
+		}
+	}
+	return nil
+}
+
+//Expand returns names of the given roles and all roles they imply
+func (h *RoleHierarchy) Expand(roles []string) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	for _, role := range roles {
+		add(role)
+		if h == nil {
+			continue
+		}
+		queue := []string{h.canonical(role)}
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			add(current)
+			for _, alias := range h.aliasesOf[current] {
+				add(alias)
+			}
+			for _, implied := range h.implies[current] {
+				implied = h.canonical(implied)
+				if !seen[implied] {
+					queue = append(queue, implied)
+				}
+			}
+		}
+	}
+	return result
+}
+
+func stringList(raw interface{}) ([]string, error) {
+	if raw == nil {
+		return nil, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("list expected")
+	}
+	result := []string{}
+	for _, item := range list {
+		value, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("string expected, got %v", item)
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
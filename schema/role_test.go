@@ -0,0 +1,168 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package schema
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Role hierarchy", func() {
+	var roles *RoleHierarchy
+
+	role := func(name string, implies, aliases []interface{}) map[string]interface{} {
+		raw := map[string]interface{}{"name": name}
+		if implies != nil {
+			raw["implies"] = implies
+		}
+		if aliases != nil {
+			raw["aliases"] = aliases
+		}
+		return raw
+	}
+
+	BeforeEach(func() {
+		roles = NewRoleHierarchy()
+		Expect(roles.AddRole(role("admin", []interface{}{"netadmin"}, nil))).To(Succeed())
+		Expect(roles.AddRole(role("netadmin", []interface{}{"Member"}, []interface{}{"network_admin"}))).To(Succeed())
+		Expect(roles.AddRole(role("Member", nil, []interface{}{"_member_"}))).To(Succeed())
+	})
+
+	DescribeTable("grants",
+		func(role, principal string, expected []string) {
+			Expect(roles.Grants(role, principal)).To(Equal(expected))
+		},
+		Entry("role grants itself", "Member", "Member", []string{"Member"}),
+		Entry("directly implied role", "netadmin", "Member", []string{"netadmin", "Member"}),
+		Entry("transitively implied role", "admin", "Member", []string{"admin", "netadmin", "Member"}),
+		Entry("alias of the role", "_member_", "Member", []string{"_member_", "Member"}),
+		Entry("alias of the principal", "admin", "network_admin", []string{"admin", "network_admin"}),
+		Entry("implied role is not granted upwards", "Member", "netadmin", nil),
+		Entry("unknown role", "guest", "Member", nil),
+	)
+
+	It("grants only equal roles without hierarchy", func() {
+		var empty *RoleHierarchy
+		Expect(empty.Grants("Member", "Member")).To(Equal([]string{"Member"}))
+		Expect(empty.Grants("admin", "Member")).To(BeNil())
+		Expect(empty.Expand([]string{"admin"})).To(Equal([]string{"admin"}))
+	})
+
+	It("expands roles", func() {
+		Expect(roles.Expand([]string{"netadmin"})).To(Equal([]string{"netadmin", "network_admin", "Member", "_member_"}))
+	})
+
+	It("handles cycles", func() {
+		Expect(roles.AddRole(role("Member", []interface{}{"admin"}, nil))).To(Succeed())
+		Expect(roles.Grants("Member", "netadmin")).To(Equal([]string{"Member", "admin", "netadmin"}))
+		Expect(roles.Expand([]string{"Member"})).To(HaveLen(5))
+	})
+
+	It("rejects invalid definitions", func() {
+		Expect(roles.AddRole(role("", nil, nil))).NotTo(Succeed())
+		Expect(roles.AddRole(role("_member_", nil, nil))).NotTo(Succeed())
+		Expect(roles.AddRole(role("guest", nil, []interface{}{"netadmin"}))).NotTo(Succeed())
+		Expect(roles.AddRole(role("guest", nil, []interface{}{"_member_"}))).NotTo(Succeed())
+		Expect(roles.AddRole(map[string]interface{}{"name": "guest", "implies": "Member"})).NotTo(Succeed())
+	})
+
+	Describe("Policy validation", func() {
+		var policies []*Policy
+
+		BeforeEach(func() {
+			for _, raw := range []map[string]interface{}{
+				{
+					"id":        "member_all",
+					"principal": "Member",
+					"action":    "*",
+					"resource":  map[string]interface{}{"path": "/v2.0/.*"},
+				},
+				{
+					"id":        "member_no_router_delete",
+					"principal": "Member",
+					"action":    "delete",
+					"effect":    "deny",
+					"resource":  map[string]interface{}{"path": "/v2.0/routers.*"},
+				},
+				{
+					"id":        "netadmin_router_delete",
+					"principal": "netadmin",
+					"action":    "delete",
+					"priority":  1,
+					"resource":  map[string]interface{}{"path": "/v2.0/routers.*"},
+				},
+			} {
+				policy, err := NewPolicy(raw)
+				Expect(err).ToNot(HaveOccurred())
+				policies = append(policies, policy)
+			}
+		})
+
+		AfterEach(func() {
+			policies = nil
+		})
+
+		auth := func(roles ...string) Authorization {
+			return NewAuthorization("tenant", "tenant", "token", roles, nil)
+		}
+
+		It("matches principals implied by user's roles", func() {
+			policy, role := policyValidate("read", "/v2.0/networks", auth("admin"), policies, roles)
+			Expect(policy.ID).To(Equal("member_all"))
+			Expect(role.Name).To(Equal("admin"))
+
+			policy, role = policyValidate("read", "/v2.0/networks", auth("_member_"), policies, roles)
+			Expect(policy.ID).To(Equal("member_all"))
+			Expect(role.Name).To(Equal("_member_"))
+
+			policy, _ = policyValidate("delete", "/v2.0/routers/r1", auth("network_admin"), policies, roles)
+			Expect(policy.ID).To(Equal("netadmin_router_delete"))
+
+			policy, _ = policyValidate("delete", "/v2.0/routers/r1", auth("_member_"), policies, roles)
+			Expect(policy).To(BeNil())
+		})
+
+		It("doesn't expand roles without hierarchy", func() {
+			policy, _ := PolicyValidate("read", "/v2.0/networks", auth("admin"), policies)
+			Expect(policy).To(BeNil())
+		})
+
+		It("explains which role granted access", func() {
+			explanation := explainPolicy("read", "/v2.0/networks", auth("admin"), policies, roles, nil)
+			Expect(explanation.Decision).To(Equal(EffectAllow))
+			Expect(explanation.Role).To(Equal("admin"))
+			Expect(explanation.RoleChain).To(Equal([]string{"admin", "netadmin", "Member"}))
+			Expect(explanation.EffectiveRoles).To(ContainElement("Member"))
+			Expect(explanation.Policies[0].Role).To(Equal("admin"))
+		})
+	})
+
+	Describe("Manager", func() {
+		AfterEach(func() {
+			ClearManager()
+		})
+
+		It("loads roles from schema files", func() {
+			manager := GetManager()
+			Expect(manager.LoadSchemaFromFile("../tests/test_schema_roles.yaml")).To(Succeed())
+			policy, role := manager.PolicyValidate("read", "/v2.0/networks", NewAuthorization("tenant", "tenant", "token", []string{"netadmin"}, nil))
+			Expect(policy).ToNot(BeNil())
+			Expect(policy.ID).To(Equal("member_statement"))
+			Expect(role.Name).To(Equal("netadmin"))
+		})
+	})
+})
@@ -429,6 +429,34 @@ func (schema *Schema) StateVersioning() bool {
 	return stateful
 }
 
+//State aggregation policies combining states reported by many agents
+const (
+	StateAggregationAll    = "all"
+	StateAggregationAny    = "any"
+	StateAggregationQuorum = "quorum"
+)
+
+//StateAggregation how states reported by agents are combined into the state of a resource, defaults to all
+func (schema *Schema) StateAggregation() string {
+	aggregation, _ := schema.Metadata["state_aggregation"].(string)
+	switch aggregation {
+	case StateAggregationAny, StateAggregationQuorum:
+		return aggregation
+	}
+	return StateAggregationAll
+}
+
+//StateAgents number of agents expected to report states of a resource, zero when not set
+func (schema *Schema) StateAgents() int {
+	switch agents := schema.Metadata["state_agents"].(type) {
+	case int:
+		return agents
+	case float64:
+		return int(agents)
+	}
+	return 0
+}
+
 //SyncKeyTemplate - for custom paths in etcd
 func (schema *Schema) SyncKeyTemplate() (syncKeyTemplate string, ok bool) {
 	syncKeyTemplateRaw, ok := schema.Metadata["sync_key_template"]
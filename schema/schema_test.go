@@ -44,6 +44,99 @@ var _ = Describe("Schema", func() {
 			Expect(manager.LoadSchemaFromFile(schemaPath)).To(Succeed())
 		})
 
+		Describe("Reloading db objects", func() {
+			var (
+				manager          *Manager
+				filePolicies     int
+				fileExtensions   int
+				resourceSchema   *Schema
+				memberPolicyData map[string]interface{}
+			)
+
+			resources := func(data ...map[string]interface{}) []*Resource {
+				list := []*Resource{}
+				for _, properties := range data {
+					resource, err := NewResource(resourceSchema, properties)
+					Expect(err).ToNot(HaveOccurred())
+					list = append(list, resource)
+				}
+				return list
+			}
+
+			extensionData := func(id, code, path string) map[string]interface{} {
+				return map[string]interface{}{"id": id, "code_type": "gohanscript", "code": code, "path": path}
+			}
+
+			BeforeEach(func() {
+				manager = GetManager()
+				Expect(manager.LoadSchemasFromFiles("../tests/test_abstract_schema.yaml", "../tests/test_schema.yaml")).To(Succeed())
+				filePolicies = len(manager.Policies())
+				fileExtensions = len(manager.Extensions)
+				resourceSchema = NewSchema("db_object", "db_objects", "DB object", "", "db_object")
+				memberPolicyData = map[string]interface{}{
+					"id":        "db_policy",
+					"principal": "Member",
+					"action":    "read",
+					"resource":  map[string]interface{}{"path": "/v2.0/db_objects"},
+				}
+			})
+
+			It("replaces policies", func() {
+				Expect(manager.LoadPolicies(resources(memberPolicyData))).To(Succeed())
+				Expect(manager.Policies()).To(HaveLen(filePolicies + 1))
+
+				memberPolicyData["id"] = "db_policy_updated"
+				Expect(manager.ReloadPolicies(resources(memberPolicyData))).To(Succeed())
+				policies := manager.Policies()
+				Expect(policies).To(HaveLen(filePolicies + 1))
+				Expect(policies[len(policies)-1].ID).To(Equal("db_policy_updated"))
+
+				Expect(manager.ReloadPolicies(resources())).To(Succeed())
+				Expect(manager.Policies()).To(HaveLen(filePolicies))
+			})
+
+			It("keeps policies if any is invalid", func() {
+				Expect(manager.ReloadPolicies(resources(memberPolicyData))).To(Succeed())
+				invalid := map[string]interface{}{"id": "invalid", "effect": "maybe"}
+				Expect(manager.ReloadPolicies(resources(memberPolicyData, invalid))).ToNot(Succeed())
+				Expect(manager.Policies()).To(HaveLen(filePolicies + 1))
+			})
+
+			It("returns changed extensions", func() {
+				first := extensionData("first", "code", "/v2.0/first.*")
+				second := extensionData("second", "code", "/v2.0/second.*")
+				changed, err := manager.ReloadExtensions(resources(first, second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(changed).To(HaveLen(2))
+				Expect(manager.Extensions).To(HaveLen(fileExtensions + 2))
+
+				changed, err = manager.ReloadExtensions(resources(first, second))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(changed).To(BeEmpty())
+
+				modified := extensionData("first", "code", "/v2.0/moved.*")
+				changed, err = manager.ReloadExtensions(resources(modified))
+				Expect(err).ToNot(HaveOccurred())
+				paths := []string{}
+				for _, extension := range changed {
+					paths = append(paths, extension.Path.String())
+				}
+				Expect(paths).To(ConsistOf("/v2.0/first.*", "/v2.0/moved.*", "/v2.0/second.*"))
+				Expect(manager.Extensions).To(HaveLen(fileExtensions + 1))
+			})
+
+			It("replaces namespaces", func() {
+				namespace := map[string]interface{}{"id": "db_namespace", "prefix": "db"}
+				Expect(manager.ReloadNamespaces(resources(namespace))).To(Succeed())
+				_, ok := manager.Namespace("db_namespace")
+				Expect(ok).To(BeTrue())
+
+				Expect(manager.ReloadNamespaces(resources())).To(Succeed())
+				_, ok = manager.Namespace("db_namespace")
+				Expect(ok).To(BeFalse())
+			})
+		})
+
 		AfterEach(func() {
 			ClearManager()
 		})
@@ -102,6 +195,32 @@ var _ = Describe("Schema", func() {
 		})
 	})
 
+	Describe("References", func() {
+		var manager *Manager
+
+		BeforeEach(func() {
+			manager = GetManager()
+			Expect(manager.LoadSchemasFromFiles(
+				"../tests/test_abstract_schema.yaml", "../tests/test_schema.yaml")).To(Succeed())
+		})
+
+		AfterEach(func() {
+			ClearManager()
+		})
+
+		It("should list referencing properties with cascade", func() {
+			network, ok := manager.Schema("network")
+			Expect(ok).To(BeTrue())
+			cascade := map[string]bool{}
+			for _, reference := range manager.References(network) {
+				Expect(reference.RelationColumn()).To(Equal("id"))
+				cascade[reference.Schema.ID+"."+reference.Property.ID] = reference.Cascade
+			}
+			Expect(cascade).To(HaveKeyWithValue("subnet.network_id", false))
+			Expect(cascade).To(HaveKeyWithValue("server.network_id", true))
+		})
+	})
+
 	Describe("Metadata", func() {
 		var metadataSchema *Schema
 		var metadataFailedSchema *Schema
@@ -0,0 +1,194 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/util"
+)
+
+//agentStateDir is the directory under the state key of a resource
+//where each agent realizing the resource reports its own state
+const agentStateDir = "/_agents"
+
+//AgentState is a state of a resource reported by one agent
+type AgentState struct {
+	Agent   string `json:"agent"`
+	Version int64  `json:"version"`
+	State   string `json:"state"`
+	Error   string `json:"error"`
+}
+
+//Data returns the agent state as a map
+func (agentState *AgentState) Data() map[string]interface{} {
+	return map[string]interface{}{
+		"agent":   agentState.Agent,
+		"version": agentState.Version,
+		"state":   agentState.State,
+		"error":   agentState.Error,
+	}
+}
+
+//splitAgentStateKey splits a state key of an agent into the resource path and the agent
+//ok is false for keys which aren't under the agent state directory,
+//agent is empty for the directory itself.
+func splitAgentStateKey(key string) (resourcePath, agent string, ok bool) {
+	if strings.HasSuffix(key, agentStateDir) {
+		return strings.TrimSuffix(key, agentStateDir), "", true
+	}
+	i := strings.LastIndex(key, agentStateDir+"/")
+	if i < 0 {
+		return key, "", false
+	}
+	agent = key[i+len(agentStateDir)+1:]
+	if strings.Contains(agent, "/") {
+		return key, "", false
+	}
+	return key[:i], agent, true
+}
+
+//fetchAgentStates fetches states reported by agents of the resource, sorted by agent
+func fetchAgentStates(backend gohan_sync.Sync, resourcePath string) []*AgentState {
+	agentStates := []*AgentState{}
+	node, err := backend.Fetch(statePrefix + resourcePath + agentStateDir)
+	if err != nil || node == nil {
+		return agentStates
+	}
+	for _, child := range node.Children {
+		var data struct {
+			Version int64  `json:"version"`
+			State   string `json:"state"`
+			Error   string `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(child.Value), &data); err != nil {
+			log.Warning("Invalid agent state %s: %s", child.Key, err)
+			continue
+		}
+		agentStates = append(agentStates, &AgentState{
+			Agent:   path.Base(child.Key),
+			Version: data.Version,
+			State:   data.State,
+			Error:   data.Error,
+		})
+	}
+	sort.Slice(agentStates, func(i, j int) bool {
+		return agentStates[i].Agent < agentStates[j].Agent
+	})
+	return agentStates
+}
+
+//aggregateAgentStates combines states reported by agents using the aggregation policy of the schema
+//Expected agents which haven't reported yet count as version 0. The aggregated version
+//is the highest one reached by all agents, any agent or a quorum of them.
+//Error is set when not enough agents reached the version without an error.
+func aggregateAgentStates(s *schema.Schema, agentStates []*AgentState) (version int64, state, stateError string) {
+	expected := s.StateAgents()
+	if expected < len(agentStates) {
+		expected = len(agentStates)
+	}
+	if expected == 0 {
+		return 0, "", ""
+	}
+	required := expected
+	switch s.StateAggregation() {
+	case schema.StateAggregationAny:
+		required = 1
+	case schema.StateAggregationQuorum:
+		required = expected/2 + 1
+	}
+
+	versions := make([]int64, expected)
+	for i, agentState := range agentStates {
+		versions[i] = agentState.Version
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] > versions[j]
+	})
+	version = versions[required-1]
+
+	states := []string{}
+	errors := []string{}
+	succeeded := 0
+	for _, agentState := range agentStates {
+		if agentState.Version < version {
+			continue
+		}
+		if agentState.Error != "" {
+			errors = append(errors, agentState.Agent+": "+agentState.Error)
+			continue
+		}
+		succeeded++
+		if agentState.State != "" && !util.ContainsString(states, agentState.State) {
+			states = append(states, agentState.State)
+		}
+	}
+	if succeeded < required {
+		stateError = strings.Join(errors, "; ")
+	}
+	return version, strings.Join(states, ", "), stateError
+}
+
+//agentStatesData converts agent states for extensions
+func agentStatesData(agentStates []*AgentState) []interface{} {
+	data := make([]interface{}, 0, len(agentStates))
+	for _, agentState := range agentStates {
+		data = append(data, agentState.Data())
+	}
+	return data
+}
+
+//fetchResourceState returns the state of a resource together with states reported by its agents
+func fetchResourceState(dataStore db.DB, backend gohan_sync.Sync, s *schema.Schema, resourceID string) (map[string]interface{}, error) {
+	tx, err := dataStore.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+	resource, err := tx.Fetch(s, transaction.IDFilter(resourceID))
+	if err != nil {
+		return nil, err
+	}
+	resourceState, err := tx.StateFetch(s, transaction.IDFilter(resourceID))
+	if err != nil {
+		return nil, err
+	}
+	agentStates := []*AgentState{}
+	if backend != nil {
+		body, err := resource.JSONString()
+		if err != nil {
+			return nil, err
+		}
+		resourcePath := strings.TrimPrefix(generatePath(resource.Path(), body), configPrefix)
+		agentStates = fetchAgentStates(backend, resourcePath)
+	}
+	return map[string]interface{}{
+		"config_version": resourceState.ConfigVersion,
+		"state_version":  resourceState.StateVersion,
+		"state":          resourceState.State,
+		"error":          resourceState.Error,
+		"monitoring":     resourceState.Monitoring,
+		"aggregation":    s.StateAggregation(),
+		"agents":         agentStates,
+	}, nil
+}
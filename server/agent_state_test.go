@@ -0,0 +1,125 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+)
+
+func TestSplitAgentStateKey(t *testing.T) {
+	for _, test := range []struct {
+		key, resourcePath, agent string
+		ok                       bool
+	}{
+		{"/v2.0/networks/n1", "/v2.0/networks/n1", "", false},
+		{"/v2.0/networks/n1/_agents/compute1", "/v2.0/networks/n1", "compute1", true},
+		{"/v2.0/networks/n1/_agents", "/v2.0/networks/n1", "", true},
+		{"/v2.0/networks/n1/_agents/compute1/extra", "/v2.0/networks/n1/_agents/compute1/extra", "", false},
+	} {
+		resourcePath, agent, ok := splitAgentStateKey(test.key)
+		if resourcePath != test.resourcePath || agent != test.agent || ok != test.ok {
+			t.Errorf("%s: got (%s, %s, %v)", test.key, resourcePath, agent, ok)
+		}
+	}
+}
+
+func TestAggregateAgentStates(t *testing.T) {
+	agentStates := []*AgentState{
+		{Agent: "a1", Version: 3, State: "up"},
+		{Agent: "a2", Version: 3, State: "up"},
+		{Agent: "a3", Version: 2, Error: "failed"},
+	}
+	for _, test := range []struct {
+		aggregation string
+		agents      int
+		version     int64
+		state       string
+		stateError  string
+	}{
+		{schema.StateAggregationAll, 0, 2, "up", "a3: failed"},
+		{schema.StateAggregationAny, 0, 3, "up", ""},
+		{schema.StateAggregationQuorum, 0, 3, "up", ""},
+		{schema.StateAggregationQuorum, 5, 2, "up", "a3: failed"},
+		{schema.StateAggregationAll, 4, 0, "up", "a3: failed"},
+	} {
+		s := &schema.Schema{Metadata: map[string]interface{}{
+			"state_aggregation": test.aggregation,
+			"state_agents":      test.agents,
+		}}
+		version, state, stateError := aggregateAgentStates(s, agentStates)
+		if version != test.version || state != test.state || stateError != test.stateError {
+			t.Errorf("%s of %d agents: got (%d, %s, %s)", test.aggregation, test.agents, version, state, stateError)
+		}
+	}
+}
+
+func TestAgentStateUpdate(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+
+	createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r1", "p0": "created"})
+	s, _ := schema.GetManager().Schema("with_sync_property")
+	s.Metadata["state_aggregation"] = schema.StateAggregationQuorum
+	s.Metadata["state_agents"] = 3
+
+	report := func(agent, value string) {
+		key := statePrefix + s.URL + "/r1" + agentStateDir + "/" + agent
+		if err := backend.Update(key, value); err != nil {
+			t.Fatal(err)
+		}
+		if err := StateUpdate(&gohan_sync.Event{Action: "set", Key: key}, server); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fetchState := func() transaction.ResourceState {
+		tx, err := dataStore.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Close()
+		state, err := tx.StateFetch(s, transaction.IDFilter("r1"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return state
+	}
+
+	report("a1", `{"version": 1, "state": "up"}`)
+	if state := fetchState(); state.StateVersion != 0 {
+		t.Errorf("expected state version 0 before a quorum reported, got %d", state.StateVersion)
+	}
+	report("a2", `{"version": 1, "state": "up"}`)
+	state := fetchState()
+	if state.StateVersion != 1 || state.State != "up" || state.Error != "" {
+		t.Errorf("unexpected state: %+v", state)
+	}
+
+	resourceState, err := fetchResourceState(dataStore, backend, s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	agentStates := resourceState["agents"].([]*AgentState)
+	if len(agentStates) != 2 || agentStates[0].Agent != "a1" || agentStates[1].Agent != "a2" {
+		t.Errorf("unexpected agent states: %v", agentStates)
+	}
+	if resourceState["aggregation"] != schema.StateAggregationQuorum {
+		t.Errorf("unexpected aggregation: %v", resourceState["aggregation"])
+	}
+}
@@ -50,6 +50,16 @@ func authorization(w http.ResponseWriter, r *http.Request, action, path string,
 	return policy, role
 }
 
+//authorizeAction checks if any policy allows the action on the path, responding with an error otherwise
+//It gates APIs which aren't backed by a schema with the same policies as resources.
+func authorizeAction(w http.ResponseWriter, action, path string, auth schema.Authorization) bool {
+	if policy, _ := schema.GetManager().PolicyValidate(action, path, auth); policy == nil {
+		middleware.HTTPJSONError(w, fmt.Sprintf("No matching policy: %s %s", action, path), http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
 func addParamToQuery(r *http.Request, key, value string) {
 	r.URL.RawQuery += "&" + key + "=" + value
 }
@@ -80,6 +90,10 @@ func problemToResponseCode(problem resources.ResourceProblem) int {
 		return http.StatusConflict
 	case resources.Unauthorized:
 		return http.StatusUnauthorized
+	case resources.Forbidden:
+		return http.StatusForbidden
+	case resources.QuotaExceeded:
+		return http.StatusConflict
 	}
 	return http.StatusInternalServerError
 }
@@ -159,6 +173,26 @@ func handleError(writer http.ResponseWriter, err error) {
 	}
 }
 
+//setDryRun marks context of mutating requests with dry_run query parameter
+func setDryRun(context middleware.Context, r *http.Request) error {
+	dryRunParam := r.URL.Query().Get(resources.DryRunKey)
+	if dryRunParam == "" {
+		return nil
+	}
+	dryRun, err := strconv.ParseBool(dryRunParam)
+	if err != nil {
+		return resources.NewResourceError(err, fmt.Sprintf("Invalid %s parameter: %s", resources.DryRunKey, dryRunParam), resources.WrongQuery)
+	}
+	if dataStore, ok := context["db"].(db.DB); ok && dryRun && !dataStore.SupportsRollback() {
+		return resources.NewResourceError(
+			fmt.Errorf("database can't roll back changes"),
+			fmt.Sprintf("%s isn't supported by the database backend", resources.DryRunKey),
+			resources.WrongQuery)
+	}
+	context[resources.DryRunKey] = dryRun
+	return nil
+}
+
 func fillInContext(context middleware.Context, db db.DB,
 	r *http.Request, w http.ResponseWriter,
 	s *schema.Schema, p martini.Params, sync sync.Sync,
@@ -242,15 +276,75 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 		getSingleFunc(w, r, p, identityService, context)
 	})
 
+	//setup state route
+	if s.StateVersioning() {
+		getStateFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
+			addJSONContentTypeHeader(w)
+			fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+			id := p["id"]
+			if err := resources.GetSingleResource(context, dataStore, s, id); err != nil {
+				handleError(w, err)
+				return
+			}
+			if wait, _ := strconv.ParseBool(r.URL.Query().Get("wait")); wait {
+				timeout, err := stateWaitTimeout(r)
+				if err != nil {
+					handleError(w, err)
+					return
+				}
+				if err := server.waitForState(s, id, timeout); err != nil {
+					handleError(w, err)
+					return
+				}
+			}
+			state, err := fetchResourceState(dataStore, server.sync, s, id)
+			if err != nil {
+				handleError(w, err)
+				return
+			}
+			routes.ServeJson(w, map[string]interface{}{"state": state})
+		}
+		route.Get(singleURL+"/state", middleware.Authorization(schema.ActionRead), getStateFunc)
+		route.Get(singleURLWithParents+"/state", middleware.Authorization(schema.ActionRead), func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
+			addParamToQuery(r, schema.FormatParentID(s.Parent), p[s.Parent])
+			getStateFunc(w, r, p, identityService, context)
+		})
+	}
+
+	//setup delete preview route
+	deletePreviewFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
+		addJSONContentTypeHeader(w)
+		fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+		id := p["id"]
+		if err := resources.PreviewDeleteResource(context, dataStore, s, id); err != nil {
+			handleError(w, err)
+			return
+		}
+		routes.ServeJson(w, context["response"])
+	}
+	route.Get(singleURL+"/delete_preview", middleware.Authorization(schema.ActionDelete), deletePreviewFunc)
+	route.Get(singleURLWithParents+"/delete_preview", middleware.Authorization(schema.ActionDelete), func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
+		addParamToQuery(r, schema.FormatParentID(s.Parent), p[s.Parent])
+		deletePreviewFunc(w, r, p, identityService, context)
+	})
+
 	//setup delete route
 	deleteSingleFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
 		addJSONContentTypeHeader(w)
 		fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+		if err := setDryRun(context, r); err != nil {
+			handleError(w, err)
+			return
+		}
 		id := p["id"]
 		if err := resources.DeleteResource(context, dataStore, s, id); err != nil {
 			handleError(w, err)
 			return
 		}
+		if resources.IsDryRun(context) {
+			routes.ServeJson(w, context["response"])
+			return
+		}
 		w.WriteHeader(http.StatusNoContent)
 	}
 	route.Delete(singleURL, middleware.Authorization(schema.ActionDelete), deleteSingleFunc)
@@ -263,6 +357,10 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 	postPluralFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
 		addJSONContentTypeHeader(w)
 		fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+		if err := setDryRun(context, r); err != nil {
+			handleError(w, err)
+			return
+		}
 		dataMap, err := middleware.ReadJSON(r)
 		if err != nil {
 			handleError(w, resources.NewResourceError(err, fmt.Sprintf("Failed to parse data: %s", err), resources.WrongData))
@@ -282,7 +380,9 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 			handleError(w, err)
 			return
 		}
-		w.WriteHeader(http.StatusCreated)
+		if !resources.IsDryRun(context) {
+			w.WriteHeader(http.StatusCreated)
+		}
 		routes.ServeJson(w, context["response"])
 	}
 	route.Post(pluralURL, middleware.Authorization(schema.ActionCreate), postPluralFunc)
@@ -296,6 +396,10 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 	putSingleFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
 		addJSONContentTypeHeader(w)
 		fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+		if err := setDryRun(context, r); err != nil {
+			handleError(w, err)
+			return
+		}
 		id := p["id"]
 		dataMap, err := middleware.ReadJSON(r)
 		if err != nil {
@@ -307,7 +411,7 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 			context, dataStore, identityService, s, id, dataMap); err != nil {
 			handleError(w, err)
 			return
-		} else if isCreated {
+		} else if isCreated && !resources.IsDryRun(context) {
 			w.WriteHeader(http.StatusCreated)
 		}
 		routes.ServeJson(w, context["response"])
@@ -323,6 +427,10 @@ func MapRouteBySchema(server *Server, dataStore db.DB, s *schema.Schema) {
 	patchSingleFunc := func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
 		addJSONContentTypeHeader(w)
 		fillInContext(context, dataStore, r, w, s, p, server.sync, identityService, server.queue)
+		if err := setDryRun(context, r); err != nil {
+			handleError(w, err)
+			return
+		}
 		id := p["id"]
 		dataMap, err := middleware.ReadJSON(r)
 		if err != nil {
@@ -0,0 +1,148 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/extension/golang"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+	"github.com/rackspace/gophercloud"
+)
+
+//APIKeyIdentity accepts API keys as tokens
+//Tokens which don't name an API key are verified by the fallback identity service.
+type APIKeyIdentity struct {
+	dataStore      db.DB
+	fallback       middleware.IdentityService
+	adminTokenHash string
+}
+
+//NewAPIKeyIdentity is a constructor for APIKeyIdentity
+//fallback may be nil, then only API keys are accepted.
+//adminTokenHash is an optional hash of a token with admin role, used to create the first API keys.
+func NewAPIKeyIdentity(dataStore db.DB, fallback middleware.IdentityService, adminTokenHash string) *APIKeyIdentity {
+	return &APIKeyIdentity{dataStore: dataStore, fallback: fallback, adminTokenHash: adminTokenHash}
+}
+
+//VerifyToken verifies the API key or passes the token to the fallback identity service
+func (identity *APIKeyIdentity) VerifyToken(token string) (schema.Authorization, error) {
+	if identity.adminTokenHash != "" &&
+		subtle.ConstantTimeCompare([]byte(identity.adminTokenHash), []byte(resources.HashAPIKeySecret(token))) == 1 {
+		return identity.adminAuthorization(token), nil
+	}
+	apiKey, ok, err := resources.VerifyAPIKey(identity.dataStore, token)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if identity.fallback == nil {
+			return nil, fmt.Errorf("Invalid API key")
+		}
+		return identity.fallback.VerifyToken(token)
+	}
+	tenantName, err := identity.GetTenantName(apiKey.TenantID)
+	if err != nil {
+		return nil, err
+	}
+	return schema.NewAuthorization(apiKey.TenantID, tenantName, token, apiKey.Roles, nil), nil
+}
+
+//GetTenantID maps the given tenant name to the tenant's ID
+func (identity *APIKeyIdentity) GetTenantID(tenantName string) (string, error) {
+	if identity.fallback == nil {
+		return tenantName, nil
+	}
+	return identity.fallback.GetTenantID(tenantName)
+}
+
+//GetTenantName maps the given tenant ID to the tenant's name
+func (identity *APIKeyIdentity) GetTenantName(tenantID string) (string, error) {
+	if identity.fallback == nil {
+		return tenantID, nil
+	}
+	return identity.fallback.GetTenantName(tenantID)
+}
+
+//GetServiceAuthorization returns the authorization of the fallback identity service,
+//or admin authorization if API keys are used alone
+func (identity *APIKeyIdentity) GetServiceAuthorization() (schema.Authorization, error) {
+	if identity.fallback == nil {
+		return identity.adminAuthorization("admin_token"), nil
+	}
+	return identity.fallback.GetServiceAuthorization()
+}
+
+func (identity *APIKeyIdentity) adminAuthorization(token string) schema.Authorization {
+	return schema.NewAuthorization("admin", "admin", token, []string{resources.AdminRole}, nil)
+}
+
+//GetClient returns the client of the fallback identity service
+func (identity *APIKeyIdentity) GetClient() *gophercloud.ServiceClient {
+	if identity.fallback == nil {
+		return nil
+	}
+	return identity.fallback.GetClient()
+}
+
+//setupAPIKeys registers the go extension generating secrets of API keys
+//and removing secret hashes from responses. It is configured for the api_key schema in gohan.json.
+func setupAPIKeys() {
+	golang.RegisterGoCallback("handle_api_key",
+		func(event string, context map[string]interface{}) error {
+			apiKeySchema, ok := schema.GetManager().Schema(resources.APIKeySchemaID)
+			if !ok {
+				return nil
+			}
+			switch event {
+			case "pre_create_in_transaction":
+				if data, ok := context["resource"].(map[string]interface{}); ok {
+					return resources.SetAPIKeySecret(context, data)
+				}
+			case "post_list_in_transaction", "post_show_in_transaction",
+				"post_create_in_transaction", "post_update_in_transaction":
+				resources.RedactAPIKeyResponse(context, apiKeySchema)
+			}
+			return nil
+		})
+}
+
+//MapAPIKeyRoutes maps route rotating secrets of API keys
+func MapAPIKeyRoutes(server *Server, dataStore db.DB) {
+	apiKeySchema, ok := schema.GetManager().Schema(resources.APIKeySchemaID)
+	if !ok {
+		return
+	}
+	rotateURL := apiKeySchema.GetSingleURL() + "/rotate"
+	log.Debug("[Path] %s", rotateURL)
+	server.martini.Post(rotateURL, middleware.Authorization(schema.ActionUpdate),
+		func(w http.ResponseWriter, r *http.Request, p martini.Params, identityService middleware.IdentityService, context middleware.Context) {
+			addJSONContentTypeHeader(w)
+			fillInContext(context, dataStore, r, w, apiKeySchema, p, server.sync, identityService, server.queue)
+			if err := resources.RotateAPIKey(context, dataStore, p["id"]); err != nil {
+				handleError(w, err)
+				return
+			}
+			routes.ServeJson(w, context["response"])
+		})
+}
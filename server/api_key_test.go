@@ -0,0 +1,67 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/cloudwan/gohan/schema"
+)
+
+//fallbackIdentity records tokens passed to the fallback identity service
+type fallbackIdentity struct {
+	APIKeyIdentity
+	tokens []string
+}
+
+func (identity *fallbackIdentity) VerifyToken(token string) (schema.Authorization, error) {
+	identity.tokens = append(identity.tokens, token)
+	return nil, fmt.Errorf("Invalid token")
+}
+
+func TestAPIKeyDatabaseErrorIsNotFallback(t *testing.T) {
+	_, dataStore, _, cleanup := newTestSyncServer(t)
+	defer cleanup()
+	fallback := &fallbackIdentity{}
+	identity := NewAPIKeyIdentity(dataStore, fallback, "")
+
+	if _, err := identity.VerifyToken("unknown.secret"); err == nil {
+		t.Error("Expected unknown API key to be rejected")
+	}
+	if len(fallback.tokens) != 1 {
+		t.Errorf("Expected token of unknown API key to be passed to the fallback, got %v", fallback.tokens)
+	}
+
+	tx, err := dataStore.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Exec("DROP TABLE `api_keys`"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	if _, err := identity.VerifyToken("broken.secret"); err == nil {
+		t.Error("Expected database error to fail the request")
+	}
+	if len(fallback.tokens) != 1 {
+		t.Errorf("Expected token not to be passed to the fallback on database errors, got %v", fallback.tokens)
+	}
+}
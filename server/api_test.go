@@ -0,0 +1,53 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/cloudwan/gohan/db/file"
+	"github.com/cloudwan/gohan/db/sql"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+)
+
+func TestSetDryRun(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/v2.0/networks?dry_run=true", nil)
+
+	context := middleware.Context{"db": sql.NewDB()}
+	if err := setDryRun(context, r); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !resources.IsDryRun(context) {
+		t.Errorf("expected dry run")
+	}
+
+	context = middleware.Context{"db": file.NewDB()}
+	err := setDryRun(context, r)
+	resourceErr, ok := err.(resources.ResourceError)
+	if !ok || resourceErr.Problem != resources.WrongQuery {
+		t.Errorf("expected dry run to be rejected by file database, got %v", err)
+	}
+	if resources.IsDryRun(context) {
+		t.Errorf("unexpected dry run")
+	}
+
+	r, _ = http.NewRequest("POST", "/v2.0/networks?dry_run=false", nil)
+	if err := setDryRun(context, r); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/rackspace/gophercloud"
+)
+
+//CertificateIdentityConfig describes how client certificate fields are mapped to authorization
+//Fields are subject.common_name, subject.organization, subject.organizational_unit,
+//subject.country, subject.locality, subject.serial_number, san.dns, san.email, san.ip or san.uri.
+type CertificateIdentityConfig struct {
+	//TenantIDField names the field holding the tenant ID, the first value is used
+	TenantIDField string
+	//TenantNameField names the field holding the tenant name, defaults to the tenant ID
+	TenantNameField string
+	//RolesField names the field holding roles, all values are used
+	RolesField string
+	//Roles are granted to every certificate in addition to roles from RolesField
+	Roles []string
+}
+
+//CertificateIdentity authenticates clients by TLS client certificates
+//Tokens are verified by the fallback identity service.
+type CertificateIdentity struct {
+	config   CertificateIdentityConfig
+	fallback middleware.IdentityService
+}
+
+//NewCertificateIdentity is a constructor for CertificateIdentity
+//fallback may be nil, then only client certificates are accepted.
+func NewCertificateIdentity(config CertificateIdentityConfig, fallback middleware.IdentityService) (*CertificateIdentity, error) {
+	for _, field := range []string{config.TenantIDField, config.TenantNameField, config.RolesField} {
+		if field == "" {
+			continue
+		}
+		if _, err := certificateField(&x509.Certificate{}, field); err != nil {
+			return nil, err
+		}
+	}
+	if config.TenantIDField == "" {
+		return nil, fmt.Errorf("Tenant ID field of client certificates is required")
+	}
+	return &CertificateIdentity{config: config, fallback: fallback}, nil
+}
+
+//VerifyCertificate maps the verified client certificate to authorization
+func (identity *CertificateIdentity) VerifyCertificate(certificate *x509.Certificate) (schema.Authorization, error) {
+	tenantIDs, _ := certificateField(certificate, identity.config.TenantIDField)
+	if len(tenantIDs) == 0 || tenantIDs[0] == "" {
+		return nil, fmt.Errorf("Client certificate has no %s", identity.config.TenantIDField)
+	}
+	tenantID := tenantIDs[0]
+	tenantName := tenantID
+	if identity.config.TenantNameField != "" {
+		if tenantNames, _ := certificateField(certificate, identity.config.TenantNameField); len(tenantNames) > 0 {
+			tenantName = tenantNames[0]
+		}
+	}
+	roles := append([]string{}, identity.config.Roles...)
+	if identity.config.RolesField != "" {
+		fieldRoles, _ := certificateField(certificate, identity.config.RolesField)
+		roles = append(roles, fieldRoles...)
+	}
+	if len(roles) == 0 {
+		return nil, fmt.Errorf("Client certificate has no roles")
+	}
+	return schema.NewAuthorization(tenantID, tenantName, "", roles, nil), nil
+}
+
+//VerifyToken passes the token to the fallback identity service
+func (identity *CertificateIdentity) VerifyToken(token string) (schema.Authorization, error) {
+	if identity.fallback == nil {
+		return nil, fmt.Errorf("Only client certificates are accepted")
+	}
+	return identity.fallback.VerifyToken(token)
+}
+
+//GetTenantID maps the given tenant name to the tenant's ID
+func (identity *CertificateIdentity) GetTenantID(tenantName string) (string, error) {
+	if identity.fallback == nil {
+		return tenantName, nil
+	}
+	return identity.fallback.GetTenantID(tenantName)
+}
+
+//GetTenantName maps the given tenant ID to the tenant's name
+func (identity *CertificateIdentity) GetTenantName(tenantID string) (string, error) {
+	if identity.fallback == nil {
+		return tenantID, nil
+	}
+	return identity.fallback.GetTenantName(tenantID)
+}
+
+//GetServiceAuthorization returns the authorization of the fallback identity service,
+//or admin authorization if client certificates are used alone
+func (identity *CertificateIdentity) GetServiceAuthorization() (schema.Authorization, error) {
+	if identity.fallback == nil {
+		return schema.NewAuthorization("admin", "admin", "admin_token", []string{resources.AdminRole}, nil), nil
+	}
+	return identity.fallback.GetServiceAuthorization()
+}
+
+//GetClient returns the client of the fallback identity service
+func (identity *CertificateIdentity) GetClient() *gophercloud.ServiceClient {
+	if identity.fallback == nil {
+		return nil
+	}
+	return identity.fallback.GetClient()
+}
+
+func certificateField(certificate *x509.Certificate, field string) ([]string, error) {
+	subject := certificate.Subject
+	switch field {
+	case "subject.common_name":
+		if subject.CommonName == "" {
+			return nil, nil
+		}
+		return []string{subject.CommonName}, nil
+	case "subject.organization":
+		return subject.Organization, nil
+	case "subject.organizational_unit":
+		return subject.OrganizationalUnit, nil
+	case "subject.country":
+		return subject.Country, nil
+	case "subject.locality":
+		return subject.Locality, nil
+	case "subject.serial_number":
+		if subject.SerialNumber == "" {
+			return nil, nil
+		}
+		return []string{subject.SerialNumber}, nil
+	case "san.dns":
+		return certificate.DNSNames, nil
+	case "san.email":
+		return certificate.EmailAddresses, nil
+	case "san.ip":
+		values := []string{}
+		for _, ip := range certificate.IPAddresses {
+			values = append(values, ip.String())
+		}
+		return values, nil
+	case "san.uri":
+		values := []string{}
+		for _, uri := range certificate.URIs {
+			values = append(values, uri.String())
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("Unknown client certificate field '%s'", field)
+}
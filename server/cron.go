@@ -50,6 +50,7 @@ func startCRONProcess(server *Server) {
 		lockKey := lockPath + "/" + name
 		jobLocks[lockKey] = make(chan int, 1)
 		jobLocks[lockKey] <- 1
+		jobElection := server.newElection(lockKey)
 		env, err := server.NewEnvironmentForPath(name, path)
 		if err != nil {
 			log.Fatal(err.Error())
@@ -58,7 +59,7 @@ func startCRONProcess(server *Server) {
 		takeLock := func() error {
 			select {
 			case <-jobLocks[lockKey]:
-				err := server.sync.Lock(lockKey, false)
+				err := jobElection.TryCampaign()
 				if err != nil {
 					log.Debug("Failed to take ETCD lock")
 					jobLocks[lockKey] <- 1
@@ -82,7 +83,7 @@ func startCRONProcess(server *Server) {
 				}
 				log.Debug("Unlocking %s", lockKey)
 				jobLocks[lockKey] <- 1
-				server.sync.Unlock(lockKey)
+				jobElection.Resign()
 			}()
 
 			context := map[string]interface{}{
@@ -0,0 +1,129 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+//retryAction is an action of policies allowing to retry events and dead letters
+const retryAction = "retry"
+
+//RetryEvent schedules an event to be synced on the next pass
+func RetryEvent(tx transaction.Transaction, id interface{}) (*schema.Resource, error) {
+	eventSchema, _ := schema.GetManager().Schema("event")
+	event, err := tx.Fetch(eventSchema, transaction.IDFilter(id))
+	if err != nil {
+		return nil, resources.NewResourceError(err, fmt.Sprintf("Event %v not found", id), resources.NotFound)
+	}
+	data := event.Data()
+	data["retry_count"] = 0
+	data["next_retry"] = 0
+	data["last_error"] = ""
+	if err := tx.Update(event); err != nil {
+		return nil, resources.NewResourceError(err, fmt.Sprintf("Failed to retry event: %v", err), resources.UpdateFailed)
+	}
+	return event, nil
+}
+
+//RetryDeadLetter moves a dead letter back to events
+//The event keeps its ID, so it is synced in its original order.
+func RetryDeadLetter(tx transaction.Transaction, id interface{}) (*schema.Resource, error) {
+	schemaManager := schema.GetManager()
+	eventSchema, _ := schemaManager.Schema("event")
+	deadLetterSchema, _ := schemaManager.Schema(deadLetterSchemaID)
+	deadLetter, err := tx.Fetch(deadLetterSchema, transaction.IDFilter(id))
+	if err != nil {
+		return nil, resources.NewResourceError(err, fmt.Sprintf("Dead letter %v not found", id), resources.NotFound)
+	}
+	event, _ := schema.NewResource(eventSchema, map[string]interface{}{
+		"id":            deadLetter.Get("id"),
+		"type":          deadLetter.Get("type"),
+		"path":          deadLetter.Get("path"),
+		"version":       deadLetter.Get("version"),
+		"body":          deadLetter.Get("body"),
+		"sync_plain":    deadLetter.Get("sync_plain"),
+		"sync_property": deadLetter.Get("sync_property"),
+		"timestamp":     deadLetter.Get("timestamp"),
+		"retry_count":   0,
+		"next_retry":    0,
+		"last_error":    "",
+	})
+	if err := tx.Create(event); err != nil {
+		return nil, resources.NewResourceError(err, fmt.Sprintf("Failed to retry dead letter: %v", err), resources.UpdateFailed)
+	}
+	if err := tx.Delete(deadLetterSchema, deadLetter.Get("id")); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
+
+//MapDeadLetterRoutes maps routes retrying pending events and dead letters
+//Both are listed and discarded using the routes of the event and dead_letter schemas.
+func MapDeadLetterRoutes(route martini.Router, dataStore db.DB) {
+	schemaManager := schema.GetManager()
+	eventSchema, ok := schemaManager.Schema("event")
+	if !ok {
+		return
+	}
+	deadLetterSchema, ok := schemaManager.Schema(deadLetterSchemaID)
+	if !ok {
+		return
+	}
+	mapRetryRoute(route, dataStore, eventSchema, RetryEvent)
+	mapRetryRoute(route, dataStore, deadLetterSchema, RetryDeadLetter)
+}
+
+func mapRetryRoute(route martini.Router, dataStore db.DB, s *schema.Schema,
+	retry func(transaction.Transaction, interface{}) (*schema.Resource, error)) {
+	retryURL := s.GetSingleURL() + "/retry"
+	log.Debug("[Path] %s", retryURL)
+	route.Post(retryURL, func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+		addJSONContentTypeHeader(w)
+		if !authorizeAction(w, retryAction, r.URL.Path, auth) {
+			return
+		}
+		tx, err := dataStore.Begin()
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		defer tx.Close()
+		event, err := retry(tx, p["id"])
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			handleError(w, err)
+			return
+		}
+		committed := transactionCommitInformer()
+		select {
+		case committed <- 1:
+		default:
+		}
+		routes.ServeJson(w, map[string]interface{}{"event": event.Data()})
+	})
+}
@@ -228,8 +228,7 @@ func setupEditor(server *Server) {
 				return err
 			}
 			server.initDB()
-			server.resetRouter()
-			server.mapRoutes()
+			server.remapRoutes()
 			return nil
 		})
 }
@@ -0,0 +1,620 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwan/gohan/extension"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/cloudwan/gohan/util"
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	grpcAuthTokenKey = "x-auth-token"
+
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+//grpcMessage is a dynamic protocol buffers message described by schema
+type grpcMessage struct {
+	service *schema.ProtoService
+	message *schema.ProtoMessage
+	data    map[string]interface{}
+}
+
+//grpcCodec encodes and decodes messages derived from schemas
+type grpcCodec struct{}
+
+func (grpcCodec) Marshal(v interface{}) ([]byte, error) {
+	message, ok := v.(*grpcMessage)
+	if !ok {
+		return nil, fmt.Errorf("unsupported message type %T", v)
+	}
+	return encodeProtoMessage(message.service, message.message, message.data)
+}
+
+func (grpcCodec) Unmarshal(data []byte, v interface{}) error {
+	message, ok := v.(*grpcMessage)
+	if !ok {
+		return fmt.Errorf("unsupported message type %T", v)
+	}
+	var err error
+	message.data, err = decodeProtoMessage(message.service, message.message, data)
+	return err
+}
+
+func (grpcCodec) String() string {
+	return "proto"
+}
+
+//gRPC Process
+//Serves resources of all schemas using services generated by "gohan proto"
+func startGRPCProcess(server *Server) {
+	config := util.GetConfig()
+	if !config.GetBool("grpc/enabled", false) {
+		return
+	}
+	address := config.GetString("grpc/address", ":9092")
+	options := []grpc.ServerOption{grpc.CustomCodec(grpcCodec{})}
+	if server.tls != nil {
+		creds, err := credentials.NewServerTLSFromFile(server.tls.CertFile, server.tls.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+	server.grpc = grpc.NewServer(options...)
+	for _, s := range schema.GetManager().OrderedSchemas() {
+		if s.IsAbstract() {
+			continue
+		}
+		server.grpc.RegisterService(server.newGRPCServiceDesc(s.ProtoService()), server)
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Info("    gRPC Server %s", address)
+	go func() {
+		defer l.LogFatalPanic(log)
+		if err := server.grpc.Serve(listener); err != nil && server.running {
+			log.Error(fmt.Sprintf("[gRPC] server stopped: %s", err))
+		}
+	}()
+}
+
+func stopGRPCProcess(server *Server) {
+	if server.grpc != nil {
+		server.grpc.GracefulStop()
+	}
+}
+
+func (server *Server) newGRPCServiceDesc(service *schema.ProtoService) *grpc.ServiceDesc {
+	desc := &grpc.ServiceDesc{
+		ServiceName: service.FullName(),
+		HandlerType: (*interface{})(nil),
+		Metadata:    service.Schema.ID + ".proto",
+	}
+	for _, protoMethod := range service.Methods {
+		method := protoMethod
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: method.Name,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				request := &grpcMessage{service: service, message: method.Input}
+				if err := dec(request); err != nil {
+					return nil, grpc.Errorf(codes.InvalidArgument, "Failed to parse data: %s", err)
+				}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					response, err := server.handleGRPC(ctx, service.Schema, method, req.(*grpcMessage).data)
+					if err != nil {
+						return nil, err
+					}
+					return &grpcMessage{service: service, message: method.Output, data: response}, nil
+				}
+				if interceptor == nil {
+					return handler(ctx, request)
+				}
+				info := &grpc.UnaryServerInfo{
+					Server:     srv,
+					FullMethod: "/" + service.FullName() + "/" + method.Name,
+				}
+				return interceptor(ctx, request, info, handler)
+			},
+		})
+	}
+	return desc
+}
+
+func (server *Server) grpcIdentityService() middleware.IdentityService {
+	if server.keystoneIdentity != nil {
+		return server.keystoneIdentity
+	}
+	return &middleware.NoIdentityService{}
+}
+
+func (server *Server) grpcAuthorization(ctx context.Context, path string) (middleware.IdentityService, schema.Authorization, error) {
+	identityService := server.grpcIdentityService()
+	authToken := ""
+	if md, ok := metadata.FromContext(ctx); ok && len(md[grpcAuthTokenKey]) > 0 {
+		authToken = md[grpcAuthTokenKey][0]
+	}
+	if authToken == "" && server.keystoneIdentity != nil {
+		nobodyResourceService := middleware.NewNobodyResourceService(schema.GetManager().NobodyResourcePaths())
+		if !nobodyResourceService.VerifyResourcePath(path) {
+			return nil, nil, grpc.Errorf(codes.Unauthenticated, "No %s", grpcAuthTokenKey)
+		}
+		identityService = &middleware.NobodyIdentityService{}
+	}
+	auth, err := identityService.VerifyToken(authToken)
+	if err != nil {
+		return nil, nil, grpc.Errorf(codes.Unauthenticated, "%s", err)
+	}
+	return identityService, auth, nil
+}
+
+func (server *Server) handleGRPC(ctx context.Context, s *schema.Schema, method *schema.ProtoMethod, request map[string]interface{}) (map[string]interface{}, error) {
+	id, _ := request["id"].(string)
+	path := s.GetPluralURL()
+	if id != "" {
+		path = strings.Replace(s.GetSingleURL(), ":id", id, 1)
+	}
+	identityService, auth, err := server.grpcAuthorization(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	context := middleware.Context{
+		"path":             path,
+		"schema":           s,
+		"params":           map[string]interface{}{"id": id},
+		"sync":             server.sync,
+		"db":               server.db,
+		"queue":            server.queue,
+		"identity_service": identityService,
+		"openstack_client": identityService.GetClient(),
+		"tenant_id":        auth.TenantID(),
+		"tenant_name":      auth.TenantName(),
+		"domain_id":        auth.DomainID(),
+		"domain_name":      auth.DomainName(),
+		"auth_token":       auth.AuthToken(),
+		"catalog":          auth.Catalog(),
+		"auth":             auth,
+	}
+	context["service_auth"], _ = identityService.GetServiceAuthorization()
+
+	switch method.Action {
+	case schema.ProtoMethodList:
+		err = resources.GetMultipleResources(context, server.db, s, grpcListQuery(request))
+		if err != nil {
+			return grpcResponse(err)
+		}
+		response := util.MaybeMap(context["response"])
+		response["total"] = context["total"]
+		return response, nil
+	case schema.ProtoMethodGet:
+		err = resources.GetSingleResource(context, server.db, s, id)
+	case schema.ProtoMethodCreate:
+		err = resources.CreateResource(context, server.db, identityService, s, util.MaybeMap(request[s.Singular]))
+	case schema.ProtoMethodUpdate:
+		err = resources.UpdateResource(context, server.db, identityService, s, id, util.MaybeMap(request[s.Singular]))
+	case schema.ProtoMethodDelete:
+		err = resources.DeleteResource(context, server.db, s, id)
+		if err != nil {
+			return grpcResponse(err)
+		}
+		return map[string]interface{}{}, nil
+	default:
+		return server.handleGRPCAction(context, s, method, identityService, auth, id, request)
+	}
+	if err != nil {
+		return grpcResponse(err)
+	}
+	response := util.MaybeMap(context["response"])
+	return util.MaybeMap(response[s.Singular]), nil
+}
+
+func (server *Server) handleGRPCAction(context middleware.Context, s *schema.Schema, method *schema.ProtoMethod,
+	identityService middleware.IdentityService, auth schema.Authorization, id string, request map[string]interface{}) (map[string]interface{}, error) {
+	var action *schema.Action
+	for i := range s.Actions {
+		if s.Actions[i].ID == method.Action {
+			action = &s.Actions[i]
+		}
+	}
+	if action == nil {
+		return nil, grpc.Errorf(codes.Unimplemented, "Unknown action %s", method.Action)
+	}
+	path := strings.Replace(s.GetActionURL(action.Path), ":id", id, 1)
+	context["path"] = path
+	manager := schema.GetManager()
+	policy, role := manager.PolicyValidate(action.ID, path, auth)
+	if policy == nil {
+		return nil, grpc.Errorf(codes.PermissionDenied, "No matching policy: %s %s", action.ID, path)
+	}
+	context["policy"] = policy
+	context["role"] = role
+
+	input := util.MaybeMap(request["input"])
+	if err := resources.ActionResource(context, server.db, identityService, s, *action, id, input); err != nil {
+		return grpcResponse(err)
+	}
+	return map[string]interface{}{"output": context["response"]}, nil
+}
+
+func grpcListQuery(request map[string]interface{}) url.Values {
+	query := url.Values{}
+	for key, value := range util.MaybeMap(request["filter"]) {
+		query[key] = strings.Split(fmt.Sprint(value), ",")
+	}
+	for _, key := range []string{"limit", "offset"} {
+		if value, ok := request[key].(int); ok && value > 0 {
+			query.Set(key, strconv.Itoa(value))
+		}
+	}
+	for _, key := range []string{"sort_key", "sort_order"} {
+		if value, ok := request[key].(string); ok && value != "" {
+			query.Set(key, value)
+		}
+	}
+	return query
+}
+
+//grpcResponse converts resource errors to gRPC status errors
+//Extensions may return a successful response using an exception with 2xx code
+func grpcResponse(err error) (map[string]interface{}, error) {
+	switch err := err.(type) {
+	case resources.ResourceError:
+		return nil, grpc.Errorf(problemToGRPCCode(err.Problem), "%s", err.Message)
+	case extension.Error:
+		message, code := unwrapExtensionException(err.ExceptionInfo)
+		if 200 <= code && code < 300 {
+			return message, nil
+		}
+		return nil, grpc.Errorf(httpStatusToGRPCCode(code), "%v", message["error"])
+	}
+	return nil, grpc.Errorf(codes.Internal, "%s", err)
+}
+
+func problemToGRPCCode(problem resources.ResourceProblem) codes.Code {
+	if problem == resources.Unauthorized {
+		return codes.PermissionDenied
+	}
+	return httpStatusToGRPCCode(problemToResponseCode(problem))
+}
+
+func httpStatusToGRPCCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.FailedPrecondition
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	}
+	return codes.Unknown
+}
+
+func protoTag(number, wireType int) uint64 {
+	return uint64(number)<<3 | uint64(wireType)
+}
+
+func encodeProtoMessage(service *schema.ProtoService, message *schema.ProtoMessage, data map[string]interface{}) ([]byte, error) {
+	buffer := proto.NewBuffer(nil)
+	for _, field := range message.Fields {
+		value, ok := data[field.Name]
+		if !ok || value == nil {
+			continue
+		}
+		if err := encodeProtoField(buffer, service, field, value); err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", message.Name, field.Name, err)
+		}
+	}
+	return buffer.Bytes(), nil
+}
+
+func encodeProtoField(buffer *proto.Buffer, service *schema.ProtoService, field *schema.ProtoField, value interface{}) error {
+	switch {
+	case field.Map:
+		entries, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("expected map, got %T", value)
+		}
+		keys := make([]string, 0, len(entries))
+		for key := range entries {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			entry := proto.NewBuffer(nil)
+			encodeProtoValue(entry, service, &schema.ProtoField{Number: 1, Type: schema.ProtoString}, key)
+			if err := encodeProtoValue(entry, service, &schema.ProtoField{Number: 2, Type: field.Type}, entries[key]); err != nil {
+				return err
+			}
+			buffer.EncodeVarint(protoTag(field.Number, protoWireBytes))
+			buffer.EncodeRawBytes(entry.Bytes())
+		}
+		return nil
+	case field.Repeated:
+		list := reflect.ValueOf(value)
+		if list.Kind() != reflect.Slice {
+			return fmt.Errorf("expected list, got %T", value)
+		}
+		for i := 0; i < list.Len(); i++ {
+			if err := encodeProtoValue(buffer, service, field, list.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return encodeProtoValue(buffer, service, field, value)
+}
+
+func encodeProtoValue(buffer *proto.Buffer, service *schema.ProtoService, field *schema.ProtoField, value interface{}) error {
+	switch field.Type {
+	case schema.ProtoString:
+		stringValue, ok := value.(string)
+		if field.JSON {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			stringValue = string(data)
+		} else if !ok {
+			stringValue = fmt.Sprint(value)
+		}
+		buffer.EncodeVarint(protoTag(field.Number, protoWireBytes))
+		return buffer.EncodeStringBytes(stringValue)
+	case schema.ProtoInt64:
+		number, err := protoInt64(value)
+		if err != nil {
+			return err
+		}
+		buffer.EncodeVarint(protoTag(field.Number, protoWireVarint))
+		return buffer.EncodeVarint(uint64(number))
+	case schema.ProtoDouble:
+		number, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+		if err != nil {
+			return fmt.Errorf("expected number, got %v", value)
+		}
+		buffer.EncodeVarint(protoTag(field.Number, protoWireFixed64))
+		return buffer.EncodeFixed64(math.Float64bits(number))
+	case schema.ProtoBool:
+		boolValue, ok := value.(bool)
+		if !ok {
+			return fmt.Errorf("expected boolean, got %T", value)
+		}
+		var varint uint64
+		if boolValue {
+			varint = 1
+		}
+		buffer.EncodeVarint(protoTag(field.Number, protoWireVarint))
+		return buffer.EncodeVarint(varint)
+	}
+	message, ok := service.Message(field.Type)
+	if !ok {
+		return fmt.Errorf("unknown message %s", field.Type)
+	}
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("expected object, got %T", value)
+	}
+	encoded, err := encodeProtoMessage(service, message, data)
+	if err != nil {
+		return err
+	}
+	buffer.EncodeVarint(protoTag(field.Number, protoWireBytes))
+	return buffer.EncodeRawBytes(encoded)
+}
+
+func protoInt64(value interface{}) (int64, error) {
+	number := reflect.ValueOf(value)
+	switch number.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return number.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return int64(number.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return int64(number.Float()), nil
+	}
+	return 0, fmt.Errorf("expected integer, got %T", value)
+}
+
+func decodeProtoMessage(service *schema.ProtoService, message *schema.ProtoMessage, data []byte) (map[string]interface{}, error) {
+	result := map[string]interface{}{}
+	for len(data) > 0 {
+		key, n := proto.DecodeVarint(data)
+		if n == 0 {
+			return nil, fmt.Errorf("%s: invalid field tag", message.Name)
+		}
+		data = data[n:]
+		number, wireType := int(key>>3), int(key&7)
+		var scalar uint64
+		var raw []byte
+		switch wireType {
+		case protoWireVarint:
+			scalar, n = proto.DecodeVarint(data)
+			if n == 0 {
+				return nil, fmt.Errorf("%s: invalid varint", message.Name)
+			}
+			data = data[n:]
+		case protoWireFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("%s: unexpected end of data", message.Name)
+			}
+			scalar = binary.LittleEndian.Uint64(data)
+			data = data[8:]
+		case protoWireFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("%s: unexpected end of data", message.Name)
+			}
+			scalar = uint64(binary.LittleEndian.Uint32(data))
+			data = data[4:]
+		case protoWireBytes:
+			length, n := proto.DecodeVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return nil, fmt.Errorf("%s: unexpected end of data", message.Name)
+			}
+			raw = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return nil, fmt.Errorf("%s: unsupported wire type %d", message.Name, wireType)
+		}
+		field, ok := message.Field(number)
+		if !ok {
+			continue
+		}
+		if err := decodeProtoField(service, field, wireType, scalar, raw, result); err != nil {
+			return nil, fmt.Errorf("%s.%s: %s", message.Name, field.Name, err)
+		}
+	}
+	return result, nil
+}
+
+func decodeProtoField(service *schema.ProtoService, field *schema.ProtoField, wireType int, scalar uint64, raw []byte, result map[string]interface{}) error {
+	switch {
+	case field.Map:
+		entryMessage := &schema.ProtoMessage{
+			Fields: []*schema.ProtoField{
+				{Name: "key", Number: 1, Type: schema.ProtoString},
+				{Name: "value", Number: 2, Type: field.Type},
+			},
+		}
+		entry, err := decodeProtoMessage(service, entryMessage, raw)
+		if err != nil {
+			return err
+		}
+		entries, _ := result[field.Name].(map[string]interface{})
+		if entries == nil {
+			entries = map[string]interface{}{}
+			result[field.Name] = entries
+		}
+		key, _ := entry["key"].(string)
+		entries[key] = entry["value"]
+		return nil
+	case field.Repeated:
+		list, _ := result[field.Name].([]interface{})
+		if wireType == protoWireBytes && isPackableProtoType(field.Type) {
+			values, err := decodePackedProtoValues(field, raw)
+			if err != nil {
+				return err
+			}
+			result[field.Name] = append(list, values...)
+			return nil
+		}
+		value, err := decodeProtoValue(service, field, scalar, raw)
+		if err != nil {
+			return err
+		}
+		result[field.Name] = append(list, value)
+		return nil
+	}
+	value, err := decodeProtoValue(service, field, scalar, raw)
+	if err != nil {
+		return err
+	}
+	result[field.Name] = value
+	return nil
+}
+
+func isPackableProtoType(protoType string) bool {
+	return protoType == schema.ProtoInt64 || protoType == schema.ProtoDouble || protoType == schema.ProtoBool
+}
+
+func decodePackedProtoValues(field *schema.ProtoField, raw []byte) ([]interface{}, error) {
+	values := []interface{}{}
+	for len(raw) > 0 {
+		var scalar uint64
+		if field.Type == schema.ProtoDouble {
+			if len(raw) < 8 {
+				return nil, fmt.Errorf("unexpected end of packed data")
+			}
+			scalar = binary.LittleEndian.Uint64(raw)
+			raw = raw[8:]
+		} else {
+			var n int
+			scalar, n = proto.DecodeVarint(raw)
+			if n == 0 {
+				return nil, fmt.Errorf("invalid packed varint")
+			}
+			raw = raw[n:]
+		}
+		value, err := decodeProtoValue(nil, field, scalar, nil)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+func decodeProtoValue(service *schema.ProtoService, field *schema.ProtoField, scalar uint64, raw []byte) (interface{}, error) {
+	switch field.Type {
+	case schema.ProtoString:
+		if !field.JSON {
+			return string(raw), nil
+		}
+		if len(raw) == 0 {
+			return nil, nil
+		}
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	case schema.ProtoInt64:
+		return int(int64(scalar)), nil
+	case schema.ProtoDouble:
+		return math.Float64frombits(scalar), nil
+	case schema.ProtoBool:
+		return scalar != 0, nil
+	}
+	message, ok := service.Message(field.Type)
+	if !ok {
+		return nil, fmt.Errorf("unknown message %s", field.Type)
+	}
+	return decodeProtoMessage(service, message, raw)
+}
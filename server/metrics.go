@@ -0,0 +1,77 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/go-martini/martini"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsURL = "/gohan/v0.1/metrics"
+
+//Results of syncing events
+const (
+	syncResultSynced     = "synced"
+	syncResultFailed     = "failed"
+	syncResultDeadLetter = "dead_letter"
+)
+
+var (
+	syncEventsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "gohan",
+		Subsystem: "sync",
+		Name:      "events_total",
+		Help:      "Number of processed sync events by result.",
+	}, []string{"result"})
+	syncEventsPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "gohan",
+		Subsystem: "sync",
+		Name:      "events_pending",
+		Help:      "Number of events listed by the last sync pass.",
+	})
+	syncEventDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gohan",
+		Subsystem: "sync",
+		Name:      "event_duration_seconds",
+		Help:      "Time writing an event to sync backend.",
+	})
+	syncPassDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "gohan",
+		Subsystem: "sync",
+		Name:      "pass_duration_seconds",
+		Help:      "Time of a sync pass including deletion of synced events.",
+		Buckets:   []float64{.01, .05, .1, .5, 1, 5, 10, 30, 60, 300},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(syncEventsTotal, syncEventsPending, syncEventDuration, syncPassDuration)
+}
+
+//MapMetricsRoute maps route exposing metrics in Prometheus text format
+func MapMetricsRoute(route martini.Router) {
+	log.Debug("[Path] %s", metricsURL)
+	handler := prometheus.Handler()
+	route.Get(metricsURL, func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		if !authorizeAction(w, schema.ActionRead, metricsURL, auth) {
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
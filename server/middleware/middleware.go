@@ -17,6 +17,7 @@ package middleware
 
 import (
 	"bytes"
+	"crypto/x509"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -92,7 +93,7 @@ func Logging() martini.Handler {
 func filterHeaders(headers http.Header) http.Header {
 	filtered := http.Header{}
 	for k, v := range headers {
-		if k == "X-Auth-Token" {
+		if k == "X-Auth-Token" || k == "Authorization" {
 			filtered[k] = []string{"***"}
 			continue
 		}
@@ -110,6 +111,12 @@ type IdentityService interface {
 	GetClient() *gophercloud.ServiceClient
 }
 
+//CertificateIdentityService authenticates users by verified TLS client certificates
+type CertificateIdentityService interface {
+	IdentityService
+	VerifyCertificate(*x509.Certificate) (schema.Authorization, error)
+}
+
 //NobodyResourceService contains a definition of nobody resources (that do not require authorization)
 type NobodyResourceService interface {
 	VerifyResourcePath(string) bool
@@ -228,9 +235,25 @@ func Authentication() martini.Handler {
 		}
 
 		authToken := req.Header.Get("X-Auth-Token")
+		if authToken == "" {
+			authToken = bearerToken(req)
+		}
 
 		var targetIdentityService IdentityService
 
+		if authToken == "" && req.TLS != nil && len(req.TLS.VerifiedChains) > 0 {
+			if certificateIdentityService, ok := identityService.(CertificateIdentityService); ok {
+				auth, err := certificateIdentityService.VerifyCertificate(req.TLS.VerifiedChains[0][0])
+				if err != nil {
+					HTTPJSONError(res, err.Error(), http.StatusUnauthorized)
+					return
+				}
+				c.Map(auth)
+				c.Next()
+				return
+			}
+		}
+
 		if authToken == "" {
 			if nobodyResourceService.VerifyResourcePath(req.URL.Path) {
 				targetIdentityService = &NobodyIdentityService{}
@@ -254,6 +277,15 @@ func Authentication() martini.Handler {
 	}
 }
 
+func bearerToken(req *http.Request) string {
+	const prefix = "Bearer "
+	authorization := req.Header.Get("Authorization")
+	if len(authorization) > len(prefix) && strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return authorization[len(prefix):]
+	}
+	return ""
+}
+
 //Context type
 type Context map[string]interface{}
 
@@ -269,6 +301,8 @@ func Authorization(action string) martini.Handler {
 	return func(res http.ResponseWriter, req *http.Request, auth schema.Authorization, context Context) {
 		context["tenant_id"] = auth.TenantID()
 		context["tenant_name"] = auth.TenantName()
+		context["domain_id"] = auth.DomainID()
+		context["domain_name"] = auth.DomainName()
 		context["auth_token"] = auth.AuthToken()
 		context["catalog"] = auth.Catalog()
 		context["auth"] = auth
@@ -0,0 +1,366 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+//OpenAPIPath is a path serving OpenAPI 3 document of the API
+const OpenAPIPath = "/gohan/v0.1/openapi.json"
+
+const openAPIVersion = "3.0.0"
+
+var openAPIPathParam = regexp.MustCompile(":([^/]+)")
+
+//MapOpenAPIRoute maps route serving OpenAPI 3 document generated from loaded schemas
+func MapOpenAPIRoute(route martini.Router) {
+	route.Get(OpenAPIPath, func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		routes.ServeJson(w, OpenAPIDocument(auth))
+	})
+}
+
+//OpenAPIDocument returns OpenAPI 3 document describing resources, actions and namespaces
+//available for given authorization
+//Namespaces are listed only when the caller can use a schema in them.
+func OpenAPIDocument(auth schema.Authorization) map[string]interface{} {
+	config := util.GetConfig()
+	manager := schema.GetManager()
+	paths := map[string]interface{}{}
+	componentSchemas := map[string]interface{}{
+		"error": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"error": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+	tags := []interface{}{}
+
+	visibleNamespaces := map[string]bool{}
+	for _, s := range manager.OrderedSchemas() {
+		if s.IsAbstract() {
+			continue
+		}
+		if !addOpenAPISchema(s, auth, paths, componentSchemas) {
+			continue
+		}
+		for namespaceID := s.NamespaceID; namespaceID != "" && !visibleNamespaces[namespaceID]; {
+			visibleNamespaces[namespaceID] = true
+			namespace, ok := manager.Namespace(namespaceID)
+			if !ok {
+				break
+			}
+			namespaceID = namespace.Parent
+		}
+	}
+
+	for _, namespace := range manager.Namespaces() {
+		if !visibleNamespaces[namespace.ID] {
+			continue
+		}
+		tags = append(tags, map[string]interface{}{"name": namespace.ID})
+		if namespace.IsTopLevel() {
+			paths[namespace.GetFullPrefix()+"/"] = map[string]interface{}{
+				"get": openAPINamespaceOperation(namespace, "versions"),
+			}
+		} else {
+			paths[namespace.GetFullPrefix()] = map[string]interface{}{
+				"get": openAPINamespaceOperation(namespace, "resources"),
+			}
+		}
+	}
+
+	document := map[string]interface{}{
+		"openapi": openAPIVersion,
+		"info": map[string]interface{}{
+			"title":   config.GetString("openapi/title", "Gohan API"),
+			"version": config.GetString("openapi/version", "0.1"),
+		},
+		"tags":  tags,
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas":    componentSchemas,
+			"parameters": openAPIListParameters(),
+			"responses": map[string]interface{}{
+				"error": map[string]interface{}{
+					"description": "Error",
+					"content":     openAPIJSONContent(openAPIRef("schemas", "error")),
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{
+					"type": "apiKey",
+					"in":   "header",
+					"name": "X-Auth-Token",
+				},
+			},
+		},
+		"security": []interface{}{
+			map[string]interface{}{"token": []interface{}{}},
+		},
+	}
+	return document
+}
+
+//addOpenAPISchema adds operations on resources of the schema allowed by policies
+//It reports if any operation was added.
+func addOpenAPISchema(s *schema.Schema, auth schema.Authorization, paths, componentSchemas map[string]interface{}) bool {
+	manager := schema.GetManager()
+	pluralPath := toOpenAPIPath(s.GetPluralURL())
+	singlePath := toOpenAPIPath(s.GetSingleURL())
+	tag := s.ID
+	if s.NamespaceID != "" {
+		tag = s.NamespaceID
+	}
+	pluralOperations := map[string]interface{}{}
+	singleOperations := map[string]interface{}{}
+	resultSchema := map[string]interface{}{"type": "object"}
+
+	if policy, _ := manager.PolicyValidate(schema.ActionRead, s.GetPluralURL(), auth); policy != nil {
+		resourceSchema, propertiesOrder := openAPIResourceSchema(policy, s.JSONSchema)
+		componentSchemas[s.ID] = resourceSchema
+		ref := openAPIRef("schemas", s.ID)
+		resultSchema = ref
+
+		parameters := []interface{}{}
+		for _, name := range []string{"limit", "offset", "sort_key", "sort_order"} {
+			parameters = append(parameters, openAPIRef("parameters", name))
+		}
+		for _, property := range propertiesOrder {
+			parameters = append(parameters, openAPIFilterParameter(property))
+		}
+
+		pluralOperations["get"] = map[string]interface{}{
+			"summary":     "List " + s.Plural,
+			"description": s.Description,
+			"operationId": "list_" + s.Plural,
+			"tags":        []string{tag},
+			"parameters":  parameters,
+			"responses": openAPIResponses("200", "List of "+s.Plural, map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					s.Plural: map[string]interface{}{"type": "array", "items": ref},
+				},
+			}, map[string]interface{}{
+				"X-Total-Count": map[string]interface{}{
+					"description": "Number of resources without pagination",
+					"schema":      map[string]interface{}{"type": "integer"},
+				},
+			}),
+		}
+		singleOperations["get"] = map[string]interface{}{
+			"summary":     "Show " + s.Singular,
+			"operationId": "show_" + s.Singular,
+			"tags":        []string{tag},
+			"responses":   openAPIResponses("200", s.Title, openAPIWrapped(s.Singular, ref), nil),
+		}
+	}
+	if policy, _ := manager.PolicyValidate(schema.ActionCreate, s.GetPluralURL(), auth); policy != nil {
+		componentSchemas[s.ID+"_create"], _ = openAPIResourceSchema(policy, s.JSONSchemaOnCreate)
+		pluralOperations["post"] = map[string]interface{}{
+			"summary":     "Create " + s.Singular,
+			"operationId": "create_" + s.Singular,
+			"tags":        []string{tag},
+			"requestBody": openAPIRequestBody(openAPIWrapped(s.Singular, openAPIRef("schemas", s.ID+"_create"))),
+			"responses":   openAPIResponses("201", s.Title+" created", openAPIWrapped(s.Singular, resultSchema), nil),
+		}
+	}
+	if policy, _ := manager.PolicyValidate(schema.ActionUpdate, s.GetSingleURL(), auth); policy != nil {
+		componentSchemas[s.ID+"_update"], _ = openAPIResourceSchema(policy, s.JSONSchemaOnUpdate)
+		body := openAPIWrapped(s.Singular, openAPIRef("schemas", s.ID+"_update"))
+		result := openAPIWrapped(s.Singular, resultSchema)
+		for _, method := range []string{"put", "patch"} {
+			singleOperations[method] = map[string]interface{}{
+				"summary":     "Update " + s.Singular,
+				"operationId": method + "_" + s.Singular,
+				"tags":        []string{tag},
+				"requestBody": openAPIRequestBody(body),
+				"responses":   openAPIResponses("200", s.Title+" updated", result, nil),
+			}
+		}
+	}
+	if policy, _ := manager.PolicyValidate(schema.ActionDelete, s.GetSingleURL(), auth); policy != nil {
+		singleOperations["delete"] = map[string]interface{}{
+			"summary":     "Delete " + s.Singular,
+			"operationId": "delete_" + s.Singular,
+			"tags":        []string{tag},
+			"responses":   openAPIResponses("204", s.Title+" deleted", nil, nil),
+		}
+	}
+	if len(pluralOperations) > 0 {
+		paths[pluralPath] = pluralOperations
+	}
+	if len(singleOperations) > 0 {
+		singleOperations["parameters"] = []interface{}{openAPIPathParameter("id")}
+		paths[singlePath] = singleOperations
+	}
+	visible := len(pluralOperations) > 0 || len(singleOperations) > 0
+
+	for _, action := range s.Actions {
+		actionURL := s.GetActionURL(action.Path)
+		if policy, _ := manager.PolicyValidate(action.ID, actionURL, auth); policy == nil {
+			continue
+		}
+		operation := map[string]interface{}{
+			"summary":     action.Description,
+			"operationId": s.ID + "_" + action.ID,
+			"tags":        []string{tag},
+			"responses":   openAPIResponses("200", action.ID+" result", openAPIActionSchema(action.OutputSchema), nil),
+		}
+		if action.InputSchema != nil {
+			operation["requestBody"] = openAPIRequestBody(openAPIActionSchema(action.InputSchema))
+		}
+		actionPath := toOpenAPIPath(actionURL)
+		operations, ok := paths[actionPath].(map[string]interface{})
+		if !ok {
+			operations = map[string]interface{}{}
+			paths[actionPath] = operations
+		}
+		parameters := []interface{}{}
+		for _, match := range openAPIPathParam.FindAllStringSubmatch(actionURL, -1) {
+			parameters = append(parameters, openAPIPathParameter(match[1]))
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+		operations[strings.ToLower(action.Method)] = operation
+		visible = true
+	}
+	return visible
+}
+
+func openAPINamespaceOperation(namespace *schema.Namespace, key string) map[string]interface{} {
+	return map[string]interface{}{
+		"summary":     "List " + key + " of namespace " + namespace.ID,
+		"operationId": "namespace_" + namespace.ID,
+		"tags":        []string{namespace.ID},
+		"responses": openAPIResponses("200", "List of "+key, map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				key: map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "object"}},
+			},
+		}, nil),
+	}
+}
+
+//openAPIResourceSchema returns JSON schema with properties filtered by the policy
+//and the order of the properties, used to generate filter parameters in order
+func openAPIResourceSchema(policy *schema.Policy, jsonSchema map[string]interface{}) (map[string]interface{}, []string) {
+	properties, propertiesOrder, required := policy.FilterSchema(
+		util.MaybeMap(jsonSchema["properties"]),
+		util.MaybeStringList(jsonSchema["propertiesOrder"]),
+		util.MaybeStringList(jsonSchema["required"]))
+	result := schema.OpenAPISchema(map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+		"required":   required,
+	})
+	return result, propertiesOrder
+}
+
+func openAPIActionSchema(jsonSchema map[string]interface{}) map[string]interface{} {
+	if jsonSchema == nil {
+		return map[string]interface{}{"type": "object"}
+	}
+	return schema.OpenAPISchema(jsonSchema)
+}
+
+func openAPIListParameters() map[string]interface{} {
+	return map[string]interface{}{
+		"limit":      openAPIQueryParameter("limit", "Maximum number of resources returned", map[string]interface{}{"type": "integer", "minimum": 0}),
+		"offset":     openAPIQueryParameter("offset", "Number of resources skipped", map[string]interface{}{"type": "integer", "minimum": 0}),
+		"sort_key":   openAPIQueryParameter("sort_key", "Property used for sorting", map[string]interface{}{"type": "string"}),
+		"sort_order": openAPIQueryParameter("sort_order", "Sorting order", map[string]interface{}{"type": "string", "enum": []string{"asc", "desc"}}),
+	}
+}
+
+func openAPIFilterParameter(property string) map[string]interface{} {
+	parameter := openAPIQueryParameter(property, "Filter by "+property, map[string]interface{}{
+		"type":  "array",
+		"items": map[string]interface{}{"type": "string"},
+	})
+	parameter["explode"] = true
+	return parameter
+}
+
+func openAPIQueryParameter(name, description string, parameterSchema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":        name,
+		"in":          "query",
+		"description": description,
+		"required":    false,
+		"schema":      parameterSchema,
+	}
+}
+
+func openAPIPathParameter(name string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":     name,
+		"in":       "path",
+		"required": true,
+		"schema":   map[string]interface{}{"type": "string"},
+	}
+}
+
+func openAPIRequestBody(bodySchema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"required": true,
+		"content":  openAPIJSONContent(bodySchema),
+	}
+}
+
+func openAPIResponses(code, description string, bodySchema, headers map[string]interface{}) map[string]interface{} {
+	response := map[string]interface{}{"description": description}
+	if bodySchema != nil {
+		response["content"] = openAPIJSONContent(bodySchema)
+	}
+	if headers != nil {
+		response["headers"] = headers
+	}
+	return map[string]interface{}{
+		code:      response,
+		"default": openAPIRef("responses", "error"),
+	}
+}
+
+func openAPIJSONContent(bodySchema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": bodySchema},
+	}
+}
+
+func openAPIWrapped(key string, inner map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": map[string]interface{}{key: inner},
+	}
+}
+
+func openAPIRef(kind, name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/" + kind + "/" + name}
+}
+
+func toOpenAPIPath(path string) string {
+	return openAPIPathParam.ReplaceAllString(path, "{$1}")
+}
@@ -0,0 +1,120 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cloudwan/gohan/schema"
+)
+
+const openAPITestSchema = `
+namespaces:
+- id: neutron
+  prefix: neutron
+- id: neutronV2
+  parent: neutron
+  prefix: v2.0
+- id: hidden
+  prefix: hidden
+policies:
+- action: read
+  effect: allow
+  id: member_read_networks
+  principal: Member
+  resource:
+    path: /neutron/v2.0/networks.*
+- action: update
+  effect: allow
+  id: member_update_network
+  principal: Member
+  resource:
+    path: /neutron/v2.0/networks/[^/]+$
+- action: delete
+  effect: allow
+  id: member_delete_networks
+  principal: Member
+  resource:
+    path: /neutron/v2.0/networks$
+schemas:
+- id: network
+  namespace: neutronV2
+  plural: networks
+  singular: network
+  title: Network
+  description: Network
+  schema:
+    properties:
+      id:
+        type: string
+    type: object
+- id: secret
+  namespace: hidden
+  plural: secrets
+  singular: secret
+  title: Secret
+  description: Secret
+  schema:
+    properties:
+      id:
+        type: string
+    type: object
+`
+
+func TestOpenAPIDocumentFiltersByPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohan_openapi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	schemaFile := filepath.Join(dir, "schema.yaml")
+	if err := ioutil.WriteFile(schemaFile, []byte(openAPITestSchema), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer schema.ClearManager()
+	if err := schema.GetManager().LoadSchemasFromFiles(schemaFile); err != nil {
+		t.Fatal(err)
+	}
+
+	auth := schema.NewAuthorization("member", "member", "token", []string{"Member"}, nil)
+	document := OpenAPIDocument(auth)
+
+	tags := map[string]bool{}
+	for _, tag := range document["tags"].([]interface{}) {
+		tags[tag.(map[string]interface{})["name"].(string)] = true
+	}
+	if !tags["neutron"] || !tags["neutronV2"] || tags["hidden"] {
+		t.Errorf("Expected only namespaces of visible schemas with their parents, got %v", tags)
+	}
+
+	paths := document["paths"].(map[string]interface{})
+	if _, ok := paths["/hidden/"]; ok {
+		t.Error("Expected hidden namespace not to be described")
+	}
+	network, ok := paths["/neutron/v2.0/networks/{id}"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected network to be described, got %v", paths)
+	}
+	if _, ok := network["put"]; !ok {
+		t.Error("Expected update allowed on the single resource URL to be described")
+	}
+	if _, ok := network["delete"]; ok {
+		t.Error("Expected delete allowed only on the plural URL not to be described")
+	}
+}
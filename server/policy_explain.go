@@ -0,0 +1,86 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+//policyExplainAction is an action of policies allowing to explain policy decisions
+const policyExplainAction = "explain"
+
+//PolicyExplainRequest describes a request to be explained
+//If neither principal nor roles are given, the caller's authorization is explained.
+type PolicyExplainRequest struct {
+	Action     string                 `json:"action"`
+	Path       string                 `json:"path"`
+	Principal  string                 `json:"principal"`
+	Roles      []string               `json:"roles"`
+	TenantID   string                 `json:"tenant_id"`
+	TenantName string                 `json:"tenant_name"`
+	DomainID   string                 `json:"domain_id"`
+	DomainName string                 `json:"domain_name"`
+	Resource   map[string]interface{} `json:"resource"`
+}
+
+//Explain explains the policy decision on the request
+func (request *PolicyExplainRequest) Explain(auth schema.Authorization) (*schema.PolicyExplanation, error) {
+	if request.Action == "" || request.Path == "" {
+		return nil, fmt.Errorf("Both action and path are required")
+	}
+	roles := request.Roles
+	if request.Principal != "" {
+		roles = append(roles, request.Principal)
+	}
+	if len(roles) > 0 {
+		auth = schema.NewDomainAuthorization(request.TenantID, request.TenantName, request.DomainID, request.DomainName, "", roles, nil)
+	}
+	return schema.GetManager().ExplainPolicy(request.Action, request.Path, auth, request.Resource), nil
+}
+
+//MapPolicyExplainRoute maps route explaining policy decisions
+func MapPolicyExplainRoute(route martini.Router) {
+	policySchema, ok := schema.GetManager().Schema("policy")
+	if !ok {
+		return
+	}
+	explainURL := policySchema.GetPluralURL() + "/explain"
+	log.Debug("[Path] %s", explainURL)
+	route.Post(explainURL, func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		addJSONContentTypeHeader(w)
+		if !authorizeAction(w, policyExplainAction, explainURL, auth) {
+			return
+		}
+		request := &PolicyExplainRequest{}
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		explanation, err := request.Explain(auth)
+		if err != nil {
+			middleware.HTTPJSONError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		routes.ServeJson(w, explanation)
+	})
+}
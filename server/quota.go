@@ -0,0 +1,68 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+//MapQuotaRoutes maps route reporting quota usage of a tenant
+func MapQuotaRoutes(route martini.Router, dataStore db.DB) {
+	quotaSchema, ok := schema.GetManager().Schema(resources.QuotaSchemaID)
+	if !ok {
+		return
+	}
+	usageURL := quotaSchema.GetPluralURL() + "/:tenant/usage"
+	log.Debug("[Path] %s", usageURL)
+	route.Get(usageURL, func(w http.ResponseWriter, r *http.Request, p martini.Params, auth schema.Authorization) {
+		addJSONContentTypeHeader(w)
+		tenantID := p["tenant"]
+		policy, _ := authorization(w, r, schema.ActionRead, r.URL.Path, quotaSchema, auth)
+		if policy == nil {
+			middleware.HTTPJSONError(w, fmt.Sprintf("No matching policy: %s %s", schema.ActionRead, r.URL.Path), http.StatusUnauthorized)
+			return
+		}
+		if tenantFilter := policy.GetTenantIDFilter(schema.ActionRead, auth.TenantID()); tenantFilter != nil && !util.ContainsString(tenantFilter, tenantID) {
+			middleware.HTTPJSONError(w, fmt.Sprintf("Tenant %s is not allowed to read usage of tenant %s", auth.TenantID(), tenantID), http.StatusForbidden)
+			return
+		}
+		tx, err := dataStore.Begin()
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		defer tx.Close()
+		usage, err := resources.GetQuotaUsage(tx, tenantID)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		if err := tx.Commit(); err != nil {
+			handleError(w, err)
+			return
+		}
+		routes.ServeJson(w, map[string]interface{}{"tenant_id": tenantID, "usage": usage})
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"testing"
+
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/db/transaction/mocks"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestGetQuotaLocksOnlyTenantQuota(t *testing.T) {
+	defer schema.ClearManager()
+	if err := schema.GetManager().LoadSchemasFromFiles("../etc/schema/gohan.json", "../tests/test_abstract_schema.yaml", "../tests/test_schema.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	manager := schema.GetManager()
+	quotaSchema, _ := manager.Schema(resources.QuotaSchemaID)
+	networkSchema, _ := manager.Schema("network")
+	defaultQuota, _ := schema.NewResource(quotaSchema, map[string]interface{}{
+		"id": "default", "schema_id": "network", "target_tenant_id": "", "max_count": 3,
+	})
+
+	tx := &mocks.Transaction{}
+	tx.On("LockList", quotaSchema, transaction.Filter{"schema_id": "network", "target_tenant_id": "tenant"},
+		(*pagination.Paginator)(nil), transaction.SkipRelatedResources).Return([]*schema.Resource{}, uint64(0), nil)
+	tx.On("List", quotaSchema, transaction.Filter{"schema_id": "network", "target_tenant_id": ""},
+		(*pagination.Paginator)(nil)).Return([]*schema.Resource{defaultQuota}, uint64(1), nil)
+
+	maxCount, err := resources.GetQuota(tx, networkSchema, "tenant")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maxCount != 3 {
+		t.Errorf("Expected the default quota 3, got %d", maxCount)
+	}
+	tx.AssertNumberOfCalls(t, "LockList", 1)
+	tx.AssertNotCalled(t, "LockList", quotaSchema, transaction.Filter{"schema_id": "network", "target_tenant_id": ""},
+		mock.Anything, mock.Anything)
+}
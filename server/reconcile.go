@@ -0,0 +1,315 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/server/resources"
+	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/util"
+	"github.com/drone/routes"
+	"github.com/go-martini/martini"
+)
+
+const (
+	reconcileURL = "/gohan/v0.1/sync/reconcile"
+	//reconcileAction is an action of policies allowing to reconcile sync
+	reconcileAction = "reconcile"
+)
+
+//SyncReconcileReport lists keys of the sync backend which differ from the database
+type SyncReconcileReport struct {
+	DryRun  bool     `json:"dry_run"`
+	Missing []string `json:"missing"`
+	Stale   []string `json:"stale"`
+	Orphan  []string `json:"orphan"`
+	//Changed keys were written by others while reconciling and are left as they are
+	Changed []string `json:"changed"`
+}
+
+//expectedValue is the value of a resource key computed from the database
+type expectedValue struct {
+	content string
+	//versions are compared only for schemas with state versioning
+	versioned bool
+}
+
+//ReconcileSync makes resource keys in the sync backend match the database
+//Missing and stale keys are written and orphan keys under resource prefixes are deleted
+//with their state and monitoring keys. Keys of resources with pending events are
+//left to the sync process. Nothing is changed when dryRun is true.
+//The sync backend is read before the database and keys are written only if their revision
+//didn't change since, so values synced meanwhile by the sync process are never overwritten.
+func ReconcileSync(dataStore db.DB, backend sync.Sync, dryRun bool) (*SyncReconcileReport, error) {
+	existing := map[string]*sync.Node{}
+	for _, prefix := range syncPrefixes() {
+		node, err := backend.Fetch(prefix)
+		if err != nil {
+			log.Debug("nothing found under %s: %s", prefix, err)
+			continue
+		}
+		collectSyncValues(node, existing)
+	}
+	expected, pending, err := expectedSyncValues(dataStore)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SyncReconcileReport{DryRun: dryRun, Missing: []string{}, Stale: []string{}, Orphan: []string{}, Changed: []string{}}
+	//apply writes unless the key changed since it was fetched, a missing key has revision 0
+	apply := func(key string, ops ...*sync.Op) error {
+		var revision int64
+		if node, ok := existing[key]; ok {
+			revision = node.Revision
+		}
+		condition := &sync.Condition{Key: key, Target: sync.TargetRevision, Operator: "=", Revision: revision}
+		succeeded, err := backend.Txn([]*sync.Condition{condition}, ops)
+		if err != nil {
+			return err
+		}
+		if !succeeded {
+			log.Info("%s changed while reconciling, leaving it", key)
+			report.Changed = append(report.Changed, key)
+		}
+		return nil
+	}
+	expectedKeys := []string{}
+	for key := range expected {
+		expectedKeys = append(expectedKeys, key)
+	}
+	sort.Strings(expectedKeys)
+	for _, key := range expectedKeys {
+		if pending[key] {
+			continue
+		}
+		value := expected[key]
+		current, ok := existing[key]
+		if !ok {
+			report.Missing = append(report.Missing, key)
+		} else if !sameSyncValue(current.Value, value) {
+			report.Stale = append(report.Stale, key)
+		} else {
+			continue
+		}
+		if dryRun {
+			continue
+		}
+		if err := apply(key, &sync.Op{Action: sync.OpUpdate, Key: key, Value: value.content}); err != nil {
+			return nil, err
+		}
+	}
+	existingKeys := []string{}
+	for key := range existing {
+		existingKeys = append(existingKeys, key)
+	}
+	sort.Strings(existingKeys)
+	for _, key := range existingKeys {
+		if _, ok := expected[key]; ok || pending[key] {
+			continue
+		}
+		report.Orphan = append(report.Orphan, key)
+		if dryRun {
+			continue
+		}
+		resourcePath := strings.TrimPrefix(key, configPrefix)
+		ops := []*sync.Op{}
+		for _, orphanKey := range []string{key, statePrefix + resourcePath, monitoringPrefix + resourcePath} {
+			ops = append(ops, &sync.Op{Action: sync.OpDelete, Key: orphanKey})
+		}
+		if err := apply(key, ops...); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+//syncPrefixes returns prefixes containing resource keys of all synced schemas
+func syncPrefixes() []string {
+	prefixes := []string{}
+	for _, s := range schema.GetManager().OrderedSchemas() {
+		if s.IsAbstract() || s.Metadata["nosync"] == true {
+			continue
+		}
+		prefixes = append(prefixes, syncPrefix(s))
+	}
+	return mergePrefixes(prefixes)
+}
+
+//expectedSyncValues computes resource keys of all synced schemas and keys with pending events
+func expectedSyncValues(dataStore db.DB) (map[string]expectedValue, map[string]bool, error) {
+	tx, err := dataStore.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Close()
+	schemaManager := schema.GetManager()
+
+	pending := map[string]bool{}
+	eventSchema, _ := schemaManager.Schema("event")
+	events, _, err := tx.List(eventSchema, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, event := range events {
+		resourcePath := util.MaybeString(event.Get("path"))
+		if schema.GetSchemaByURLPath(resourcePath) == nil {
+			continue
+		}
+		pending[generatePath(resourcePath, util.MaybeString(event.Get("body")))] = true
+	}
+
+	expected := map[string]expectedValue{}
+	for _, s := range schemaManager.OrderedSchemas() {
+		if s.IsAbstract() || s.Metadata["nosync"] == true {
+			continue
+		}
+		syncPlain, syncProperty := syncOptions(s)
+		list, _, err := tx.List(s, nil, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, resource := range list {
+			body, err := resource.JSONString()
+			if err != nil {
+				return nil, nil, err
+			}
+			version := 0
+			if s.StateVersioning() {
+				state, err := tx.StateFetch(s, transaction.IDFilter(resource.ID()))
+				if err != nil {
+					return nil, nil, err
+				}
+				version = int(state.ConfigVersion)
+			}
+			content, err := syncValue(body, version, syncPlain, syncProperty)
+			if err != nil {
+				log.Warning("skipping %s: %s", resource.Path(), err)
+				continue
+			}
+			expected[generatePath(resource.Path(), body)] = expectedValue{
+				content:   content,
+				versioned: s.StateVersioning(),
+			}
+		}
+	}
+	return expected, pending, nil
+}
+
+//syncPrefix returns the static directory of resource keys of the schema
+func syncPrefix(s *schema.Schema) string {
+	path := s.URL
+	if syncKeyTemplate, ok := s.SyncKeyTemplate(); ok {
+		path = syncKeyTemplate
+	}
+	if i := strings.IndexAny(path, "{:"); i >= 0 {
+		path = path[:strings.LastIndex(path[:i], "/")+1]
+	}
+	return configPrefix + strings.TrimSuffix(path, "/")
+}
+
+//mergePrefixes removes duplicated prefixes and ones nested in others
+func mergePrefixes(prefixes []string) []string {
+	sort.Strings(prefixes)
+	merged := []string{}
+	for _, prefix := range prefixes {
+		if len(merged) > 0 {
+			last := merged[len(merged)-1]
+			if prefix == last || strings.HasPrefix(prefix, last+"/") {
+				continue
+			}
+		}
+		merged = append(merged, prefix)
+	}
+	return merged
+}
+
+func collectSyncValues(node *sync.Node, values map[string]*sync.Node) {
+	if node.Value != "" {
+		values[node.Key] = node
+	}
+	for _, child := range node.Children {
+		collectSyncValues(child, values)
+	}
+}
+
+//sameSyncValue compares values as JSON, ignoring versions of schemas without state versioning
+func sameSyncValue(current string, value expectedValue) bool {
+	if current == value.content {
+		return true
+	}
+	a, b := decodeSyncValue(current), decodeSyncValue(value.content)
+	if !value.versioned {
+		for _, decoded := range []interface{}{a, b} {
+			if wrapped, ok := decoded.(map[string]interface{}); ok {
+				delete(wrapped, "version")
+			}
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+//decodeSyncValue decodes a value and the body wrapped in it
+func decodeSyncValue(value string) interface{} {
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return value
+	}
+	if wrapped, ok := decoded.(map[string]interface{}); ok {
+		if body, ok := wrapped["body"].(string); ok {
+			wrapped["body"] = decodeSyncValue(body)
+		}
+	}
+	return decoded
+}
+
+//MapSyncReconcileRoute maps route reconciling the sync backend with the database
+func MapSyncReconcileRoute(route martini.Router, dataStore db.DB, backend sync.Sync) {
+	if backend == nil {
+		return
+	}
+	log.Debug("[Path] %s", reconcileURL)
+	route.Post(reconcileURL, func(w http.ResponseWriter, r *http.Request, auth schema.Authorization) {
+		addJSONContentTypeHeader(w)
+		if !authorizeAction(w, reconcileAction, reconcileURL, auth) {
+			return
+		}
+		dryRun := false
+		if dryRunParam := r.URL.Query().Get(resources.DryRunKey); dryRunParam != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+				middleware.HTTPJSONError(w, fmt.Sprintf("Invalid %s parameter: %s", resources.DryRunKey, dryRunParam), http.StatusBadRequest)
+				return
+			}
+		}
+		report, err := ReconcileSync(dataStore, backend, dryRun)
+		if err != nil {
+			handleError(w, err)
+			return
+		}
+		routes.ServeJson(w, report)
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+func TestReconcileSync(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+
+	reconcile := func(dryRun bool, missing, stale, orphan []string) {
+		report, err := ReconcileSync(dataStore, backend, dryRun)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := &SyncReconcileReport{DryRun: dryRun, Missing: missing, Stale: stale, Orphan: orphan, Changed: []string{}}
+		if !reflect.DeepEqual(report, expected) {
+			t.Errorf("Expected %+v, got %+v", expected, report)
+		}
+	}
+
+	network := createSyncedResource(t, dataStore, "network", map[string]interface{}{
+		"id": "red", "name": "red", "description": "", "tenant_id": "red",
+		"providor_networks": map[string]interface{}{}, "route_targets": []interface{}{},
+		"shared": false,
+	})
+	withSyncProperty := createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r0", "p0": "property0"})
+	if err := server.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	reconcile(true, []string{}, []string{}, []string{})
+
+	networkKey := configPrefix + network.Path()
+	withSyncPropertyKey := configPrefix + withSyncProperty.Path()
+	orphanKey := configPrefix + "/v2.0/networks/orphan"
+	backend.Delete(networkKey)
+	backend.Update(withSyncPropertyKey, `"stale"`)
+	backend.Update(orphanKey, `{"body": "{}", "version": 1}`)
+	backend.Update(statePrefix+"/v2.0/networks/orphan", `{"state": "up"}`)
+	createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r1", "p0": "pending"})
+
+	reconcile(true, []string{networkKey}, []string{withSyncPropertyKey}, []string{orphanKey})
+	if _, err := backend.Fetch(orphanKey); err != nil {
+		t.Error("Expected dry run not to change sync backend")
+	}
+	reconcile(false, []string{networkKey}, []string{withSyncPropertyKey}, []string{orphanKey})
+	reconcile(true, []string{}, []string{}, []string{})
+	if _, err := backend.Fetch(statePrefix + "/v2.0/networks/orphan"); err == nil {
+		t.Error("Expected state of orphan to be deleted")
+	}
+	if node, err := backend.Fetch(withSyncPropertyKey); err != nil || node.Value != `{"body":"\"property0\"","version":1}` {
+		t.Errorf("Expected stale key to be rewritten, got %+v", node)
+	}
+}
+
+//racingSync syncs a newer value of the key right before each transaction
+type racingSync struct {
+	*memory.Sync
+	key, value string
+}
+
+func (s *racingSync) Txn(conditions []*gohan_sync.Condition, ops []*gohan_sync.Op) (bool, error) {
+	if err := s.Sync.Update(s.key, s.value); err != nil {
+		return false, err
+	}
+	return s.Sync.Txn(conditions, ops)
+}
+
+func TestReconcileSyncKeepsConcurrentWrites(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+
+	resource := createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r0", "p0": "property0"})
+	if err := server.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	key := configPrefix + resource.Path()
+	backend.Update(key, `"stale"`)
+	synced := `{"body":"\"newer\"","version":2}`
+
+	report, err := ReconcileSync(dataStore, &racingSync{Sync: backend, key: key, value: synced}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(report.Changed, []string{key}) {
+		t.Errorf("Expected %s to be reported as changed, got %+v", key, report)
+	}
+	if node, err := backend.Fetch(key); err != nil || node.Value != synced {
+		t.Errorf("Expected value synced meanwhile to be kept, got %+v", node)
+	}
+}
@@ -0,0 +1,325 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/extension"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/go-martini/martini"
+)
+
+//ReloadPath is a sync path used to notify API nodes about changes of reloaded resources
+const ReloadPath = "/gohan/reload"
+
+//reloadedSchemas lists schemas whose resources are loaded into the schema manager
+var reloadedSchemas = []string{"policy", "extension", "namespace"}
+
+//reloadState serializes reloads
+type reloadState struct {
+	mu   sync.Mutex
+	stop chan bool
+}
+
+//routerState holds the router serving requests
+//Routes are mapped on a new router, which replaces the current one once all routes are mapped.
+type routerState struct {
+	mu      sync.Mutex
+	current atomic.Value
+}
+
+//handleRoute dispatches the request to the current router
+func (server *Server) handleRoute(w http.ResponseWriter, r *http.Request, c martini.Context) {
+	router := server.routerState.current.Load().(martini.Router)
+	c.MapTo(router, (*martini.Routes)(nil))
+	router.Handle(w, r, c)
+}
+
+//remapRoutes maps routes on a new router and swaps it in,
+//so requests being served never see a partially mapped router
+func (server *Server) remapRoutes() {
+	server.routerState.mu.Lock()
+	defer server.routerState.mu.Unlock()
+	server.martini.Router = martini.NewRouter()
+	server.addOptionsRoute()
+	server.mapRoutes()
+	server.routerState.current.Store(server.martini.Router)
+}
+
+func isReloaded(schemaID string) bool {
+	for _, reloaded := range reloadedSchemas {
+		if reloaded == schemaID {
+			return true
+		}
+	}
+	return false
+}
+
+//DbReloadWrapper wraps db.DB so committed changes of policies,
+//extensions and namespaces are reloaded by all API nodes.
+//Reloads run after the commit, out of the committing request, so they can't fail or stall it.
+type DbReloadWrapper struct {
+	db.DB
+	server *Server
+}
+
+// Begin wraps transaction object with reload
+func (rw *DbReloadWrapper) Begin() (transaction.Transaction, error) {
+	tx, err := rw.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &reloadTransaction{Transaction: tx, server: rw.server, changed: map[string]bool{}}, nil
+}
+
+type reloadTransaction struct {
+	transaction.Transaction
+	server  *Server
+	changed map[string]bool
+}
+
+func (tx *reloadTransaction) markChanged(s *schema.Schema) {
+	if isReloaded(s.ID) {
+		tx.changed[s.ID] = true
+	}
+}
+
+func (tx *reloadTransaction) Create(resource *schema.Resource) error {
+	if err := tx.Transaction.Create(resource); err != nil {
+		return err
+	}
+	tx.markChanged(resource.Schema())
+	return nil
+}
+
+func (tx *reloadTransaction) Update(resource *schema.Resource) error {
+	if err := tx.Transaction.Update(resource); err != nil {
+		return err
+	}
+	tx.markChanged(resource.Schema())
+	return nil
+}
+
+func (tx *reloadTransaction) Delete(s *schema.Schema, resourceID interface{}) error {
+	if err := tx.Transaction.Delete(s, resourceID); err != nil {
+		return err
+	}
+	tx.markChanged(s)
+	return nil
+}
+
+func (tx *reloadTransaction) DeleteFilter(s *schema.Schema, filter transaction.Filter) error {
+	if err := tx.Transaction.DeleteFilter(s, filter); err != nil {
+		return err
+	}
+	tx.markChanged(s)
+	return nil
+}
+
+func (tx *reloadTransaction) Commit() error {
+	if err := tx.Transaction.Commit(); err != nil {
+		return err
+	}
+	if len(tx.changed) == 0 {
+		return nil
+	}
+	schemaIDs := []string{}
+	for _, schemaID := range reloadedSchemas {
+		if tx.changed[schemaID] {
+			schemaIDs = append(schemaIDs, schemaID)
+		}
+	}
+	tx.changed = map[string]bool{}
+	tx.server.triggerReload(schemaIDs)
+	return nil
+}
+
+//triggerReload reloads given schemas on all API nodes in the background
+//With etcd, every node including this one reloads them when notified under ReloadPath.
+//Schemas whose notification failed, or all of them without etcd, are reloaded here.
+func (server *Server) triggerReload(schemaIDs []string) {
+	local := server.notifyReload(schemaIDs)
+	if len(local) == 0 {
+		return
+	}
+	go func() {
+		defer l.LogFatalPanic(log)
+		if err := server.reload(local...); err != nil {
+			log.Error("Failed to reload %s: %s", strings.Join(local, ", "), err)
+		}
+	}()
+}
+
+//reload replaces policies, extensions or namespaces of given schemas with ones stored in db
+func (server *Server) reload(schemaIDs ...string) error {
+	server.reloadState.mu.Lock()
+	defer server.reloadState.mu.Unlock()
+
+	manager := schema.GetManager()
+	lists := map[string][]*schema.Resource{}
+	tx, err := server.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	for _, schemaID := range schemaIDs {
+		s, ok := manager.Schema(schemaID)
+		if !ok {
+			continue
+		}
+		list, _, err := tx.List(s, nil, nil)
+		if err != nil {
+			return err
+		}
+		lists[schemaID] = list
+	}
+
+	if list, ok := lists["policy"]; ok {
+		if err := manager.ReloadPolicies(list); err != nil {
+			return err
+		}
+		log.Info("Reloaded %d policies", len(list))
+	}
+	if list, ok := lists["extension"]; ok {
+		changed, err := manager.ReloadExtensions(list)
+		if err != nil {
+			return err
+		}
+		if err := server.rebuildEnvironments(changed); err != nil {
+			return err
+		}
+		log.Info("Reloaded %d extensions", len(list))
+	}
+	if list, ok := lists["namespace"]; ok {
+		if err := manager.ReloadNamespaces(list); err != nil {
+			return err
+		}
+		server.remapRoutes()
+		log.Info("Reloaded %d namespaces", len(list))
+	}
+	return nil
+}
+
+//rebuildEnvironments replaces environments of schemas whose path matches any of changed extensions
+//Environments are replaced only if all of them are built successfully.
+func (server *Server) rebuildEnvironments(changed []*schema.Extension) error {
+	if len(changed) == 0 {
+		return nil
+	}
+	environments := map[string]extension.Environment{}
+	for _, s := range schema.GetManager().Schemas() {
+		if s.IsAbstract() {
+			continue
+		}
+		pluralURL := s.GetPluralURL()
+		for _, changedExtension := range changed {
+			if !changedExtension.Match(pluralURL) {
+				continue
+			}
+			env, err := server.NewEnvironmentForPath(s.ID, pluralURL)
+			if err != nil {
+				return fmt.Errorf("[%s] %v", pluralURL, err)
+			}
+			environments[s.ID] = env
+			break
+		}
+	}
+	environmentManager := extension.GetManager()
+	for schemaID, env := range environments {
+		environmentManager.ReplaceEnvironment(schemaID, env)
+	}
+	return nil
+}
+
+//notifyReload tells all API nodes to reload given schemas
+//It returns schemas which couldn't be notified.
+func (server *Server) notifyReload(schemaIDs []string) []string {
+	if server.sync == nil {
+		return schemaIDs
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"time": time.Now().UnixNano(),
+	})
+	failed := []string{}
+	for _, schemaID := range schemaIDs {
+		if err := server.sync.Update(ReloadPath+"/"+schemaID, string(data)); err != nil {
+			log.Warning("Failed to notify reload of %s: %s", schemaID, err)
+			failed = append(failed, schemaID)
+		}
+	}
+	return failed
+}
+
+//startReloadWatchProcess reloads resources changed on any API node
+func startReloadWatchProcess(server *Server) {
+	events := make(chan *gohan_sync.Event, 16)
+	server.reloadState.stop = make(chan bool)
+	stop := server.reloadState.stop
+
+	go func() {
+		defer l.LogFatalPanic(log)
+		for server.running {
+			err := server.sync.Watch(ReloadPath, events, stop, gohan_sync.RevisionCurrent)
+			if err != nil {
+				log.Error(fmt.Sprintf("reload watch error: %s", err))
+				select {
+				case <-stop:
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer l.LogFatalPanic(log)
+		for {
+			select {
+			case <-stop:
+				return
+			case event := <-events:
+				if event.Action == "delete" {
+					continue
+				}
+				schemaID := strings.TrimPrefix(event.Key, ReloadPath+"/")
+				if !isReloaded(schemaID) {
+					continue
+				}
+				if err := server.reload(schemaID); err != nil {
+					log.Error("Failed to reload %s: %s", schemaID, err)
+				}
+			}
+		}
+	}()
+}
+
+func stopReloadWatchProcess(server *Server) {
+	if server.reloadState.stop != nil {
+		close(server.reloadState.stop)
+		server.reloadState.stop = nil
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	"github.com/go-martini/martini"
+)
+
+func TestHandleRouteSwapsRouter(t *testing.T) {
+	server := &Server{}
+	m := martini.Classic()
+	m.Action(server.handleRoute)
+
+	newRouter := func(path string) martini.Router {
+		router := martini.NewRouter()
+		router.Get(path, func(w http.ResponseWriter) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return router
+	}
+	get := func(path string) int {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("GET", path, nil)
+		m.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	server.routerState.current.Store(newRouter("/old"))
+	if code := get("/old"); code != http.StatusNoContent {
+		t.Errorf("expected %d from the current router, got %d", http.StatusNoContent, code)
+	}
+
+	server.routerState.current.Store(newRouter("/new"))
+	if code := get("/new"); code != http.StatusNoContent {
+		t.Errorf("expected %d from the swapped router, got %d", http.StatusNoContent, code)
+	}
+	if code := get("/old"); code != http.StatusNotFound {
+		t.Errorf("expected routes of the previous router to be gone, got %d", code)
+	}
+}
+
+func TestReloadAfterCommit(t *testing.T) {
+	server, dataStore, _, cleanup := newTestSyncServer(t)
+	defer cleanup()
+	server.sync = nil
+
+	manager := schema.GetManager()
+	auth := schema.NewAuthorization("member", "member", "token", []string{"Member"}, nil)
+	if policy, _ := manager.PolicyValidate("read", "/v2.0/admin_onlys", auth); policy != nil {
+		t.Fatalf("Expected no policy before the change, got %s", policy.ID)
+	}
+
+	resource, err := manager.LoadResource("policy", map[string]interface{}{
+		"id":        "member_admin_only",
+		"principal": "Member",
+		"action":    "read",
+		"effect":    "allow",
+		"resource":  map[string]interface{}{"path": "/v2.0/admin_onlys"},
+		"priority":  0,
+		"condition": []interface{}{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := (&DbReloadWrapper{DB: dataStore, server: server}).Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	if err := tx.Create(resource); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if policy, _ := manager.PolicyValidate("read", "/v2.0/admin_onlys", auth); policy != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the committed policy to be reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
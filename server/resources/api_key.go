@@ -0,0 +1,197 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+)
+
+//APIKeySchemaID is an ID of the core schema defining API keys
+const APIKeySchemaID = "api_key"
+
+//AdminRole is a role given to built-in admin identities like the admin token
+const AdminRole = "admin"
+
+const (
+	apiKeySecretHashProperty = "secret_hash"
+	apiKeySecretProperty     = "secret"
+	apiKeySecretContextKey   = "api_key_secret"
+	apiKeySecretLength       = 32
+	apiKeyHashPrefix         = "sha256:"
+)
+
+//APIKey describes an active API key
+type APIKey struct {
+	ID       string
+	TenantID string
+	Roles    []string
+}
+
+//VerifyAPIKey checks the token against stored API keys
+//Token has form of <key ID>.<secret>. ok is false when the token doesn't name an existing key.
+func VerifyAPIKey(dataStore db.DB, token string) (apiKey *APIKey, ok bool, err error) {
+	apiKeySchema, found := schema.GetManager().Schema(APIKeySchemaID)
+	if !found {
+		return nil, false, nil
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, false, nil
+	}
+	tx, err := dataStore.Begin()
+	if err != nil {
+		return nil, false, err
+	}
+	defer tx.Close()
+	//Other errors than a missing key fail the request instead of passing the token to other identity services
+	list, _, err := tx.List(apiKeySchema, transaction.IDFilter(parts[0]), nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(list) == 0 {
+		return nil, false, nil
+	}
+	resource := list[0]
+	storedHash, _ := resource.Get(apiKeySecretHashProperty).(string)
+	if subtle.ConstantTimeCompare([]byte(storedHash), []byte(HashAPIKeySecret(parts[1]))) != 1 {
+		return nil, true, fmt.Errorf("Invalid API key")
+	}
+	if revoked, _ := resource.Get("revoked").(bool); revoked {
+		return nil, true, fmt.Errorf("API key %s is revoked", resource.ID())
+	}
+	if expiresAt, _ := resource.Get("expires_at").(string); expiresAt != "" {
+		expiration, err := time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return nil, true, fmt.Errorf("API key %s has invalid expiration: %s", resource.ID(), err)
+		}
+		if time.Now().After(expiration) {
+			return nil, true, fmt.Errorf("API key %s is expired", resource.ID())
+		}
+	}
+	tenantID, _ := resource.Get("tenant_id").(string)
+	roles := []string{}
+	rawRoles, _ := resource.Get("roles").([]interface{})
+	for _, role := range rawRoles {
+		if name, ok := role.(string); ok {
+			roles = append(roles, name)
+		}
+	}
+	return &APIKey{ID: resource.ID(), TenantID: tenantID, Roles: roles}, true, nil
+}
+
+//RotateAPIKey replaces the secret of the API key specified by ID
+//The new secret is returned in the response and can't be retrieved later.
+func RotateAPIKey(context middleware.Context, dataStore db.DB, resourceID string) error {
+	apiKeySchema, ok := schema.GetManager().Schema(APIKeySchemaID)
+	if !ok {
+		return ResourceError{fmt.Errorf("No %s schema", APIKeySchemaID), "", NotFound}
+	}
+	context["id"] = resourceID
+	auth := context["auth"].(schema.Authorization)
+	if _, err := loadPolicy(context, schema.ActionUpdate, strings.Replace(apiKeySchema.GetSingleURL(), ":id", resourceID, 1), apiKeySchema, auth); err != nil {
+		return err
+	}
+	return InTransaction(
+		context, dataStore,
+		transaction.GetIsolationLevel(apiKeySchema, schema.ActionUpdate),
+		func() error {
+			tx := context["transaction"].(transaction.Transaction)
+			resource, err := tx.Fetch(apiKeySchema, transaction.IDFilter(resourceID))
+			if err != nil {
+				return ResourceError{err, "", NotFound}
+			}
+			if err := SetAPIKeySecret(context, resource.Data()); err != nil {
+				return err
+			}
+			if err := tx.Update(resource); err != nil {
+				return ResourceError{err, fmt.Sprintf("Failed to rotate API key: %v", err), UpdateFailed}
+			}
+			context["response"] = map[string]interface{}{
+				apiKeySchema.Singular: apiKeyResponse(context, resource.Data()),
+			}
+			return nil
+		},
+	)
+}
+
+//SetAPIKeySecret generates a secret of the API key and stores its hash in data
+//The secret is kept in the context to be returned in the response of this request only.
+func SetAPIKeySecret(context middleware.Context, data map[string]interface{}) error {
+	id, _ := data["id"].(string)
+	secret := make([]byte, apiKeySecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return fmt.Errorf("Failed to generate API key secret: %s", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	data[apiKeySecretHashProperty] = HashAPIKeySecret(encoded)
+	context[apiKeySecretContextKey] = id + "." + encoded
+	return nil
+}
+
+//RedactAPIKeyResponse removes secret hashes from API keys in the response of the context,
+//adding the secret if it was generated in this request
+func RedactAPIKeyResponse(context middleware.Context, apiKeySchema *schema.Schema) {
+	response, ok := context["response"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	if data, ok := response[apiKeySchema.Singular].(map[string]interface{}); ok {
+		response[apiKeySchema.Singular] = apiKeyResponse(context, data)
+	}
+	if list, ok := response[apiKeySchema.Plural].([]interface{}); ok {
+		redacted := make([]interface{}, 0, len(list))
+		for _, item := range list {
+			if data, ok := item.(map[string]interface{}); ok {
+				item = apiKeyResponse(context, data)
+			}
+			redacted = append(redacted, item)
+		}
+		response[apiKeySchema.Plural] = redacted
+	}
+}
+
+//apiKeyResponse returns a copy of API key data without the secret hash,
+//with the secret included if it was generated in this request
+func apiKeyResponse(context middleware.Context, data map[string]interface{}) map[string]interface{} {
+	response := map[string]interface{}{}
+	for key, value := range data {
+		if key != apiKeySecretHashProperty {
+			response[key] = value
+		}
+	}
+	if secret, ok := context[apiKeySecretContextKey].(string); ok && !IsDryRun(context) {
+		response[apiKeySecretProperty] = secret
+	}
+	return response
+}
+
+//HashAPIKeySecret returns the hash stored for the secret
+func HashAPIKeySecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return apiKeyHashPrefix + hex.EncodeToString(hash[:])
+}
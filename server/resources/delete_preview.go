@@ -0,0 +1,169 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/middleware"
+	"github.com/cloudwan/gohan/util"
+)
+
+//DeletePreviewResource identifies a resource affected by a delete
+type DeletePreviewResource struct {
+	SchemaID string `json:"schema_id"`
+	ID       string `json:"id"`
+}
+
+//DeletePreviewConstraint describes a foreign key which makes a delete fail
+type DeletePreviewConstraint struct {
+	SchemaID     string `json:"schema_id"`
+	ID           string `json:"id"`
+	Property     string `json:"property"`
+	Relation     string `json:"relation"`
+	ReferencedID string `json:"referenced_id"`
+}
+
+//DeletePreview describes resources which would be deleted together with a resource
+//Resources the caller isn't allowed to read are only counted in Hidden
+type DeletePreview struct {
+	Deletable bool                      `json:"deletable"`
+	Deleted   []DeletePreviewResource   `json:"deleted"`
+	Blocked   []DeletePreviewConstraint `json:"blocked"`
+	Hidden    int                       `json:"hidden"`
+}
+
+type deletePreviewWalker struct {
+	tx         transaction.Transaction
+	auth       schema.Authorization
+	cascadeAll bool
+	preview    *DeletePreview
+	visited    map[string]bool
+	blocking   int
+}
+
+//PreviewDeleteResource returns resources which would be deleted or would block deletion
+//of the resource specified by the schema and ID
+func PreviewDeleteResource(context middleware.Context, dataStore db.DB, resourceSchema *schema.Schema, resourceID string) error {
+	context["id"] = resourceID
+	auth := context["auth"].(schema.Authorization)
+	policy, err := loadPolicy(context, schema.ActionDelete, strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), resourceSchema, auth)
+	if err != nil {
+		return err
+	}
+	tx, err := dataStore.Begin()
+	if err != nil {
+		return fmt.Errorf("cannot create transaction: %v", err)
+	}
+	defer tx.Close()
+
+	filter := transaction.IDFilter(resourceID)
+	if tenantIDs := policy.GetTenantIDFilter(schema.ActionDelete, auth.TenantID()); tenantIDs != nil {
+		filter["tenant_id"] = tenantIDs
+	}
+	resource, err := tx.Fetch(resourceSchema, filter)
+	if err != nil {
+		return ResourceError{err, "", NotFound}
+	}
+
+	walker := &deletePreviewWalker{
+		tx:         tx,
+		auth:       auth,
+		cascadeAll: util.GetConfig().GetBool("database/cascade_delete", false),
+		preview:    &DeletePreview{Deleted: []DeletePreviewResource{}, Blocked: []DeletePreviewConstraint{}},
+		visited:    map[string]bool{},
+	}
+	if err := walker.walk(resource); err != nil {
+		return err
+	}
+	walker.preview.Deletable = walker.blocking == 0
+	context["response"] = map[string]interface{}{"delete_preview": walker.preview}
+	return nil
+}
+
+func (walker *deletePreviewWalker) walk(root *schema.Resource) error {
+	queue := []*schema.Resource{root}
+	walker.visited[deletePreviewKey(root)] = true
+	for len(queue) > 0 {
+		resource := queue[0]
+		queue = queue[1:]
+		if walker.canRead(resource) {
+			walker.preview.Deleted = append(walker.preview.Deleted, DeletePreviewResource{
+				SchemaID: resource.Schema().ID,
+				ID:       resource.ID(),
+			})
+		} else {
+			walker.preview.Hidden++
+		}
+
+		for _, reference := range schema.GetManager().References(resource.Schema()) {
+			value := resource.Get(reference.RelationColumn())
+			if value == nil {
+				continue
+			}
+			referencing, _, err := walker.tx.List(reference.Schema, transaction.Filter{reference.Property.ID: value}, nil)
+			if err != nil {
+				return err
+			}
+			for _, child := range referencing {
+				key := deletePreviewKey(child)
+				if walker.visited[key] {
+					continue
+				}
+				if reference.Cascade || walker.cascadeAll {
+					walker.visited[key] = true
+					queue = append(queue, child)
+					continue
+				}
+				walker.blocking++
+				if !walker.canRead(child) {
+					walker.preview.Hidden++
+					continue
+				}
+				walker.preview.Blocked = append(walker.preview.Blocked, DeletePreviewConstraint{
+					SchemaID:     child.Schema().ID,
+					ID:           child.ID(),
+					Property:     reference.Property.ID,
+					Relation:     resource.Schema().ID,
+					ReferencedID: resource.ID(),
+				})
+			}
+		}
+	}
+	return nil
+}
+
+func (walker *deletePreviewWalker) canRead(resource *schema.Resource) bool {
+	resourceSchema := resource.Schema()
+	policy, _ := schema.GetManager().PolicyValidate(schema.ActionRead, resourceSchema.GetPluralURL(), walker.auth)
+	if policy == nil {
+		return false
+	}
+	tenantIDs := policy.GetTenantIDFilter(schema.ActionRead, walker.auth.TenantID())
+	if tenantIDs == nil {
+		return true
+	}
+	tenantID, ok := resource.Get("tenant_id").(string)
+	return ok && util.ContainsString(tenantIDs, tenantID)
+}
+
+func deletePreviewKey(resource *schema.Resource) string {
+	return resource.Schema().ID + "/" + resource.ID()
+}
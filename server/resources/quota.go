@@ -0,0 +1,148 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package resources
+
+import (
+	"fmt"
+
+	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+)
+
+//QuotaSchemaID is an ID of the core schema defining quotas
+const QuotaSchemaID = "quota"
+
+//Unlimited is a max_count value which disables quota
+const Unlimited = -1
+
+//QuotaUsage describes number of resources of a schema owned by a tenant
+type QuotaUsage struct {
+	Used     uint64 `json:"used"`
+	MaxCount int    `json:"max_count"`
+}
+
+//GetQuota returns max count of resources of the schema the tenant can own
+//Quota defined for the tenant takes precedence over the default quota.
+//Only the quota row of the tenant is locked, so concurrent creations of the tenant are serialized.
+//The default quota row is shared by all tenants and isn't locked, not to serialize creations of all of them.
+func GetQuota(tx transaction.Transaction, resourceSchema *schema.Schema, tenantID string) (int, error) {
+	quotaSchema, ok := schema.GetManager().Schema(QuotaSchemaID)
+	if !ok {
+		return Unlimited, nil
+	}
+	quotas, _, err := tx.LockList(quotaSchema, transaction.Filter{
+		"schema_id":        resourceSchema.ID,
+		"target_tenant_id": tenantID,
+	}, nil, transaction.SkipRelatedResources)
+	if err != nil {
+		return Unlimited, err
+	}
+	if len(quotas) > 0 {
+		return quotaMaxCount(quotas[0]), nil
+	}
+	quotas, _, err = tx.List(quotaSchema, transaction.Filter{
+		"schema_id":        resourceSchema.ID,
+		"target_tenant_id": "",
+	}, nil)
+	if err != nil {
+		return Unlimited, err
+	}
+	if len(quotas) > 0 {
+		return quotaMaxCount(quotas[0]), nil
+	}
+	return Unlimited, nil
+}
+
+//GetQuotaUsage returns usage of all schemas limited by quotas for the tenant
+func GetQuotaUsage(tx transaction.Transaction, tenantID string) (map[string]QuotaUsage, error) {
+	usage := map[string]QuotaUsage{}
+	for _, s := range schema.GetManager().OrderedSchemas() {
+		if !isQuotaLimited(s) {
+			continue
+		}
+		used, err := countTenantResources(tx, s, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		maxCount, err := GetQuota(tx, s, tenantID)
+		if err != nil {
+			return nil, err
+		}
+		usage[s.ID] = QuotaUsage{Used: used, MaxCount: maxCount}
+	}
+	return usage, nil
+}
+
+func checkQuota(tx transaction.Transaction, resource *schema.Resource) error {
+	resourceSchema := resource.Schema()
+	if !isQuotaLimited(resourceSchema) {
+		return nil
+	}
+	tenantID, _ := resource.Get("tenant_id").(string)
+	if tenantID == "" {
+		return nil
+	}
+	maxCount, err := GetQuota(tx, resourceSchema, tenantID)
+	if err != nil {
+		return err
+	}
+	if maxCount == Unlimited {
+		return nil
+	}
+	if maxCount == 0 {
+		err := fmt.Errorf("Tenant %s is not allowed to create %s", tenantID, resourceSchema.Plural)
+		return NewResourceError(err, err.Error(), Forbidden)
+	}
+	used, err := countTenantResources(tx, resourceSchema, tenantID)
+	if err != nil {
+		return err
+	}
+	if used >= uint64(maxCount) {
+		err := fmt.Errorf("Quota exceeded: tenant %s can create at most %d %s", tenantID, maxCount, resourceSchema.Plural)
+		return NewResourceError(err, err.Error(), QuotaExceeded)
+	}
+	return nil
+}
+
+func countTenantResources(tx transaction.Transaction, resourceSchema *schema.Schema, tenantID string) (uint64, error) {
+	paginator, err := pagination.NewPaginator(resourceSchema, "", "", 1, 0)
+	if err != nil {
+		return 0, err
+	}
+	_, total, err := tx.List(resourceSchema, transaction.Filter{"tenant_id": tenantID}, paginator)
+	return total, err
+}
+
+func quotaMaxCount(quota *schema.Resource) int {
+	switch maxCount := quota.Get("max_count").(type) {
+	case int:
+		return maxCount
+	case int64:
+		return int(maxCount)
+	case float64:
+		return int(maxCount)
+	}
+	return Unlimited
+}
+
+func isQuotaLimited(s *schema.Schema) bool {
+	if s.IsAbstract() || s.Metadata["type"] == "metaschema" {
+		return false
+	}
+	_, err := s.GetPropertyByID("tenant_id")
+	return err == nil
+}
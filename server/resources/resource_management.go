@@ -44,6 +44,8 @@ const (
 	hlsearch
 
 	Unauthorized
+	Forbidden
+	QuotaExceeded
 )
 
 // ResourceError is created when an anticipated problem has occurred during resource manipulations.
@@ -66,7 +68,17 @@ type ExtensionError struct {
 	ExceptionInfo map[string]interface{}
 }
 
+//DryRunKey is a context key set to true when changes shouldn't be applied
+const DryRunKey = "dry_run"
+
+//IsDryRun checks if the request is a dry run
+func IsDryRun(context middleware.Context) bool {
+	dryRun, _ := context[DryRunKey].(bool)
+	return dryRun
+}
+
 //InTransaction executes function in the db transaction and set it to the context
+//In dry run mode the transaction is rolled back instead of being committed
 func InTransaction(context middleware.Context, dataStore db.DB, level transaction.Type, f func() error) error {
 	if context["transaction"] != nil {
 		return fmt.Errorf("cannot create nested transaction")
@@ -87,6 +99,11 @@ func InTransaction(context middleware.Context, dataStore db.DB, level transactio
 		return err
 	}
 
+	if IsDryRun(context) {
+		delete(context, "transaction")
+		return nil
+	}
+
 	err = aTransaction.Commit()
 	if err != nil {
 		return fmt.Errorf("commit error : %s", err)
@@ -98,6 +115,7 @@ func InTransaction(context middleware.Context, dataStore db.DB, level transactio
 // ApplyPolicyForResources applies policy filtering for response
 func ApplyPolicyForResources(context middleware.Context, resourceSchema *schema.Schema) error {
 	policy := context["policy"].(*schema.Policy)
+	auth := context["auth"].(schema.Authorization)
 	rawResponse, ok := context["response"]
 	if !ok {
 		return fmt.Errorf("No response")
@@ -113,7 +131,7 @@ func ApplyPolicyForResources(context middleware.Context, resourceSchema *schema.
 	data := []interface{}{}
 	for _, resource := range resources {
 		resourceMap := resource.(map[string]interface{})
-		if err := policy.ApplyPropertyConditionFilter(schema.ActionRead, resourceMap, nil); err != nil {
+		if err := policy.ApplyConditionFilter(schema.ActionRead, auth, resourceMap, nil); err != nil {
 			continue
 		}
 		data = append(data, policy.RemoveHiddenProperty(resourceMap))
@@ -125,6 +143,7 @@ func ApplyPolicyForResources(context middleware.Context, resourceSchema *schema.
 // ApplyPolicyForResource applies policy filtering for response
 func ApplyPolicyForResource(context middleware.Context, resourceSchema *schema.Schema) error {
 	policy := context["policy"].(*schema.Policy)
+	auth := context["auth"].(schema.Authorization)
 	rawResponse, ok := context["response"]
 	if !ok {
 		return fmt.Errorf("No response")
@@ -138,7 +157,7 @@ func ApplyPolicyForResource(context middleware.Context, resourceSchema *schema.S
 		return nil
 	}
 	resourceMap := resource.(map[string]interface{})
-	if err := policy.ApplyPropertyConditionFilter(schema.ActionRead, resourceMap, nil); err != nil {
+	if err := policy.ApplyConditionFilter(schema.ActionRead, auth, resourceMap, nil); err != nil {
 		return err
 	}
 	response[resourceSchema.Singular] = policy.RemoveHiddenProperty(resourceMap)
@@ -213,7 +232,7 @@ func FilterFromQueryParameter(resourceSchema *schema.Schema,
 func GetMultipleResources(context middleware.Context, dataStore db.DB, resourceSchema *schema.Schema, queryParameters map[string][]string) error {
 	log.Debug("Start get multiple resources!!")
 	auth := context["auth"].(schema.Authorization)
-	policy, err := loadPolicy(context, "read", resourceSchema.GetPluralURL(), auth)
+	policy, err := loadPolicy(context, "read", resourceSchema.GetPluralURL(), resourceSchema, auth)
 	if err != nil {
 		return err
 	}
@@ -223,6 +242,9 @@ func GetMultipleResources(context middleware.Context, dataStore db.DB, resourceS
 	if policy.RequireOwner() {
 		filter["tenant_id"] = policy.GetTenantIDFilter(schema.ActionRead, auth.TenantID())
 	}
+	if policy.RequireDomainOwner() {
+		filter["domain_id"] = policy.GetDomainIDFilter(auth.DomainID())
+	}
 	filter = policy.RemoveHiddenProperty(filter)
 
 	paginator, err := pagination.FromURLQuery(resourceSchema, queryParameters)
@@ -265,7 +287,7 @@ func GetMultipleResources(context middleware.Context, dataStore db.DB, resourceS
 func GetSingleResource(context middleware.Context, dataStore db.DB, resourceSchema *schema.Schema, resourceID string) error {
 	context["id"] = resourceID
 	auth := context["auth"].(schema.Authorization)
-	policy, err := loadPolicy(context, "read", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), auth)
+	policy, err := loadPolicy(context, "read", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), resourceSchema, auth)
 	if err != nil {
 		return err
 	}
@@ -353,7 +375,7 @@ func CreateOrUpdateResource(
 	auth := context["auth"].(schema.Authorization)
 
 	//LoadPolicy
-	policy, err := loadPolicy(context, "update", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), auth)
+	policy, err := loadPolicy(context, "update", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), resourceSchema, auth)
 	if err != nil {
 		return false, err
 	}
@@ -400,7 +422,7 @@ func CreateResource(
 	auth := context["auth"].(schema.Authorization)
 
 	//LoadPolicy
-	policy, err := loadPolicy(context, "create", resourceSchema.GetPluralURL(), auth)
+	policy, err := loadPolicy(context, "create", resourceSchema.GetPluralURL(), resourceSchema, auth)
 	if err != nil {
 		return err
 	}
@@ -409,6 +431,10 @@ func CreateResource(
 	if _, ok := dataMap["tenant_id"]; err == nil && !ok {
 		dataMap["tenant_id"] = context["tenant_id"]
 	}
+	_, err = resourceSchema.GetPropertyByID("domain_id")
+	if _, ok := dataMap["domain_id"]; err == nil && !ok {
+		dataMap["domain_id"] = auth.DomainID()
+	}
 
 	if tenantID, ok := dataMap["tenant_id"]; ok && tenantID != nil {
 		dataMap["tenant_name"], err = identityService.GetTenantName(tenantID.(string))
@@ -425,7 +451,7 @@ func CreateResource(
 	delete(dataMap, "tenant_name")
 
 	// apply property filter
-	err = policy.ApplyPropertyConditionFilter(schema.ActionCreate, dataMap, nil)
+	err = policy.ApplyConditionFilter(schema.ActionCreate, auth, dataMap, nil)
 	if err != nil {
 		return ResourceError{err, err.Error(), Unauthorized}
 	}
@@ -472,8 +498,10 @@ func CreateResource(
 		return err
 	}
 
-	if err := extension.HandleEvent(context, environment, "post_create"); err != nil {
-		return err
+	if !IsDryRun(context) {
+		if err := extension.HandleEvent(context, environment, "post_create"); err != nil {
+			return err
+		}
 	}
 
 	if err := ApplyPolicyForResource(context, resourceSchema); err != nil {
@@ -494,6 +522,9 @@ func CreateResourceInTransaction(context middleware.Context, resource *schema.Re
 	if err := extension.HandleEvent(context, environment, "pre_create_in_transaction"); err != nil {
 		return err
 	}
+	if err := checkQuota(mainTransaction, resource); err != nil {
+		return err
+	}
 	if err := mainTransaction.Create(resource); err != nil {
 		log.Debug("%s transaction error", err)
 		return ResourceError{
@@ -533,7 +564,7 @@ func UpdateResource(
 	auth := context["auth"].(schema.Authorization)
 
 	//load policy
-	policy, err := loadPolicy(context, "update", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), auth)
+	policy, err := loadPolicy(context, "update", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), resourceSchema, auth)
 	if err != nil {
 		return err
 	}
@@ -573,8 +604,10 @@ func UpdateResource(
 		return err
 	}
 
-	if err := extension.HandleEvent(context, environment, "post_update"); err != nil {
-		return err
+	if !IsDryRun(context) {
+		if err := extension.HandleEvent(context, environment, "post_update"); err != nil {
+			return err
+		}
 	}
 
 	if err := ApplyPolicyForResource(context, resourceSchema); err != nil {
@@ -607,8 +640,9 @@ func UpdateResourceInTransaction(
 	}
 
 	policy := context["policy"].(*schema.Policy)
+	auth, _ := context["auth"].(schema.Authorization)
 	// apply property filter
-	err = policy.ApplyPropertyConditionFilter(schema.ActionUpdate, resource.Data(), dataMap)
+	err = policy.ApplyConditionFilter(schema.ActionUpdate, auth, resource.Data(), dataMap)
 	if err != nil {
 		return ResourceError{err, "", Unauthorized}
 	}
@@ -661,7 +695,7 @@ func DeleteResource(context middleware.Context,
 		return fmt.Errorf("No environment for schema")
 	}
 	auth := context["auth"].(schema.Authorization)
-	policy, err := loadPolicy(context, "delete", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), auth)
+	policy, err := loadPolicy(context, "delete", strings.Replace(resourceSchema.GetSingleURL(), ":id", resourceID, 1), resourceSchema, auth)
 	if err != nil {
 		return err
 	}
@@ -697,6 +731,13 @@ func DeleteResource(context middleware.Context,
 	); err != nil {
 		return err
 	}
+	if IsDryRun(context) {
+		context["response"] = map[string]interface{}{resourceSchema.Singular: context["resource"]}
+		if err := ApplyPolicyForResource(context, resourceSchema); err != nil {
+			return ResourceError{err, "", NotFound}
+		}
+		return nil
+	}
 	if err := extension.HandleEvent(context, environment, "post_delete"); err != nil {
 		return err
 	}
@@ -728,7 +769,7 @@ func DeleteResourceInTransaction(context middleware.Context, resourceSchema *sch
 		context["resource"] = resource.Data()
 	}
 	// apply property filter
-	err = policy.ApplyPropertyConditionFilter(schema.ActionUpdate, resource.Data(), nil)
+	err = policy.ApplyConditionFilter(schema.ActionUpdate, auth, resource.Data(), nil)
 	if err != nil {
 		return ResourceError{err, "", Unauthorized}
 	}
@@ -780,13 +821,19 @@ func ActionResource(context middleware.Context, dataStore db.DB, identityService
 	return fmt.Errorf("no response")
 }
 
-func loadPolicy(context middleware.Context, action, path string, auth schema.Authorization) (*schema.Policy, error) {
+//loadPolicy finds the policy deciding the request
+//Resources without domain_id aren't accessible with is_domain_owner policies, whatever the action is.
+func loadPolicy(context middleware.Context, action, path string, resourceSchema *schema.Schema, auth schema.Authorization) (*schema.Policy, error) {
 	manager := schema.GetManager()
 	policy, role := manager.PolicyValidate(action, path, auth)
 	if policy == nil {
 		err := fmt.Errorf(fmt.Sprintf("No matching policy: %s %s", action, path))
 		return nil, ResourceError{err, err.Error(), Unauthorized}
 	}
+	if _, err := resourceSchema.GetPropertyByID("domain_id"); err != nil && policy.RequireDomainOwner() {
+		err := fmt.Errorf("Policy %s requires domain ownership, but %s has no domain_id", policy.ID, resourceSchema.ID)
+		return nil, ResourceError{err, err.Error(), Unauthorized}
+	}
 	context["policy"] = policy
 	context["role"] = role
 	return policy, nil
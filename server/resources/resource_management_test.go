@@ -1132,6 +1132,89 @@ var _ = Describe("Resource manager", func() {
 				_, ok := err.(resources.ResourceError)
 				Expect(ok).To(BeTrue())
 			})
+
+			Describe("With quotas", func() {
+				var quotas []map[string]interface{}
+
+				BeforeEach(func() {
+					quotas = []map[string]interface{}{}
+				})
+
+				JustBeforeEach(func() {
+					transaction, err := testDB.Begin()
+					Expect(err).NotTo(HaveOccurred())
+					defer transaction.Close()
+					for _, quotaData := range quotas {
+						quota, err := manager.LoadResource(resources.QuotaSchemaID, quotaData)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(transaction.Create(quota)).To(Succeed())
+					}
+					Expect(transaction.Commit()).To(Succeed())
+				})
+
+				newResourceData := func() map[string]interface{} {
+					return map[string]interface{}{"tenant_id": adminTenantID}
+				}
+
+				expectProblem := func(err error, problem resources.ResourceProblem) {
+					Expect(err).To(HaveOccurred())
+					resourceErr, ok := err.(resources.ResourceError)
+					Expect(ok).To(BeTrue())
+					Expect(resourceErr.Problem).To(Equal(problem))
+				}
+
+				Context("When the default quota is reached", func() {
+					BeforeEach(func() {
+						quotas = append(quotas, map[string]interface{}{
+							"id": "default_test", "schema_id": schemaID, "target_tenant_id": "", "max_count": 1,
+						})
+					})
+
+					It("Should not create resource", func() {
+						err := resources.CreateResource(
+							context, testDB, fakeIdentity, currentSchema, newResourceData())
+						expectProblem(err, resources.QuotaExceeded)
+					})
+
+					It("Should report usage", func() {
+						transaction, err := testDB.Begin()
+						Expect(err).NotTo(HaveOccurred())
+						defer transaction.Close()
+						usage, err := resources.GetQuotaUsage(transaction, adminTenantID)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(usage).To(HaveKeyWithValue(schemaID, resources.QuotaUsage{Used: 1, MaxCount: 1}))
+					})
+
+					It("Should prefer quota of the tenant", func() {
+						transaction, err := testDB.Begin()
+						Expect(err).NotTo(HaveOccurred())
+						quota, err := manager.LoadResource(resources.QuotaSchemaID, map[string]interface{}{
+							"id": "admin_test", "schema_id": schemaID, "target_tenant_id": adminTenantID, "max_count": resources.Unlimited,
+						})
+						Expect(err).NotTo(HaveOccurred())
+						Expect(transaction.Create(quota)).To(Succeed())
+						Expect(transaction.Commit()).To(Succeed())
+						transaction.Close()
+
+						Expect(resources.CreateResource(
+							context, testDB, fakeIdentity, currentSchema, newResourceData())).To(Succeed())
+					})
+				})
+
+				Context("When the quota is zero", func() {
+					BeforeEach(func() {
+						quotas = append(quotas, map[string]interface{}{
+							"id": "admin_test", "schema_id": schemaID, "target_tenant_id": adminTenantID, "max_count": 0,
+						})
+					})
+
+					It("Should forbid creating resources", func() {
+						err := resources.CreateResource(
+							context, testDB, fakeIdentity, currentSchema, newResourceData())
+						expectProblem(err, resources.Forbidden)
+					})
+				})
+			})
 		})
 	})
 
@@ -36,22 +36,21 @@ import (
 	"github.com/cloudwan/gohan/schema"
 	"github.com/cloudwan/gohan/server/middleware"
 	"github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/sync/etcd"
 	"github.com/cloudwan/gohan/sync/etcdv3"
+	"github.com/cloudwan/gohan/sync/memory"
+	"github.com/cloudwan/gohan/sync/prefix"
 	"github.com/cloudwan/gohan/util"
 	"github.com/drone/routes"
 	"github.com/go-martini/martini"
 	"github.com/lestrrat/go-server-starter/listener"
 	"github.com/martini-contrib/staticbin"
+	"google.golang.org/grpc"
 	"regexp"
 	"github.com/cloudwan/gohan/db/migration"
 )
 
-type tlsConfig struct {
-	CertFile string
-	KeyFile  string
-}
-
 //Server is a struct for GohanAPIServer
 type Server struct {
 	address          string
@@ -63,15 +62,39 @@ type Server struct {
 	martini          *martini.ClassicMartini
 	extensions       []string
 	keystoneIdentity middleware.IdentityService
+	tokenCache       *cloud.TokenCache
+	reloadState      reloadState
+	routerState      routerState
+	stateWait        stateWait
 	queue            *job.Queue
+	grpc             *grpc.Server
+	eventRetry       eventRetry
+	syncWorkers      int
+	eventBatchSize   int
+	elections        []*election.Election
 }
 
 func (server *Server) mapRoutes() {
 	config := util.GetConfig()
-	schemaManager := schema.GetManager()
 	MapNamespacesRoutes(server.martini)
+	MapOpenAPIRoute(server.martini)
 	MapRouteBySchemas(server, server.db)
+	MapQuotaRoutes(server.martini, server.db)
+	MapAPIKeyRoutes(server, server.db)
+	MapPolicyExplainRoute(server.martini)
+	MapDeadLetterRoutes(server.martini, server.db)
+	MapSyncReconcileRoute(server.martini, server.db, server.sync)
+	MapMetricsRoute(server.martini)
 
+	if config.GetBool("keystone/fake", false) {
+		middleware.FakeKeystone(server.martini)
+	}
+}
+
+//loadStoredResources loads policies, extensions and namespaces stored in db at startup
+//Later changes are applied by reloads of the changed kinds only.
+func (server *Server) loadStoredResources() {
+	schemaManager := schema.GetManager()
 	tx, err := server.db.Begin()
 	if err != nil {
 		log.Fatal(err)
@@ -88,14 +111,18 @@ func (server *Server) mapRoutes() {
 	if err != nil {
 		log.Info(err.Error())
 	}
-	schemaManager.LoadPolicies(policyList)
+	if err := schemaManager.ReloadPolicies(policyList); err != nil {
+		log.Error("Failed to load policies: %s", err)
+	}
 
 	extensionSchema, _ := schemaManager.Schema("extension")
 	extensionList, _, err := tx.List(extensionSchema, nil, nil)
 	if err != nil {
 		log.Info(err.Error())
 	}
-	schemaManager.LoadExtensions(extensionList)
+	if _, err := schemaManager.ReloadExtensions(extensionList); err != nil {
+		log.Error("Failed to load extensions: %s", err)
+	}
 
 	namespaceSchema, _ := schemaManager.Schema("namespace")
 	if namespaceSchema == nil {
@@ -110,11 +137,7 @@ func (server *Server) mapRoutes() {
 	if err != nil {
 		log.Info(err.Error())
 	}
-	schemaManager.LoadNamespaces(namespaceList)
-
-	if config.GetBool("keystone/fake", false) {
-		middleware.FakeKeystone(server.martini)
-	}
+	schemaManager.ReloadNamespaces(namespaceList)
 }
 
 func (server *Server) addOptionsRoute() {
@@ -123,14 +146,6 @@ func (server *Server) addOptionsRoute() {
 	})
 }
 
-func (server *Server) resetRouter() {
-	router := martini.NewRouter()
-	server.martini.Router = router
-	server.martini.MapTo(router, (*martini.Routes)(nil))
-	server.martini.Action(router.Handle)
-	server.addOptionsRoute()
-}
-
 func (server *Server) initDB() error {
 	return db.InitDBWithSchemas(server.getDatabaseConfig())
 }
@@ -144,13 +159,128 @@ func (server *Server) connectDB() error {
 	maxConn := config.GetInt("database/max_open_conn", db.DefaultMaxOpenConn)
 	dbConn, err := db.ConnectDB(dbType, dbConnection, maxConn)
 	if server.sync == nil {
-		server.db = dbConn
+		server.db = &DbReloadWrapper{dbConn, server}
 	} else {
-		server.db = &DbSyncWrapper{dbConn}
+		server.db = &DbReloadWrapper{&DbSyncWrapper{dbConn}, server}
 	}
 	return err
 }
 
+//ConnectSync connects to the sync backend configured in config
+//It returns nil when etcd servers aren't configured.
+//Keys are placed under sync_prefix when it is set.
+func ConnectSync(config *util.Config) (sync.Sync, error) {
+	backend, err := connectSyncBackend(config)
+	if err != nil || backend == nil {
+		return backend, err
+	}
+	if keyPrefix := config.GetString("sync_prefix", ""); keyPrefix != "" {
+		log.Info("sync prefix: %s", keyPrefix)
+		return prefix.NewSync(backend, keyPrefix), nil
+	}
+	return backend, nil
+}
+
+func connectSyncBackend(config *util.Config) (sync.Sync, error) {
+	syncType := config.GetString("sync", "etcd")
+	switch syncType {
+	case "etcd":
+		etcdServers := config.GetStringList("etcd", nil)
+		if etcdServers != nil {
+			log.Info("etcd servers: %s", etcdServers)
+			clientConfig, err := readEtcdClientConfig(config)
+			if err != nil {
+				return nil, err
+			}
+			backend, err := etcd.NewSyncWithConfig(&etcd.Config{
+				Endpoints:   etcdServers,
+				DialTimeout: clientConfig.dialTimeout,
+				KeepAlive:   clientConfig.keepAlive,
+				TLS:         clientConfig.tls,
+				Username:    clientConfig.username,
+				Password:    clientConfig.password,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("invalid etcd configuration: %s", err)
+			}
+			return backend, nil
+		}
+	case "etcdv3":
+		etcdServers := config.GetStringList("etcd", nil)
+		if etcdServers != nil {
+			log.Info("etcd servers: %s", etcdServers)
+			clientConfig, err := readEtcdClientConfig(config)
+			if err != nil {
+				return nil, err
+			}
+			if clientConfig.keepAlive > 0 {
+				log.Warning("etcd_client/keepalive isn't supported by etcdv3 sync, ignoring it")
+			}
+			backend, err := etcdv3.NewSyncWithConfig(&etcdv3.Config{
+				Endpoints:      etcdServers,
+				DialTimeout:    clientConfig.dialTimeout,
+				RequestTimeout: clientConfig.requestTimeout,
+				TLS:            clientConfig.tls,
+				Username:       clientConfig.username,
+				Password:       clientConfig.password,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("failed to connect to etcd servers %s: %s", etcdServers, err)
+			}
+			return backend, nil
+		}
+	case "memory":
+		log.Info("in-memory sync enabled")
+		backend, err := memory.NewSync(config.GetString("sync_file", ""))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load sync file: %s", err)
+		}
+		return backend, nil
+	default:
+		return nil, fmt.Errorf("invalid sync type: %s", syncType)
+	}
+	return nil, nil
+}
+
+type etcdClientConfig struct {
+	dialTimeout    time.Duration
+	requestTimeout time.Duration
+	//keepAlive is zero when the default of the client is used
+	keepAlive time.Duration
+	tls       *tls.Config
+	username  string
+	password  string
+}
+
+//readEtcdClientConfig reads TLS, authentication and timeouts of etcd clients
+func readEtcdClientConfig(config *util.Config) (*etcdClientConfig, error) {
+	result := &etcdClientConfig{
+		dialTimeout:    time.Duration(config.GetInt("etcd_client/dial_timeout", 1)) * time.Second,
+		requestTimeout: time.Duration(config.GetInt("etcd_client/request_timeout", 1)) * time.Second,
+		keepAlive:      time.Duration(config.GetInt("etcd_client/keepalive", 0)) * time.Second,
+		username:       config.GetString("etcd_client/username", ""),
+		password:       config.GetString("etcd_client/password", ""),
+	}
+	if result.dialTimeout < time.Second || result.requestTimeout < time.Second || result.keepAlive < 0 {
+		return nil, fmt.Errorf("etcd_client timeouts must be at least 1 second")
+	}
+	if (result.username == "") != (result.password == "") {
+		return nil, fmt.Errorf("etcd_client/username and etcd_client/password must be set together")
+	}
+	certFile := config.GetString("etcd_client/cert_file", "")
+	keyFile := config.GetString("etcd_client/key_file", "")
+	caFile := config.GetString("etcd_client/ca_file", "")
+	insecureSkipVerify := config.GetBool("etcd_client/insecure_skip_verify", false)
+	if certFile != "" || keyFile != "" || caFile != "" || insecureSkipVerify {
+		var err error
+		result.tls, err = newEtcdTLSConfig(certFile, keyFile, caFile, insecureSkipVerify)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
 func (server *Server) getDatabaseConfig() (string, string, bool, bool, bool) {
 	config := util.GetConfig()
 	databaseType := config.GetString("database/type", "sqlite3")
@@ -194,6 +324,7 @@ func NewServer(configFile string) (*Server, error) {
 	m.Use(middleware.JSONURLs())
 	m.Use(middleware.WithContext())
 
+	m.Action(server.handleRoute)
 	server.martini = m
 
 	port := os.Getenv("PORT")
@@ -203,6 +334,7 @@ func NewServer(configFile string) (*Server, error) {
 	}
 
 	setupEditor(server)
+	setupAPIKeys()
 
 	server.extensions = config.GetStringList("extension/use", []string{
 		"javascript",
@@ -233,30 +365,31 @@ func NewServer(configFile string) (*Server, error) {
 	if config.GetBool("tls/enabled", false) {
 		log.Info("TLS enabled")
 		server.tls = &tlsConfig{
-			KeyFile:  config.GetString("tls/key_file", "./etc/key.pem"),
-			CertFile: config.GetString("tls/cert_file", "./etc/cert.pem"),
+			KeyFile:      config.GetString("tls/key_file", "./etc/key.pem"),
+			CertFile:     config.GetString("tls/cert_file", "./etc/cert.pem"),
+			ClientCAFile: config.GetString("tls/client_ca_file", ""),
+			ClientAuth:   config.GetString("tls/client_auth", clientAuthOptional),
+			CRLFile:      config.GetString("tls/crl_file", ""),
 		}
 	}
 
-	syncType := config.GetString("sync", "etcd")
-	switch syncType {
-	case "etcd":
-		etcdServers := config.GetStringList("etcd", nil)
-		if etcdServers != nil {
-			log.Info("etcd servers: %s", etcdServers)
-			server.sync = etcd.NewSync(etcdServers)
-		}
-	case "etcdv3":
-		etcdServers := config.GetStringList("etcd", nil)
-		if etcdServers != nil {
-			log.Info("etcd servers: %s", etcdServers)
-			server.sync, err = etcdv3.NewSync(etcdServers, time.Second)
-			if err != nil {
-				return nil, fmt.Errorf("failed to connect to etcd servers: %s", err)
-			}
-		}
-	default:
-		return nil, fmt.Errorf("invalid sync type: %s", syncType)
+	server.sync, err = ConnectSync(config)
+	if err != nil {
+		return nil, err
+	}
+
+	server.eventRetry = eventRetry{
+		maxRetries: config.GetInt("sync_events/max_retries", defaultEventMaxRetries),
+		backoff:    time.Duration(config.GetInt("sync_events/retry_backoff", defaultEventBackoff)) * time.Second,
+		maxBackoff: time.Duration(config.GetInt("sync_events/max_backoff", defaultEventMaxBackoff)) * time.Second,
+	}
+	server.syncWorkers = config.GetInt("sync_events/workers", defaultSyncWorkers)
+	if server.syncWorkers < 1 {
+		return nil, fmt.Errorf("sync_events/workers should be positive: %d", server.syncWorkers)
+	}
+	server.eventBatchSize = config.GetInt("sync_events/batch_size", defaultEventBatchSize)
+	if server.eventBatchSize < 1 {
+		return nil, fmt.Errorf("sync_events/batch_size should be positive: %d", server.eventBatchSize)
 	}
 
 	server.connectDB()
@@ -301,7 +434,7 @@ func NewServer(configFile string) (*Server, error) {
 			log.Info("Debug Mode with Fake Keystone Server")
 		} else {
 			log.Info("Keystone backend server configured")
-			server.keystoneIdentity, err = cloud.NewKeystoneIdentity(
+			keystoneIdentity, err := cloud.NewKeystoneIdentity(
 				config.GetString("keystone/auth_url", "http://localhost:35357/v3"),
 				config.GetString("keystone/user_name", "admin"),
 				config.GetString("keystone/password", "password"),
@@ -312,10 +445,71 @@ func NewServer(configFile string) (*Server, error) {
 			if err != nil {
 				log.Fatal(err)
 			}
+			if ttl := config.GetInt("keystone/cache/ttl", 0); ttl > 0 {
+				log.Info("Keystone token cache enabled")
+				cacheConfig := cloud.TokenCacheConfig{
+					TTL:           time.Duration(ttl) * time.Second,
+					Size:          config.GetInt("keystone/cache/size", 10000),
+					CheckInterval: time.Duration(config.GetInt("keystone/cache/check_interval", 0)) * time.Second,
+				}
+				if config.GetBool("keystone/cache/shared", false) && server.sync != nil {
+					sharedKey := config.GetString("keystone/cache/shared_key", "")
+					if sharedKey == "" {
+						log.Fatal("keystone/cache/shared_key is required to share cached tokens")
+					}
+					cacheConfig.Sync = server.sync
+					cacheConfig.SharedKey = []byte(sharedKey)
+				}
+				keystoneIdentity.EnableCache(cacheConfig)
+				server.tokenCache = keystoneIdentity.Cache
+			}
+			server.keystoneIdentity = keystoneIdentity
+		}
+	} else if config.GetBool("jwt/use_jwt", false) {
+		log.Info("JWT identity configured")
+		server.keystoneIdentity, err = cloud.NewJWTIdentity(cloud.JWTConfig{
+			JWKSFile:          config.GetString("jwt/jwks_file", ""),
+			DiscoveryURL:      config.GetString("jwt/discovery_url", ""),
+			Issuer:            config.GetString("jwt/issuer", ""),
+			Audience:          config.GetString("jwt/audience", ""),
+			SkipAudienceCheck: config.GetBool("jwt/skip_audience_check", false),
+			TenantIDClaim:     config.GetString("jwt/claims/tenant_id", "tenant_id"),
+			TenantNameClaim:   config.GetString("jwt/claims/tenant_name", "tenant_name"),
+			RolesClaim:        config.GetString("jwt/claims/roles", "roles"),
+			Leeway:            time.Duration(config.GetInt("jwt/leeway", 0)) * time.Second,
+			ServiceTenantID:   config.GetString("jwt/service/tenant_id", "admin"),
+			ServiceTenantName: config.GetString("jwt/service/tenant_name", "admin"),
+			ServiceRoles:      config.GetStringList("jwt/service/roles", []string{"admin"}),
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if config.GetBool("api_key/use_api_key", false) {
+		log.Info("API key identity configured")
+		server.keystoneIdentity = NewAPIKeyIdentity(server.db, server.keystoneIdentity, config.GetString("api_key/admin_token_hash", ""))
+	}
+
+	if config.GetBool("client_certificate/use_client_certificate", false) {
+		if server.tls == nil || server.tls.ClientCAFile == "" {
+			log.Fatal("client_certificate requires tls/enabled and tls/client_ca_file")
+		}
+		log.Info("Client certificate identity configured")
+		server.keystoneIdentity, err = NewCertificateIdentity(CertificateIdentityConfig{
+			TenantIDField:   config.GetString("client_certificate/tenant_id_field", "subject.organization"),
+			TenantNameField: config.GetString("client_certificate/tenant_name_field", ""),
+			RolesField:      config.GetString("client_certificate/roles_field", "subject.organizational_unit"),
+			Roles:           config.GetStringList("client_certificate/roles", nil),
+		}, server.keystoneIdentity)
+		if err != nil {
+			log.Fatal(err)
 		}
+	}
+
+	if server.keystoneIdentity != nil {
 		m.MapTo(server.keystoneIdentity, (*middleware.IdentityService)(nil))
 		m.Use(middleware.Authentication())
-		//m.Use(Authorization())
 	} else {
 		m.MapTo(&middleware.NoIdentityService{}, (*middleware.IdentityService)(nil))
 		m.Map(schema.NewAuthorization("admin", "admin", "admin_token", []string{"admin"}, nil))
@@ -325,7 +519,6 @@ func NewServer(configFile string) (*Server, error) {
 		return nil, fmt.Errorf("invalid base dir: %s", err)
 	}
 
-	server.addOptionsRoute()
 	cors := config.GetString("cors", "")
 	if cors != "" {
 		log.Info("Enabling CORS for %s", cors)
@@ -334,7 +527,7 @@ func NewServer(configFile string) (*Server, error) {
 		}
 		server.martini.Use(func(rw http.ResponseWriter, r *http.Request) {
 			rw.Header().Add("Access-Control-Allow-Origin", cors)
-			rw.Header().Add("Access-Control-Allow-Headers", "X-Auth-Token, Content-Type")
+			rw.Header().Add("Access-Control-Allow-Headers", "X-Auth-Token, Authorization, Content-Type")
 			rw.Header().Add("Access-Control-Expose-Headers", "X-Total-Count")
 			rw.Header().Add("Access-Control-Allow-Methods", "GET,PUT,POST,DELETE")
 		})
@@ -398,7 +591,8 @@ func NewServer(configFile string) (*Server, error) {
 			SkipLogging: true,
 		}))
 	}
-	server.mapRoutes()
+	server.loadStoredResources()
+	server.remapRoutes()
 
 	maxWorkerCount := config.GetInt("workers", 100)
 	server.queue = job.NewQueue(uint(maxWorkerCount))
@@ -419,9 +613,7 @@ func (server *Server) Start() (err error) {
 		l = listeners[0]
 	}
 	if server.tls != nil {
-		config := &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven}
-		config.Certificates = make([]tls.Certificate, 1)
-		config.Certificates[0], err = tls.LoadX509KeyPair(server.tls.CertFile, server.tls.KeyFile)
+		config, err := newTLSConfig(server.tls)
 		if err != nil {
 			return err
 		}
@@ -442,14 +634,30 @@ func (server *Server) Stop() {
 		stopSyncProcess(server)
 		stopStateWatchProcess(server)
 		stopSyncWatchProcess(server)
+		stopReloadWatchProcess(server)
+		stopStateWaitProcess(server)
 	}
 	stopAMQPProcess(server)
 	stopSNMPProcess(server)
 	stopCRONProcess(server)
+	stopGRPCProcess(server)
+	for _, e := range server.elections {
+		e.Close()
+	}
+	if server.tokenCache != nil {
+		server.tokenCache.Stop()
+	}
 	manners.Close()
 	server.queue.Stop()
 }
 
+//newElection returns the election of key shared with extensions, which is closed when the server stops
+func (server *Server) newElection(key string) *election.Election {
+	e := election.Get(server.sync, key)
+	server.elections = append(server.elections, e)
+	return e
+}
+
 //Queue returns servers build-in queue
 func (server *Server) Queue() *job.Queue {
 	return server.queue
@@ -489,10 +697,13 @@ func RunServer(configFile string) {
 		startSyncProcess(server)
 		startStateWatchProcess(server)
 		startSyncWatchProcess(server)
+		startReloadWatchProcess(server)
+		startStateWaitProcess(server)
 	}
 	startAMQPProcess(server)
 	startSNMPProcess(server)
 	startCRONProcess(server)
+	startGRPCProcess(server)
 	err = server.Start()
 	if err != nil {
 		log.Fatal(err)
@@ -346,6 +346,50 @@ var _ = Describe("Server package test", func() {
 		})
 	})
 
+	Describe("DeletePreview", func() {
+		It("should list cascaded and blocking resources", func() {
+			network := getNetwork("red", "red")
+			testURL("POST", networkPluralURL, adminTokenID, network, http.StatusCreated)
+			serverData := map[string]interface{}{
+				"id":         "serverRed",
+				"name":       "Server Red",
+				"network_id": "networkred",
+				"status":     "ACTIVE",
+			}
+			testURL("POST", serverPluralURL, adminTokenID, serverData, http.StatusCreated)
+
+			result := testURL("GET", getNetworkSingularURL("red")+"/delete_preview", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("delete_preview", HaveKeyWithValue("deletable", true)))
+			Expect(result).To(HaveKeyWithValue("delete_preview", HaveKeyWithValue("deleted", ConsistOf(
+				util.MatchAsJSON(map[string]interface{}{"schema_id": "network", "id": "networkred"}),
+				util.MatchAsJSON(map[string]interface{}{"schema_id": "server", "id": "serverRed"}),
+			))))
+
+			testURL("POST", getSubnetFullPluralURL("red"), adminTokenID, getSubnet("red", "red", ""), http.StatusCreated)
+			result = testURL("GET", getNetworkSingularURL("red")+"/delete_preview", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("delete_preview", HaveKeyWithValue("deletable", false)))
+			Expect(result).To(HaveKeyWithValue("delete_preview", HaveKeyWithValue("blocked", ConsistOf(
+				util.MatchAsJSON(map[string]interface{}{
+					"schema_id":     "subnet",
+					"id":            "subnetred",
+					"property":      "network_id",
+					"relation":      "network",
+					"referenced_id": "networkred",
+				}),
+			))))
+		})
+
+		It("should require delete policy", func() {
+			powerUserNetwork := getNetwork("pink", powerUserTenantID)
+			testURL("POST", networkPluralURL, powerUserTokenID, powerUserNetwork, http.StatusCreated)
+			//member can read and update the network, but can't delete it
+			testURL("GET", getNetworkSingularURL("pink"), memberTokenID, nil, http.StatusOK)
+			testURL("GET", getNetworkSingularURL("pink")+"/delete_preview", memberTokenID, nil, http.StatusNotFound)
+			result := testURL("GET", getNetworkSingularURL("pink")+"/delete_preview", powerUserTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("delete_preview", HaveKeyWithValue("deletable", true)))
+		})
+	})
+
 	Describe("NullableProperties", func() {
 		It("should work", func() {
 			network := getNetwork("red", "red")
@@ -808,6 +852,93 @@ var _ = Describe("Server package test", func() {
 				Expect(err).To(HaveOccurred(), "Failed to sync db resource deletion to sync backend")
 			})
 		})
+
+		Context("Reconciling", func() {
+			reconcileURL := baseURL + "/gohan/v0.1/sync/reconcile"
+
+			It("should restore keys missing in sync backend", func() {
+				manager := schema.GetManager()
+				networkResource, err := manager.LoadResource("network", getNetwork("Red", "red"))
+				Expect(err).ToNot(HaveOccurred())
+				testDB1 := &srv.DbSyncWrapper{DB: testDB}
+				tx, err := testDB1.Begin()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Create(networkResource)).To(Succeed())
+				Expect(tx.Commit()).To(Succeed())
+				tx.Close()
+				Expect(server.Sync()).To(Succeed())
+
+				sync, err := gohan_etcd.NewSync([]string{"http://127.0.0.1:2379"}, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				key := "/config" + networkResource.Path()
+				Expect(sync.Delete(key)).To(Succeed())
+
+				testURL("POST", reconcileURL, memberTokenID, nil, http.StatusUnauthorized)
+				result := testURL("POST", reconcileURL+"?dry_run=true", adminTokenID, nil, http.StatusOK)
+				Expect(result).To(HaveKeyWithValue("missing", ConsistOf(key)))
+				_, err = sync.Fetch(key)
+				Expect(err).To(HaveOccurred())
+
+				testURL("POST", reconcileURL, adminTokenID, nil, http.StatusOK)
+				_, err = sync.Fetch(key)
+				Expect(err).ToNot(HaveOccurred())
+				result = testURL("POST", reconcileURL+"?dry_run=true", adminTokenID, nil, http.StatusOK)
+				Expect(result).To(HaveKeyWithValue("missing", BeEmpty()))
+			})
+		})
+
+		Context("With failing events", func() {
+			eventPluralURL := baseURL + "/gohan/v0.1/events"
+			deadLetterPluralURL := baseURL + "/gohan/v0.1/dead_letters"
+
+			It("should retry them and move them to dead letters", func() {
+				manager := schema.GetManager()
+				poisoned, err := manager.LoadResource("with_sync_property", map[string]interface{}{"id": "r0"})
+				Expect(err).ToNot(HaveOccurred())
+				networkResource, err := manager.LoadResource("network", getNetwork("Red", "red"))
+				Expect(err).ToNot(HaveOccurred())
+				testDB1 := &srv.DbSyncWrapper{DB: testDB}
+				tx, err := testDB1.Begin()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(tx.Create(poisoned)).To(Succeed())
+				Expect(tx.Create(networkResource)).To(Succeed())
+				Expect(tx.Commit()).To(Succeed())
+				tx.Close()
+
+				Expect(server.Sync()).To(Succeed())
+
+				sync, err := gohan_etcd.NewSync([]string{"http://127.0.0.1:2379"}, time.Second)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = sync.Fetch("/config" + networkResource.Path())
+				Expect(err).ToNot(HaveOccurred(), "Failing event blocked later events")
+
+				result := testURL("GET", eventPluralURL, adminTokenID, nil, http.StatusOK)
+				events := result.(map[string]interface{})["events"].([]interface{})
+				Expect(events).To(HaveLen(1))
+				Expect(events[0]).To(HaveKeyWithValue("retry_count", float64(1)))
+				Expect(events[0]).To(HaveKeyWithValue("last_error", ContainSubstring("p0")))
+
+				Expect(server.Sync()).To(Succeed())
+
+				result = testURL("GET", eventPluralURL, adminTokenID, nil, http.StatusOK)
+				Expect(result).To(HaveKeyWithValue("events", BeEmpty()))
+				result = testURL("GET", deadLetterPluralURL, adminTokenID, nil, http.StatusOK)
+				deadLetters := result.(map[string]interface{})["dead_letters"].([]interface{})
+				Expect(deadLetters).To(HaveLen(1))
+				Expect(deadLetters[0]).To(HaveKeyWithValue("retry_count", float64(2)))
+				id := fmt.Sprint(deadLetters[0].(map[string]interface{})["id"])
+
+				testURL("POST", deadLetterPluralURL+"/"+id+"/retry", memberTokenID, nil, http.StatusUnauthorized)
+				testURL("POST", deadLetterPluralURL+"/"+id+"/retry", adminTokenID, nil, http.StatusOK)
+				result = testURL("GET", deadLetterPluralURL, adminTokenID, nil, http.StatusOK)
+				Expect(result).To(HaveKeyWithValue("dead_letters", BeEmpty()))
+				result = testURL("GET", eventPluralURL+"/"+id, adminTokenID, nil, http.StatusOK)
+				Expect(result).To(HaveKeyWithValue("event", HaveKeyWithValue("retry_count", float64(0))))
+
+				testURL("DELETE", eventPluralURL+"/"+id, adminTokenID, nil, http.StatusNoContent)
+				testURL("POST", eventPluralURL+"/"+id+"/retry", adminTokenID, nil, http.StatusNotFound)
+			})
+		})
 	})
 
 	Describe("Updating the state", func() {
@@ -1150,6 +1281,247 @@ var _ = Describe("Server package test", func() {
 		})
 	})
 
+	Describe("DryRun", func() {
+		It("should not create network", func() {
+			network := getNetwork("red", "red")
+			result := testURL("POST", networkPluralURL+"?dry_run=true", adminTokenID, network, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("network", util.MatchAsJSON(network)))
+			testURL("GET", getNetworkSingularURL("red"), adminTokenID, nil, http.StatusNotFound)
+		})
+
+		It("should not update or delete network", func() {
+			network := getNetwork("red", "red")
+			testURL("POST", networkPluralURL, adminTokenID, network, http.StatusCreated)
+
+			result := testURL("PUT", getNetworkSingularURL("red")+"?dry_run=true", adminTokenID,
+				map[string]interface{}{"name": "Renamed"}, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("network", HaveKeyWithValue("name", "Renamed")))
+			result = testURL("GET", getNetworkSingularURL("red"), adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("network", util.MatchAsJSON(network)))
+
+			result = testURL("DELETE", getNetworkSingularURL("red")+"?dry_run=true", adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("network", HaveKeyWithValue("id", network["id"])))
+			testURL("GET", getNetworkSingularURL("red"), adminTokenID, nil, http.StatusOK)
+		})
+
+		It("should reject invalid dry_run parameter", func() {
+			testURL("POST", networkPluralURL+"?dry_run=maybe", adminTokenID, getNetwork("red", "red"), http.StatusBadRequest)
+		})
+	})
+
+	Describe("APIKey", func() {
+		apiKeyPluralURL := baseURL + "/gohan/v0.1/api_keys"
+
+		createAPIKey := func(apiKey map[string]interface{}) (string, map[string]interface{}) {
+			result := testURL("POST", apiKeyPluralURL, adminTokenID, apiKey, http.StatusCreated)
+			created := result.(map[string]interface{})["api_key"].(map[string]interface{})
+			Expect(created).ToNot(HaveKey("secret_hash"))
+			Expect(created).To(HaveKey("secret"))
+			return created["secret"].(string), created
+		}
+
+		It("should authenticate with an API key", func() {
+			secret, created := createAPIKey(map[string]interface{}{
+				"name":      "ci",
+				"tenant_id": memberTenantID,
+				"roles":     []string{"Member"},
+			})
+			testURL("GET", networkPluralURL, secret, nil, http.StatusOK)
+
+			result := testURL("GET", apiKeyPluralURL+"/"+created["id"].(string), adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("api_key", Not(HaveKey("secret"))))
+			Expect(result).To(HaveKeyWithValue("api_key", Not(HaveKey("secret_hash"))))
+		})
+
+		It("should reject a wrong secret", func() {
+			_, created := createAPIKey(map[string]interface{}{"tenant_id": memberTenantID, "roles": []string{"Member"}})
+			testURL("GET", networkPluralURL, created["id"].(string)+".wrong", nil, http.StatusUnauthorized)
+		})
+
+		It("should reject revoked and expired keys", func() {
+			secret, created := createAPIKey(map[string]interface{}{"tenant_id": memberTenantID, "roles": []string{"Member"}})
+			testURL("PUT", apiKeyPluralURL+"/"+created["id"].(string), adminTokenID,
+				map[string]interface{}{"revoked": true}, http.StatusOK)
+			testURL("GET", networkPluralURL, secret, nil, http.StatusUnauthorized)
+
+			secret, _ = createAPIKey(map[string]interface{}{
+				"tenant_id":  memberTenantID,
+				"roles":      []string{"Member"},
+				"expires_at": "2000-01-01T00:00:00Z",
+			})
+			testURL("GET", networkPluralURL, secret, nil, http.StatusUnauthorized)
+		})
+
+		It("should rotate the secret", func() {
+			oldSecret, created := createAPIKey(map[string]interface{}{"tenant_id": memberTenantID, "roles": []string{"Member"}})
+			result := testURL("POST", apiKeyPluralURL+"/"+created["id"].(string)+"/rotate", adminTokenID, nil, http.StatusOK)
+			rotated := result.(map[string]interface{})["api_key"].(map[string]interface{})
+			Expect(rotated).ToNot(HaveKey("secret_hash"))
+			newSecret := rotated["secret"].(string)
+			Expect(newSecret).ToNot(Equal(oldSecret))
+			testURL("GET", networkPluralURL, oldSecret, nil, http.StatusUnauthorized)
+			testURL("GET", networkPluralURL, newSecret, nil, http.StatusOK)
+		})
+
+		It("should be admin only", func() {
+			testURL("GET", apiKeyPluralURL, memberTokenID, nil, http.StatusUnauthorized)
+			testURL("POST", apiKeyPluralURL, memberTokenID, map[string]interface{}{}, http.StatusUnauthorized)
+		})
+
+		It("should be admin only even if other policies allow access", func() {
+			policyPluralURL := baseURL + "/gohan/v0.1/policies"
+			testURL("POST", policyPluralURL, adminTokenID, map[string]interface{}{
+				"id":        "member_all",
+				"principal": "Member",
+				"action":    "*",
+				"effect":    "allow",
+				"resource":  map[string]interface{}{"path": ".*"},
+			}, http.StatusCreated)
+			defer func() {
+				testURL("DELETE", policyPluralURL+"/member_all", adminTokenID, nil, http.StatusNoContent)
+				eventuallyURL("GET", baseURL+"/v2.0/admin_onlys", memberTokenID, nil, http.StatusUnauthorized)
+			}()
+			eventuallyURL("GET", baseURL+"/v2.0/admin_onlys", memberTokenID, nil, http.StatusOK)
+			testURL("GET", apiKeyPluralURL, memberTokenID, nil, http.StatusUnauthorized)
+		})
+
+		It("should not accept secret hash in input", func() {
+			testURL("POST", apiKeyPluralURL, adminTokenID, map[string]interface{}{"secret_hash": "sha256:00"}, http.StatusBadRequest)
+		})
+	})
+
+	Describe("Reload", func() {
+		adminOnlyPluralURL := baseURL + "/v2.0/admin_onlys"
+		policyPluralURL := baseURL + "/gohan/v0.1/policies"
+		extensionPluralURL := baseURL + "/gohan/v0.1/extensions"
+
+		It("should apply policies changed through API", func() {
+			testURL("GET", adminOnlyPluralURL, memberTokenID, nil, http.StatusUnauthorized)
+			testURL("POST", policyPluralURL, adminTokenID, map[string]interface{}{
+				"id":        "member_admin_only",
+				"principal": "Member",
+				"action":    "read",
+				"effect":    "allow",
+				"resource":  map[string]interface{}{"path": "/v2.0/admin_onlys"},
+			}, http.StatusCreated)
+			eventuallyURL("GET", adminOnlyPluralURL, memberTokenID, nil, http.StatusOK)
+			testURL("DELETE", policyPluralURL+"/member_admin_only", adminTokenID, nil, http.StatusNoContent)
+			eventuallyURL("GET", adminOnlyPluralURL, memberTokenID, nil, http.StatusUnauthorized)
+		})
+
+		It("should apply extensions changed through API", func() {
+			testURL("GET", adminOnlyPluralURL, adminTokenID, nil, http.StatusOK)
+			testURL("POST", extensionPluralURL, adminTokenID, map[string]interface{}{
+				"id":        "reloaded_extension",
+				"code_type": "javascript",
+				"path":      "/v2.0/admin_onlys",
+				"code": `gohan_register_handler("pre_list", function(context) {
+					throw new CustomException("reloaded", 390);
+				});`,
+			}, http.StatusCreated)
+			eventuallyURL("GET", adminOnlyPluralURL, adminTokenID, nil, 390)
+			testURL("DELETE", extensionPluralURL+"/reloaded_extension", adminTokenID, nil, http.StatusNoContent)
+			eventuallyURL("GET", adminOnlyPluralURL, adminTokenID, nil, http.StatusOK)
+		})
+	})
+
+	Describe("Policy explain", func() {
+		explainURL := baseURL + "/gohan/v0.1/policies/explain"
+
+		It("should explain decision for given roles", func() {
+			result := testURL("POST", explainURL, adminTokenID, map[string]interface{}{
+				"action":    "read",
+				"path":      "/v2.0/networks",
+				"roles":     []string{"Member"},
+				"tenant_id": memberTenantID,
+			}, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("decision", "allow"))
+			Expect(result).To(HaveKeyWithValue("policy_id", "member_statement2"))
+			Expect(result).To(HaveKeyWithValue("tenant_filter", ConsistOf(powerUserTenantID, memberTenantID)))
+		})
+
+		It("should explain conditions checked against resource", func() {
+			result := testURL("POST", explainURL, adminTokenID, map[string]interface{}{
+				"action":    "update",
+				"path":      "/v2.0/networks/red",
+				"principal": "Member",
+				"tenant_id": memberTenantID,
+				"resource":  map[string]interface{}{"id": "red", "tenant_id": "other"},
+			}, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("decision", "deny"))
+			Expect(result).To(HaveKeyWithValue("policy_id", "member_statement"))
+		})
+
+		It("should explain caller's authorization by default", func() {
+			result := testURL("POST", explainURL, adminTokenID, map[string]interface{}{
+				"action": "delete",
+				"path":   "/v2.0/networks/red",
+			}, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("decision", "allow"))
+			Expect(result).To(HaveKeyWithValue("policy_id", "admin_statement"))
+		})
+
+		It("should require action and path", func() {
+			testURL("POST", explainURL, adminTokenID, map[string]interface{}{"action": "read"}, http.StatusBadRequest)
+		})
+
+		It("should be admin only", func() {
+			testURL("POST", explainURL, memberTokenID, map[string]interface{}{
+				"action": "read",
+				"path":   "/v2.0/networks",
+			}, http.StatusUnauthorized)
+		})
+
+		It("should be allowed by policies", func() {
+			policyPluralURL := baseURL + "/gohan/v0.1/policies"
+			testURL("POST", policyPluralURL, adminTokenID, map[string]interface{}{
+				"id":        "member_explain",
+				"principal": "Member",
+				"action":    "explain",
+				"effect":    "allow",
+				"resource":  map[string]interface{}{"path": "/gohan/v0.1/policies/explain"},
+			}, http.StatusCreated)
+			explain := map[string]interface{}{
+				"action": "read",
+				"path":   "/v2.0/networks",
+			}
+			defer func() {
+				testURL("DELETE", policyPluralURL+"/member_explain", adminTokenID, nil, http.StatusNoContent)
+				eventuallyURL("POST", explainURL, memberTokenID, explain, http.StatusUnauthorized)
+			}()
+			eventuallyURL("POST", explainURL, memberTokenID, explain, http.StatusOK)
+		})
+	})
+
+	Describe("OpenAPI", func() {
+		openAPIURL := baseURL + "/gohan/v0.1/openapi.json"
+
+		It("should describe resources available for admin", func() {
+			result := testURL("GET", openAPIURL, adminTokenID, nil, http.StatusOK)
+			Expect(result).To(HaveKeyWithValue("openapi", "3.0.0"))
+			Expect(result).To(HaveKeyWithValue("paths", HaveKey("/v2.0/networks")))
+			Expect(result).To(HaveKeyWithValue("paths", HaveKey("/v2.0/networks/{id}")))
+		})
+
+		It("should filter resources by policy", func() {
+			admin := testURL("GET", openAPIURL, adminTokenID, nil, http.StatusOK)
+			member := testURL("GET", openAPIURL, memberTokenID, nil, http.StatusOK)
+			adminPaths := admin.(map[string]interface{})["paths"].(map[string]interface{})
+			memberPaths := member.(map[string]interface{})["paths"].(map[string]interface{})
+			Expect(len(memberPaths)).To(BeNumerically("<", len(adminPaths)))
+		})
+
+		It("should not include non-standard keys in component schemas", func() {
+			result := testURL("GET", openAPIURL, adminTokenID, nil, http.StatusOK)
+			components := result.(map[string]interface{})["components"].(map[string]interface{})
+			schemas := components["schemas"].(map[string]interface{})
+			for _, id := range []string{"network", "network_create", "network_update"} {
+				Expect(schemas).To(HaveKey(id))
+				Expect(schemas[id]).NotTo(HaveKey("propertiesOrder"))
+			}
+		})
+	})
+
 	Describe("Resource Actions", func() {
 		responderPluralURL := baseURL + "/v2.0/responders"
 		responderParentPluralURL := baseURL + "/v2.0/responder_parents"
@@ -1408,6 +1780,14 @@ func testURL(method, url, token string, postData interface{}, expectedCode int)
 	return data
 }
 
+//eventuallyURL waits until the request returns expectedCode, e.g. after policies are reloaded
+func eventuallyURL(method, url, token string, postData interface{}, expectedCode int) {
+	EventuallyWithOffset(1, func() int {
+		_, resp := httpRequest(method, url, token, postData)
+		return resp.StatusCode
+	}, 5*time.Second, 50*time.Millisecond).Should(Equal(expectedCode))
+}
+
 func httpRequest(method, url, token string, postData interface{}) (interface{}, *http.Response) {
 	client := &http.Client{}
 	var reader io.Reader
@@ -0,0 +1,214 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cloudwan/gohan/db/transaction"
+	l "github.com/cloudwan/gohan/log"
+	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/server/resources"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+)
+
+//StateUpdatedPath is a sync path used to notify API nodes about state updates of resources
+const StateUpdatedPath = "/gohan/state_updated"
+
+const (
+	defaultStateWaitTimeout = 30 * time.Second
+	maxStateWaitTimeout     = 5 * time.Minute
+)
+
+//stateWait tracks requests waiting for state updates of resources on this API node
+type stateWait struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]bool
+	stop    chan bool
+}
+
+func stateWaitKey(schemaID, resourceID string) string {
+	return schemaID + "/" + resourceID
+}
+
+//subscribe returns a channel receiving notifications of state updates of the resource
+func (w *stateWait) subscribe(schemaID, resourceID string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.waiters == nil {
+		w.waiters = map[string]map[chan struct{}]bool{}
+	}
+	key := stateWaitKey(schemaID, resourceID)
+	if w.waiters[key] == nil {
+		w.waiters[key] = map[chan struct{}]bool{}
+	}
+	notified := make(chan struct{}, 1)
+	w.waiters[key][notified] = true
+	return notified
+}
+
+func (w *stateWait) unsubscribe(schemaID, resourceID string, notified chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	key := stateWaitKey(schemaID, resourceID)
+	delete(w.waiters[key], notified)
+	if len(w.waiters[key]) == 0 {
+		delete(w.waiters, key)
+	}
+}
+
+//notify wakes up requests waiting for the resource
+func (w *stateWait) notify(schemaID, resourceID string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for notified := range w.waiters[stateWaitKey(schemaID, resourceID)] {
+		select {
+		case notified <- struct{}{}:
+		default:
+		}
+	}
+}
+
+//notifyStateUpdate tells all API nodes that the state of the resource was updated
+func (server *Server) notifyStateUpdate(schemaID, resourceID string) {
+	if server.sync == nil {
+		return
+	}
+	data, _ := json.Marshal(map[string]interface{}{
+		"id":   resourceID,
+		"time": time.Now().UnixNano(),
+	})
+	if err := server.sync.Update(StateUpdatedPath+"/"+schemaID, string(data)); err != nil {
+		log.Warning("Failed to notify state update of %s %s: %s", schemaID, resourceID, err)
+	}
+}
+
+//startStateWaitProcess wakes up requests waiting for state updates made by any API node
+func startStateWaitProcess(server *Server) {
+	events := make(chan *gohan_sync.Event, 16)
+	server.stateWait.stop = make(chan bool)
+	stop := server.stateWait.stop
+
+	go func() {
+		defer l.LogFatalPanic(log)
+		for server.running {
+			err := server.sync.Watch(StateUpdatedPath, events, stop, gohan_sync.RevisionCurrent)
+			if err != nil {
+				log.Error(fmt.Sprintf("state update watch error: %s", err))
+				select {
+				case <-stop:
+					return
+				case <-time.After(5 * time.Second):
+				}
+			}
+		}
+	}()
+
+	go func() {
+		defer l.LogFatalPanic(log)
+		for {
+			select {
+			case <-stop:
+				return
+			case event := <-events:
+				if event.Action == "delete" {
+					continue
+				}
+				resourceID, _ := event.Data["id"].(string)
+				schemaID := strings.TrimPrefix(event.Key, StateUpdatedPath+"/")
+				server.stateWait.notify(schemaID, resourceID)
+			}
+		}
+	}()
+}
+
+func stopStateWaitProcess(server *Server) {
+	if server.stateWait.stop != nil {
+		close(server.stateWait.stop)
+		server.stateWait.stop = nil
+	}
+}
+
+//stateWaitTimeout reads the timeout of waiting for state from the query
+//It is a duration like 30s or a number of seconds.
+func stateWaitTimeout(r *http.Request) (time.Duration, error) {
+	value := r.URL.Query().Get("timeout")
+	if value == "" {
+		return defaultStateWaitTimeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.Atoi(value)
+		if convErr != nil {
+			return 0, resources.NewResourceError(err, fmt.Sprintf("Invalid timeout parameter: %s", value), resources.WrongQuery)
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+	if timeout < 0 {
+		return 0, resources.NewResourceError(fmt.Errorf("negative timeout"), fmt.Sprintf("Invalid timeout parameter: %s", value), resources.WrongQuery)
+	}
+	if timeout > maxStateWaitTimeout {
+		timeout = maxStateWaitTimeout
+	}
+	return timeout, nil
+}
+
+//stateSettled checks if the state caught up with the config version
+//An error is reported together with the state, one left by an older version doesn't settle it.
+func stateSettled(state transaction.ResourceState) bool {
+	return state.StateVersion >= state.ConfigVersion
+}
+
+//waitForState blocks until the state of the resource settles or the timeout expires
+//It is woken up by notifications of state updates, so the database is read only after them.
+func (server *Server) waitForState(s *schema.Schema, resourceID string, timeout time.Duration) error {
+	if server.sync == nil {
+		return nil
+	}
+	notified := server.stateWait.subscribe(s.ID, resourceID)
+	defer server.stateWait.unsubscribe(s.ID, resourceID, notified)
+
+	expired := time.After(timeout)
+	for {
+		state, err := server.fetchState(s, resourceID)
+		if err != nil {
+			return err
+		}
+		if stateSettled(state) {
+			return nil
+		}
+		select {
+		case <-notified:
+		case <-expired:
+			return nil
+		}
+	}
+}
+
+func (server *Server) fetchState(s *schema.Schema, resourceID string) (transaction.ResourceState, error) {
+	tx, err := server.db.Begin()
+	if err != nil {
+		return transaction.ResourceState{}, err
+	}
+	defer tx.Close()
+	return tx.StateFetch(s, transaction.IDFilter(resourceID))
+}
@@ -0,0 +1,170 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+)
+
+func TestStateWaitTimeout(t *testing.T) {
+	for _, test := range []struct {
+		query   string
+		timeout time.Duration
+		valid   bool
+	}{
+		{"", defaultStateWaitTimeout, true},
+		{"timeout=10s", 10 * time.Second, true},
+		{"timeout=15", 15 * time.Second, true},
+		{"timeout=1h", maxStateWaitTimeout, true},
+		{"timeout=-1s", 0, false},
+		{"timeout=soon", 0, false},
+	} {
+		r, _ := http.NewRequest("GET", "/v2.0/with_sync_propertys/r1/state?wait=true&"+test.query, nil)
+		timeout, err := stateWaitTimeout(r)
+		if (err == nil) != test.valid || timeout != test.timeout {
+			t.Errorf("%s: got (%s, %v)", test.query, timeout, err)
+		}
+	}
+}
+
+func TestWaitForState(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+	server.running = true
+	startStateWaitProcess(server)
+	defer stopStateWaitProcess(server)
+
+	createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r1", "p0": "created"})
+	s, _ := schema.GetManager().Schema("with_sync_property")
+
+	start := time.Now()
+	if err := server.waitForState(s, "r1", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected to wait until the timeout while the state is behind")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.waitForState(s, "r1", 5*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	key := statePrefix + s.URL + "/r1"
+	if err := backend.Update(key, `{"version": 1, "state": "up"}`); err != nil {
+		t.Fatal(err)
+	}
+	event := &gohan_sync.Event{
+		Action: "set",
+		Key:    key,
+		Data:   map[string]interface{}{"version": float64(1), "state": "up"},
+	}
+	if err := StateUpdate(event, server); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait didn't return after the state update")
+	}
+
+	state, err := server.fetchState(s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stateSettled(state) {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
+
+func TestWaitForStateIgnoresStaleError(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+	server.running = true
+	startStateWaitProcess(server)
+	defer stopStateWaitProcess(server)
+
+	resource := createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r1", "p0": "created"})
+	s := resource.Schema()
+	key := statePrefix + s.URL + "/r1"
+	updateState := func(data map[string]interface{}) {
+		if err := backend.Update(key, "{}"); err != nil {
+			t.Fatal(err)
+		}
+		if err := StateUpdate(&gohan_sync.Event{Action: "set", Key: key, Data: data}, server); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updateState(map[string]interface{}{"version": float64(1), "state": "down", "error": "failed"})
+
+	tx, err := (&DbSyncWrapper{DB: dataStore}).Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resource.Update(map[string]interface{}{"p0": "updated"})
+	if err := tx.Update(resource); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	tx.Close()
+
+	state, err := server.fetchState(s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.ConfigVersion != 2 || state.StateVersion != 1 || state.Error != "failed" {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+	start := time.Now()
+	if err := server.waitForState(s, "r1", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected an error of an older version not to settle the state")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.waitForState(s, "r1", 5*time.Second)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	updateState(map[string]interface{}{"version": float64(2), "state": "down", "error": "failed again"})
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("wait didn't return after the state update")
+	}
+	state, err = server.fetchState(s, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stateSettled(state) || state.Error != "failed again" {
+		t.Errorf("unexpected state: %+v", state)
+	}
+}
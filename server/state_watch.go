@@ -20,7 +20,6 @@ import (
 	"regexp"
 	"strings"
 	"sync"
-	"time"
 
 	"github.com/cloudwan/gohan/db/transaction"
 	"github.com/cloudwan/gohan/extension"
@@ -43,7 +42,6 @@ var stateWatchTrimmer = regexp.MustCompile("^(" + statePrefix + "|" + monitoring
 //TODO(nati) integrate with watch process
 func startStateWatchProcess(server *Server) {
 	stateResponseChan := make(chan *gohan_sync.Event)
-	stateStopChan := make(chan bool)
 
 	for _, toCreate := range []string{stateWatchPrefix, statePrefix, monitoringPrefix} {
 		if _, err := server.sync.Fetch(toCreate); err != nil {
@@ -51,27 +49,17 @@ func startStateWatchProcess(server *Server) {
 		}
 	}
 
+	stateElection := server.newElection(lockPath + "/state_watch")
 	go func() {
 		defer l.LogFatalPanic(log)
 
-		for server.running {
-			lockKey := lockPath + "/state_watch"
-			err := server.sync.Lock(lockKey, true)
-			if err != nil {
-				log.Warning("Can't start state watch process due to lock", err)
-				time.Sleep(5 * time.Second)
-				continue
-			}
-			defer func() {
-				server.sync.Unlock(lockKey)
-			}()
-
-			err = server.sync.Watch(stateWatchPrefix, stateResponseChan, stateStopChan,
+		stateElection.Run(func(stopChan chan bool) {
+			err := server.sync.Watch(stateWatchPrefix, stateResponseChan, stopChan,
 				gohan_sync.RevisionCurrent)
 			if err != nil {
 				log.Error(fmt.Sprintf("sync state watch error: %s", err))
 			}
-		}
+		})
 	}()
 
 	go func() {
@@ -84,6 +72,10 @@ func startStateWatchProcess(server *Server) {
 			response := <-stateResponseChan
 
 			key := stateWatchTrimmer.ReplaceAllLiteralString(response.Key, "")
+			//updates reported by agents of the same resource are processed in order
+			if resourcePath, _, ok := splitAgentStateKey(key); ok {
+				key = resourcePath
+			}
 			bufferMutex.Lock()
 			buffer, ok := buffers[key]
 			if !ok {
@@ -123,8 +115,6 @@ func startStateWatchProcess(server *Server) {
 			buffer <- response
 			bufferMutex.Unlock()
 		}
-
-		stateStopChan <- true
 	}()
 
 }
@@ -135,7 +125,10 @@ func stopStateWatchProcess(server *Server) {
 //StateUpdate updates the state in the db based on the sync event
 func StateUpdate(response *gohan_sync.Event, server *Server) error {
 	dataStore := server.db
-	schemaPath := strings.TrimPrefix(response.Key, statePrefix)
+	schemaPath, agent, isAgentState := splitAgentStateKey(strings.TrimPrefix(response.Key, statePrefix))
+	if isAgentState && agent == "" {
+		return nil
+	}
 	var curSchema = schema.GetSchemaByPath(schemaPath)
 	if curSchema == nil || !curSchema.StateVersioning() {
 		log.Debug("State update on unexpected path '%s'", schemaPath)
@@ -164,7 +157,19 @@ func StateUpdate(response *gohan_sync.Event, server *Server) error {
 	if resourceState.StateVersion == resourceState.ConfigVersion {
 		return nil
 	}
-	stateVersion, ok := response.Data["version"].(float64)
+	stateData := response.Data
+	if isAgentState {
+		//states of all agents are aggregated into the state of the resource
+		agentStates := fetchAgentStates(server.sync, schemaPath)
+		version, state, stateError := aggregateAgentStates(curSchema, agentStates)
+		stateData = map[string]interface{}{
+			"version": float64(version),
+			"state":   state,
+			"error":   stateError,
+			"agents":  agentStatesData(agentStates),
+		}
+	}
+	stateVersion, ok := stateData["version"].(float64)
 	if !ok {
 		return fmt.Errorf("No version in state information")
 	}
@@ -173,10 +178,10 @@ func StateUpdate(response *gohan_sync.Event, server *Server) error {
 	if resourceState.StateVersion < oldStateVersion {
 		return nil
 	}
-	if newError, ok := response.Data["error"].(string); ok {
+	if newError, ok := stateData["error"].(string); ok {
 		resourceState.Error = newError
 	}
-	if newState, ok := response.Data["state"].(string); ok {
+	if newState, ok := stateData["state"].(string); ok {
 		resourceState.State = newState
 	}
 
@@ -194,7 +199,7 @@ func StateUpdate(response *gohan_sync.Event, server *Server) error {
 		context["auth_token"] = serviceAuthorization.AuthToken()
 		context["resource"] = curResource.Data()
 		context["schema"] = curSchema
-		context["state"] = response.Data
+		context["state"] = stateData
 		context["config_version"] = resourceState.ConfigVersion
 		context["transaction"] = tx
 
@@ -214,7 +219,11 @@ func StateUpdate(response *gohan_sync.Event, server *Server) error {
 		}
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	server.notifyStateUpdate(curSchema.ID, resourceID)
+	return nil
 }
 
 //MonitoringUpdate updates the state in the db based on the sync event
@@ -18,11 +18,14 @@ package server
 import (
 	"encoding/json"
 	"fmt"
+	syn "sync"
 	"time"
 
 	"github.com/cloudwan/gohan/db/pagination"
+	"github.com/cloudwan/gohan/db/transaction"
 	l "github.com/cloudwan/gohan/log"
 	"github.com/cloudwan/gohan/schema"
+	"github.com/cloudwan/gohan/util"
 )
 
 const (
@@ -33,52 +36,248 @@ const (
 
 	eventPollingTime  = 30 * time.Second
 	eventPollingLimit = 10000
+
+	deadLetterSchemaID = "dead_letter"
+
+	defaultEventMaxRetries = 5
+	defaultEventBackoff    = 1
+	defaultEventMaxBackoff = 300
+	defaultSyncWorkers     = 8
+	defaultEventBatchSize  = 100
 )
 
 //Start sync Process
 func startSyncProcess(server *Server) {
 	pollingTicker := time.Tick(eventPollingTime)
 	committed := transactionCommitInformer()
+	syncElection := server.newElection(syncPath)
 	go func() {
 		defer l.LogFatalPanic(log)
-		recentlySynced := false
-		for server.running {
-			select {
-			case <-pollingTicker:
-				if recentlySynced {
-					recentlySynced = false
-					continue
+		syncElection.Run(func(stopChan chan bool) {
+			recentlySynced := false
+			for server.running {
+				select {
+				case <-stopChan:
+					return
+				case <-pollingTicker:
+					if recentlySynced {
+						recentlySynced = false
+						continue
+					}
+				case <-committed:
+					recentlySynced = true
 				}
-			case <-committed:
-				recentlySynced = true
+				server.Sync()
 			}
-			server.sync.Lock(syncPath, true)
-			server.Sync()
-		}
-		server.sync.Unlock(syncPath)
+		})
 	}()
 }
 
 //Stop Sync Process
 func stopSyncProcess(server *Server) {
-	server.sync.Unlock(syncPath)
+}
+
+//eventRetry configures retries of events which failed to sync
+type eventRetry struct {
+	maxRetries int
+	backoff    time.Duration
+	maxBackoff time.Duration
+}
+
+//delay returns the backoff after the given number of failures
+func (retry *eventRetry) delay(retryCount int) time.Duration {
+	delay := retry.backoff
+	for i := 1; i < retryCount && delay < retry.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > retry.maxBackoff {
+		delay = retry.maxBackoff
+	}
+	return delay
 }
 
 //Sync to sync backend database table
+//Events of different paths are synced concurrently by workers and events of a path in order.
+//Failing events are retried with backoff and moved to dead letters after too many failures.
+//Later events of the same path wait for a failing event to keep their order.
+//Synced events are deleted in batches, so an event may be synced again after a crash.
 func (server *Server) Sync() error {
+	startTime := time.Now()
 	resourceList, err := server.listEvents()
 	if err != nil {
 		return err
 	}
+	syncEventsPending.Set(float64(len(resourceList)))
+
+	paths := []string{}
+	eventsByPath := map[string][]*schema.Resource{}
 	for _, resource := range resourceList {
-		err = server.syncEvent(resource)
-		if err != nil {
+		resourcePath := util.MaybeString(resource.Get("path"))
+		if _, ok := eventsByPath[resourcePath]; !ok {
+			paths = append(paths, resourcePath)
+		}
+		eventsByPath[resourcePath] = append(eventsByPath[resourcePath], resource)
+	}
+
+	var mu syn.Mutex
+	var wg syn.WaitGroup
+	synced := []interface{}{}
+	var syncErr error
+	pathChan := make(chan []*schema.Resource)
+	for i := 0; i < server.syncWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer l.LogFatalPanic(log)
+			defer wg.Done()
+			for events := range pathChan {
+				ids, err := server.syncEvents(events, startTime)
+				mu.Lock()
+				synced = append(synced, ids...)
+				if err != nil && syncErr == nil {
+					syncErr = err
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, resourcePath := range paths {
+		pathChan <- eventsByPath[resourcePath]
+	}
+	close(pathChan)
+	wg.Wait()
+
+	err = server.deleteEvents(synced)
+	syncPassDuration.Observe(time.Since(startTime).Seconds())
+	if syncErr != nil {
+		return syncErr
+	}
+	return err
+}
+
+//syncBackendError is a failure of the sync backend itself
+//It says nothing about the event, so it doesn't count as a failed attempt to sync it.
+type syncBackendError struct {
+	err error
+}
+
+func (e syncBackendError) Error() string {
+	return e.err.Error()
+}
+
+//syncEvents syncs events of a path in order until one fails or waits for a retry
+//It returns IDs of synced events.
+//Only failures caused by the event itself count toward max_retries, on backend errors the pass stops.
+func (server *Server) syncEvents(events []*schema.Resource, now time.Time) ([]interface{}, error) {
+	synced := []interface{}{}
+	for _, resource := range events {
+		if int64(util.MaybeInt(resource.Get("next_retry"))) > now.Unix() {
+			break
+		}
+		startTime := time.Now()
+		syncErr := server.syncEvent(resource)
+		syncEventDuration.Observe(time.Since(startTime).Seconds())
+		if syncErr == nil {
+			syncEventsTotal.WithLabelValues(syncResultSynced).Inc()
+			synced = append(synced, resource.Get("id"))
+			continue
+		}
+		if backendErr, ok := syncErr.(syncBackendError); ok {
+			log.Warning("sync backend failed on event %v, retrying in the next pass: %s", resource.Get("id"), backendErr.err)
+			syncEventsTotal.WithLabelValues(syncResultFailed).Inc()
+			return synced, backendErr.err
+		}
+		log.Warning("failed to sync event %v: %s", resource.Get("id"), syncErr)
+		return synced, server.failEvent(resource, syncErr, now)
+	}
+	return synced, nil
+}
+
+//deleteEvents deletes synced events in batches
+//Each batch is deleted with a single id filter in its own transaction.
+func (server *Server) deleteEvents(ids []interface{}) error {
+	eventSchema, _ := schema.GetManager().Schema("event")
+	for len(ids) > 0 {
+		batch := ids
+		if len(batch) > server.eventBatchSize {
+			batch = ids[:server.eventBatchSize]
+		}
+		ids = ids[len(batch):]
+		if err := server.deleteEventBatch(eventSchema, batch); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+func (server *Server) deleteEventBatch(eventSchema *schema.Schema, ids []interface{}) error {
+	log.Debug("delete events %v", ids)
+	tx, err := server.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	if err = tx.DeleteFilter(eventSchema, transaction.Filter{"id": ids}); err != nil {
+		log.Error(fmt.Sprintf("delete failed: %s", err))
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Error(fmt.Sprintf("commit failed: %s", err))
+		return err
+	}
+	return nil
+}
+
+//failEvent records a failed attempt to sync the event
+func (server *Server) failEvent(resource *schema.Resource, syncErr error, now time.Time) error {
+	schemaManager := schema.GetManager()
+	eventSchema, _ := schemaManager.Schema("event")
+	retryCount := util.MaybeInt(resource.Get("retry_count")) + 1
+	tx, err := server.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Close()
+	if retryCount >= server.eventRetry.maxRetries {
+		log.Error("moving event %v to dead letters after %d failures", resource.Get("id"), retryCount)
+		deadLetterSchema, _ := schemaManager.Schema(deadLetterSchemaID)
+		deadLetter, _ := schema.NewResource(deadLetterSchema, map[string]interface{}{
+			"id":            resource.Get("id"),
+			"type":          resource.Get("type"),
+			"path":          resource.Get("path"),
+			"version":       resource.Get("version"),
+			"body":          resource.Get("body"),
+			"sync_plain":    resource.Get("sync_plain"),
+			"sync_property": resource.Get("sync_property"),
+			"timestamp":     resource.Get("timestamp"),
+			"retry_count":   retryCount,
+			"last_error":    syncErr.Error(),
+			"failed_at":     now.Unix(),
+		})
+		if err = tx.Create(deadLetter); err != nil {
+			return err
+		}
+		if err = tx.Delete(eventSchema, resource.Get("id")); err != nil {
+			return err
+		}
+		syncEventsTotal.WithLabelValues(syncResultDeadLetter).Inc()
+		return tx.Commit()
+	}
+	syncEventsTotal.WithLabelValues(syncResultFailed).Inc()
+	data := map[string]interface{}{}
+	for key, value := range resource.Data() {
+		data[key] = value
+	}
+	data["retry_count"] = retryCount
+	data["next_retry"] = now.Add(server.eventRetry.delay(retryCount)).Unix()
+	data["last_error"] = syncErr.Error()
+	event, _ := schema.NewResource(eventSchema, data)
+	if err = tx.Update(event); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
 func (server *Server) listEvents() ([]*schema.Resource, error) {
 	tx, err := server.db.Begin()
 	if err != nil {
@@ -95,20 +294,18 @@ func (server *Server) listEvents() ([]*schema.Resource, error) {
 	return resourceList, nil
 }
 
+//syncEvent writes the event to sync backend
 func (server *Server) syncEvent(resource *schema.Resource) error {
-	schemaManager := schema.GetManager()
-	eventSchema, _ := schemaManager.Schema("event")
-	tx, err := server.db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Close()
-	eventType := resource.Get("type").(string)
-	resourcePath := resource.Get("path").(string)
-	body := resource.Get("body").(string)
-	syncPlain := resource.Get("sync_plain").(bool)
-	syncProperty := resource.Get("sync_property").(string)
+	var err error
+	eventType := util.MaybeString(resource.Get("type"))
+	resourcePath := util.MaybeString(resource.Get("path"))
+	body := util.MaybeString(resource.Get("body"))
+	syncPlain, _ := resource.Get("sync_plain").(bool)
+	syncProperty := util.MaybeString(resource.Get("sync_property"))
 
+	if schema.GetSchemaByURLPath(resourcePath) == nil {
+		return fmt.Errorf("no schema found for path %s", resourcePath)
+	}
 	path := generatePath(resourcePath, body)
 
 	version, ok := resource.Get("version").(int)
@@ -120,49 +317,15 @@ func (server *Server) syncEvent(resource *schema.Resource) error {
 	if eventType == "create" || eventType == "update" {
 		log.Debug("set %s on sync", path)
 
-		content := body
-
-		var data map[string]interface{}
-		if syncProperty != "" {
-			err = json.Unmarshal(([]byte)(body), &data)
-			if err != nil {
-				log.Error(fmt.Sprintf("failed to unmarshal body on sync: %s", err))
-				return err
-			}
-			target, ok := data[syncProperty]
-			if !ok {
-				return fmt.Errorf("could not find property `%s`", syncProperty)
-			}
-			jsonData, err := json.Marshal(target)
-			if err != nil {
-				return err
-			}
-			content = string(jsonData)
-		}
-
-		if syncPlain {
-			var target interface{}
-			json.Unmarshal([]byte(content), &target)
-			switch target.(type) {
-			case string:
-				content = fmt.Sprintf("%v", target)
-			}
-		} else {
-			data, err := json.Marshal(map[string]interface{}{
-				"body":    content,
-				"version": version,
-			})
-			if err != nil {
-				log.Error(fmt.Sprintf("When marshalling sync object: %s", err))
-				return err
-			}
-			content = string(data)
+		content, err := syncValue(body, version, syncPlain, syncProperty)
+		if err != nil {
+			return err
 		}
 
 		err = server.sync.Update(path, content)
 		if err != nil {
 			log.Error(fmt.Sprintf("%s on sync", err))
-			return err
+			return syncBackendError{err}
 		}
 	} else if eventType == "delete" {
 		log.Debug("delete %s", resourcePath)
@@ -191,23 +354,53 @@ func (server *Server) syncEvent(resource *schema.Resource) error {
 		err = server.sync.Delete(path)
 		if err != nil {
 			log.Error(fmt.Sprintf("Delete from sync failed %s", err))
-			return err
+			return syncBackendError{err}
 		}
 	}
-	log.Debug("delete event %d", resource.Get("id"))
-	id := resource.Get("id")
-	err = tx.Delete(eventSchema, id)
-	if err != nil {
-		log.Error(fmt.Sprintf("delete failed: %s", err))
-		return err
+	return nil
+}
+
+//syncValue returns the value of a resource key in sync backend
+func syncValue(body string, version int, syncPlain bool, syncProperty string) (string, error) {
+	content := body
+
+	var data map[string]interface{}
+	if syncProperty != "" {
+		err := json.Unmarshal(([]byte)(body), &data)
+		if err != nil {
+			log.Error(fmt.Sprintf("failed to unmarshal body on sync: %s", err))
+			return "", err
+		}
+		target, ok := data[syncProperty]
+		if !ok {
+			return "", fmt.Errorf("could not find property `%s`", syncProperty)
+		}
+		jsonData, err := json.Marshal(target)
+		if err != nil {
+			return "", err
+		}
+		content = string(jsonData)
 	}
 
-	err = tx.Commit()
-	if err != nil {
-		log.Error(fmt.Sprintf("commit failed: %s", err))
-		return err
+	if syncPlain {
+		var target interface{}
+		json.Unmarshal([]byte(content), &target)
+		switch target.(type) {
+		case string:
+			content = fmt.Sprintf("%v", target)
+		}
+	} else {
+		data, err := json.Marshal(map[string]interface{}{
+			"body":    content,
+			"version": version,
+		})
+		if err != nil {
+			log.Error(fmt.Sprintf("When marshalling sync object: %s", err))
+			return "", err
+		}
+		content = string(data)
 	}
-	return nil
+	return content, nil
 }
 
 func generatePath(resourcePath string, body string) string {
@@ -0,0 +1,278 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/db"
+	"github.com/cloudwan/gohan/db/transaction"
+	"github.com/cloudwan/gohan/schema"
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+//newTestSyncServer creates a server syncing a sqlite database to an in-memory sync
+func newTestSyncServer(t *testing.T) (*Server, db.DB, *memory.Sync, func()) {
+	dir, err := ioutil.TempDir("", "gohan_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cleanup := func() {
+		schema.ClearManager()
+		os.RemoveAll(dir)
+	}
+	if err := schema.GetManager().LoadSchemasFromFiles(
+		"../etc/schema/gohan.json",
+		"../tests/test_abstract_schema.yaml",
+		"../tests/test_schema.yaml",
+		"../tests/test_schema_sync.yaml"); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	dbFile := filepath.Join(dir, "test.db")
+	if err := db.InitDBWithSchemas("sqlite3", dbFile, true, false, false); err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	dataStore, err := db.ConnectDB("sqlite3", dbFile, db.DefaultMaxOpenConn)
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	backend, err := memory.NewSync("")
+	if err != nil {
+		cleanup()
+		t.Fatal(err)
+	}
+	server := &Server{
+		db:             dataStore,
+		sync:           backend,
+		eventRetry:     eventRetry{maxRetries: 5},
+		syncWorkers:    4,
+		eventBatchSize: 3,
+	}
+	return server, dataStore, backend, cleanup
+}
+
+//createSyncedResource creates a resource logging its event
+func createSyncedResource(t *testing.T, dataStore db.DB, schemaID string, data map[string]interface{}) *schema.Resource {
+	resource, err := schema.GetManager().LoadResource(schemaID, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := (&DbSyncWrapper{DB: dataStore}).Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Close()
+	if err := tx.Create(resource); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return resource
+}
+
+//batchRecordingDB records the ids of every filtered delete
+type batchRecordingDB struct {
+	db.DB
+	batches [][]interface{}
+}
+
+type batchRecordingTransaction struct {
+	transaction.Transaction
+	db *batchRecordingDB
+}
+
+func (rdb *batchRecordingDB) Begin() (transaction.Transaction, error) {
+	tx, err := rdb.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &batchRecordingTransaction{tx, rdb}, nil
+}
+
+func (tx *batchRecordingTransaction) DeleteFilter(s *schema.Schema, filter transaction.Filter) error {
+	if ids, ok := filter["id"].([]interface{}); ok {
+		tx.db.batches = append(tx.db.batches, ids)
+	}
+	return tx.Transaction.DeleteFilter(s, filter)
+}
+
+func TestDeleteEventsInBatches(t *testing.T) {
+	server, dataStore, _, cleanup := newTestSyncServer(t)
+	defer cleanup()
+
+	for i := 0; i < 7; i++ {
+		createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{
+			"id": fmt.Sprintf("r%d", i), "p0": "created",
+		})
+	}
+	eventSchema, _ := schema.GetManager().Schema("event")
+	listEvents := func() []*schema.Resource {
+		tx, err := dataStore.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Close()
+		events, _, err := tx.List(eventSchema, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return events
+	}
+	ids := []interface{}{}
+	for _, event := range listEvents() {
+		ids = append(ids, event.Get("id"))
+	}
+	if len(ids) != 7 {
+		t.Fatalf("Expected 7 events, got %d", len(ids))
+	}
+
+	recorder := &batchRecordingDB{DB: dataStore}
+	server.db = recorder
+	if err := server.deleteEvents(ids); err != nil {
+		t.Fatal(err)
+	}
+	sizes := []int{}
+	for _, batch := range recorder.batches {
+		sizes = append(sizes, len(batch))
+	}
+	if fmt.Sprint(sizes) != "[3 3 1]" {
+		t.Errorf("Expected batches of [3 3 1], got %v", sizes)
+	}
+	if events := listEvents(); len(events) != 0 {
+		t.Errorf("Expected all events to be deleted, %d left", len(events))
+	}
+}
+
+func TestSync(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+
+	for i := 0; i < 10; i++ {
+		createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{
+			"id": fmt.Sprintf("r%d", i), "p0": "created",
+		})
+	}
+	withSyncPropertySchema, _ := schema.GetManager().Schema("with_sync_property")
+	for _, p0 := range []string{"first", "second", "last"} {
+		tx, err := (&DbSyncWrapper{DB: dataStore}).Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 10; i++ {
+			resource, _ := schema.NewResource(withSyncPropertySchema, map[string]interface{}{
+				"id": fmt.Sprintf("r%d", i), "p0": p0,
+			})
+			if err := tx.Update(resource); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		tx.Close()
+	}
+	poisoned := createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "poisoned"})
+
+	if err := server.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	responseChan := make(chan *gohan_sync.Event)
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	go backend.Watch(configPrefix, responseChan, stopChan, 0)
+	values := map[string][]interface{}{}
+	for i := 0; i < 40; i++ {
+		select {
+		case event := <-responseChan:
+			values[event.Key] = append(values[event.Key], event.Data["body"])
+		case <-time.After(time.Second):
+			t.Fatalf("Expected 40 events, got %d", i)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("%s/v2.0/with_sync_properties/r%d", configPrefix, i)
+		if fmt.Sprint(values[key]) != `["created" "first" "second" "last"]` {
+			t.Errorf("Expected events of %s to be synced in order, got %v", key, values[key])
+		}
+	}
+	if _, err := backend.Fetch(configPrefix + poisoned.Path()); err == nil {
+		t.Error("Expected failing event not to be synced")
+	}
+
+	events, err := server.listEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Get("retry_count") != 1 {
+		t.Errorf("Expected only failing event to be kept, got %v", events)
+	}
+}
+
+func TestEventRetryDelay(t *testing.T) {
+	retry := &eventRetry{maxRetries: 5, backoff: time.Second, maxBackoff: 10 * time.Second}
+	for retryCount, expected := range map[int]time.Duration{
+		1:  time.Second,
+		2:  2 * time.Second,
+		4:  8 * time.Second,
+		5:  10 * time.Second,
+		50: 10 * time.Second,
+	} {
+		if delay := retry.delay(retryCount); delay != expected {
+			t.Errorf("Expected delay %s after %d failures, got %s", expected, retryCount, delay)
+		}
+	}
+}
+
+//failingSync is a sync backend which fails all writes
+type failingSync struct {
+	*memory.Sync
+}
+
+func (s *failingSync) Update(key, value string) error {
+	return fmt.Errorf("backend unavailable")
+}
+
+func TestSyncBackendErrorIsNotCounted(t *testing.T) {
+	server, dataStore, backend, cleanup := newTestSyncServer(t)
+	defer cleanup()
+	server.sync = &failingSync{backend}
+
+	createSyncedResource(t, dataStore, "with_sync_property", map[string]interface{}{"id": "r1", "p0": "created"})
+	for i := 0; i < server.eventRetry.maxRetries+1; i++ {
+		if err := server.Sync(); err == nil {
+			t.Fatal("Expected backend error to be returned")
+		}
+	}
+
+	events, err := server.listEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Get("retry_count") != nil {
+		t.Errorf("Expected event to be kept without counting backend errors, got %v", events)
+	}
+}
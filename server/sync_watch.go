@@ -19,13 +19,13 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/cloudwan/gohan/extension"
 	"github.com/cloudwan/gohan/job"
 
 	l "github.com/cloudwan/gohan/log"
 	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/election"
 	"github.com/cloudwan/gohan/util"
 )
 
@@ -56,24 +56,12 @@ func startSyncWatchProcess(server *Server) {
 		extensions[event] = env
 	}
 	responseChans := make(map[string]chan *gohan_sync.Event)
-	stopChan := make(chan bool)
 	for _, path := range watch {
 		responseChans[path] = make(chan *gohan_sync.Event)
-		go func(path string) {
+		go func(path string, watchElection *election.Election) {
 			defer l.LogFatalPanic(log)
 			responseChan := responseChans[path]
-			for server.running {
-				lockKey := lockPath + "/watch" + path
-				err := server.sync.Lock(lockKey, true)
-				if err != nil {
-					log.Warning("Can't start watch process due to lock", err)
-					time.Sleep(5 * time.Second)
-					continue
-				}
-				defer func() {
-					server.sync.Unlock(lockKey)
-				}()
-
+			watchElection.Run(func(stopChan chan bool) {
 				fromRevision := int64(gohan_sync.RevisionCurrent)
 				lastSeen, err := server.sync.Fetch(SyncWatchRevisionPrefix + path)
 				if err == nil {
@@ -88,8 +76,8 @@ func startSyncWatchProcess(server *Server) {
 				if err != nil {
 					log.Error(fmt.Sprintf("sync watch error: %s", err))
 				}
-			}
-		}(path)
+			})
+		}(path, server.newElection(lockPath+"/watch"+path))
 	}
 	//main response lisnter process
 	for _, path := range watch {
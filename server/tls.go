@@ -0,0 +1,229 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	clientAuthOptional = "optional"
+	clientAuthRequired = "required"
+)
+
+type tlsConfig struct {
+	CertFile string
+	KeyFile  string
+	// ClientCAFile is a PEM bundle of CAs client certificates are verified against
+	ClientCAFile string
+	// ClientAuth is either clientAuthOptional or clientAuthRequired
+	ClientAuth string
+	// CRLFile is an optional certificate revocation list issued by a client CA
+	CRLFile string
+}
+
+//newTLSConfig builds TLS configuration of the server listener
+func newTLSConfig(config *tlsConfig) (*tls.Config, error) {
+	serverCertificate, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	result := &tls.Config{
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		Certificates: []tls.Certificate{serverCertificate},
+	}
+	if config.ClientCAFile == "" {
+		return result, nil
+	}
+
+	clientCAs, err := loadCertificates(config.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	result.ClientCAs = x509.NewCertPool()
+	for _, ca := range clientCAs {
+		result.ClientCAs.AddCert(ca)
+	}
+	switch config.ClientAuth {
+	case "", clientAuthOptional:
+	case clientAuthRequired:
+		result.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("Unknown client_auth '%s', expected %s or %s", config.ClientAuth, clientAuthOptional, clientAuthRequired)
+	}
+	if config.CRLFile != "" {
+		crl, err := newRevocationList(config.CRLFile, clientCAs)
+		if err != nil {
+			return nil, err
+		}
+		result.VerifyPeerCertificate = crl.verifyPeerCertificate
+	}
+	return result, nil
+}
+
+//newEtcdTLSConfig builds TLS configuration of etcd clients
+func newEtcdTLSConfig(certFile, keyFile, caFile string, insecureSkipVerify bool) (*tls.Config, error) {
+	result := &tls.Config{InsecureSkipVerify: insecureSkipVerify}
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("etcd_client/cert_file and etcd_client/key_file must be set together")
+	}
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load etcd client certificate %s: %s", certFile, err)
+		}
+		result.Certificates = []tls.Certificate{certificate}
+	}
+	if caFile != "" {
+		cas, err := loadCertificates(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load etcd CA file %s: %s", caFile, err)
+		}
+		result.RootCAs = x509.NewCertPool()
+		for _, ca := range cas {
+			result.RootCAs.AddCert(ca)
+		}
+	}
+	return result, nil
+}
+
+func loadCertificates(file string) ([]*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	certificates := []*x509.Certificate{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("No certificates found in %s", file)
+	}
+	return certificates, nil
+}
+
+//revocationList checks client certificates against a CRL file
+//The file is reloaded when it changes. If the new file is invalid, the previous list is kept.
+type revocationList struct {
+	file    string
+	issuers []*x509.Certificate
+
+	mu       sync.Mutex
+	modTime  time.Time
+	issuer   *x509.Certificate
+	revoked  map[string]bool
+	nextTime time.Time
+}
+
+func newRevocationList(file string, issuers []*x509.Certificate) (*revocationList, error) {
+	crl := &revocationList{file: file, issuers: issuers}
+	if err := crl.reload(); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+func (crl *revocationList) reload() error {
+	info, err := os.Stat(crl.file)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(crl.modTime) {
+		return nil
+	}
+	data, err := ioutil.ReadFile(crl.file)
+	if err != nil {
+		return err
+	}
+	list, err := x509.ParseCRL(data)
+	if err != nil {
+		return fmt.Errorf("Invalid CRL %s: %s", crl.file, err)
+	}
+	issuer := crl.findIssuer(list)
+	if issuer == nil {
+		return fmt.Errorf("CRL %s isn't signed by any of client CAs", crl.file)
+	}
+	revoked := map[string]bool{}
+	for _, certificate := range list.TBSCertList.RevokedCertificates {
+		revoked[certificate.SerialNumber.String()] = true
+	}
+	crl.modTime = info.ModTime()
+	crl.issuer = issuer
+	crl.revoked = revoked
+	crl.nextTime = list.TBSCertList.NextUpdate
+	log.Info("Loaded CRL %s with %d revoked certificates", crl.file, len(revoked))
+	return nil
+}
+
+func (crl *revocationList) findIssuer(list *pkix.CertificateList) *x509.Certificate {
+	for _, issuer := range crl.issuers {
+		if issuer.CheckCRLSignature(list) == nil {
+			return issuer
+		}
+	}
+	return nil
+}
+
+//isRevoked checks if the certificate is revoked
+//Serial numbers are unique only per issuer, so certificates of other issuers are never revoked by the CRL.
+func (crl *revocationList) isRevoked(certificate *x509.Certificate) bool {
+	crl.mu.Lock()
+	defer crl.mu.Unlock()
+	if err := crl.reload(); err != nil {
+		log.Error("Failed to reload CRL, using the previous one: %s", err)
+	}
+	if !crl.nextTime.IsZero() && time.Now().After(crl.nextTime) {
+		log.Warning("CRL %s is outdated since %s", crl.file, crl.nextTime)
+	}
+	if !bytes.Equal(certificate.RawIssuer, crl.issuer.RawSubject) || certificate.CheckSignatureFrom(crl.issuer) != nil {
+		return false
+	}
+	return crl.revoked[certificate.SerialNumber.String()]
+}
+
+//verifyPeerCertificate rejects revoked client certificates
+//Only the leaf is checked, the CRL doesn't cover CAs of the chain.
+func (crl *revocationList) verifyPeerCertificate(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	if len(verifiedChains) == 0 || len(verifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := verifiedChains[0][0]
+	if crl.isRevoked(leaf) {
+		return fmt.Errorf("Client certificate %s is revoked", leaf.SerialNumber)
+	}
+	return nil
+}
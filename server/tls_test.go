@@ -0,0 +1,241 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{certificate: certificate, key: key}
+}
+
+func (ca *testCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client", Organization: []string{"tenant"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.certificate, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func (ca *testCA) writeCRL(t *testing.T, file string, modTime time.Time, serials ...int64) {
+	revoked := []pkix.RevokedCertificate{}
+	for _, serial := range serials {
+		revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := ca.certificate.CreateCRL(rand.Reader, ca.key, revoked, time.Now(), time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, der, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRevocationList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohan_crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "crl.der")
+
+	ca := newTestCA(t)
+	valid, revoked := ca.issue(t, 10), ca.issue(t, 11)
+	ca.writeCRL(t, file, time.Now().Add(-time.Minute), 11)
+
+	crl, err := newRevocationList(file, []*x509.Certificate{ca.certificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crl.isRevoked(valid) {
+		t.Error("Expected certificate 10 not to be revoked")
+	}
+	if err := crl.verifyPeerCertificate(nil, [][]*x509.Certificate{{revoked, ca.certificate}}); err == nil {
+		t.Error("Expected revoked certificate to be rejected")
+	}
+
+	ca.writeCRL(t, file, time.Now().Add(-30*time.Second), 1)
+	if err := crl.verifyPeerCertificate(nil, [][]*x509.Certificate{{valid, ca.certificate}}); err != nil {
+		t.Errorf("Expected the CA with a revoked serial not to reject its chain: %s", err)
+	}
+	if crl.isRevoked(newTestCA(t).issue(t, 1)) {
+		t.Error("Expected certificate of another issuer not to be revoked")
+	}
+
+	ca.writeCRL(t, file, time.Now(), 10)
+	if !crl.isRevoked(valid) || crl.isRevoked(revoked) {
+		t.Error("Expected changed CRL to be reloaded")
+	}
+
+	if err := ioutil.WriteFile(file, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if !crl.isRevoked(valid) {
+		t.Error("Expected previous CRL to be kept when the new one is invalid")
+	}
+
+	other := newTestCA(t)
+	other.writeCRL(t, file, time.Now(), 10)
+	if _, err := newRevocationList(file, []*x509.Certificate{ca.certificate}); err == nil {
+		t.Error("Expected CRL of unknown issuer to be rejected")
+	}
+}
+
+func TestCertificateIdentity(t *testing.T) {
+	if _, err := NewCertificateIdentity(CertificateIdentityConfig{TenantIDField: "subject.nickname"}, nil); err == nil {
+		t.Error("Expected unknown field to be rejected")
+	}
+	if _, err := NewCertificateIdentity(CertificateIdentityConfig{RolesField: "san.dns"}, nil); err == nil {
+		t.Error("Expected missing tenant ID field to be rejected")
+	}
+
+	identity, err := NewCertificateIdentity(CertificateIdentityConfig{
+		TenantIDField:   "san.uri",
+		TenantNameField: "subject.organization",
+		RolesField:      "subject.organizational_unit",
+		Roles:           []string{"Member"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenantURI, _ := url.Parse("urn:tenant:red")
+	certificate := &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:         "client",
+			Organization:       []string{"red"},
+			OrganizationalUnit: []string{"netadmin", "auditor"},
+		},
+		URIs: []*url.URL{tenantURI},
+	}
+	auth, err := identity.VerifyCertificate(certificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth.TenantID() != "urn:tenant:red" || auth.TenantName() != "red" {
+		t.Errorf("Unexpected tenant %s (%s)", auth.TenantID(), auth.TenantName())
+	}
+	roles := []string{}
+	for _, role := range auth.Roles() {
+		roles = append(roles, role.Name)
+	}
+	if !reflect.DeepEqual(roles, []string{"Member", "netadmin", "auditor"}) {
+		t.Errorf("Unexpected roles %v", roles)
+	}
+
+	certificate.URIs = nil
+	if _, err := identity.VerifyCertificate(certificate); err == nil {
+		t.Error("Expected certificate without tenant to be rejected")
+	}
+	if _, err := identity.VerifyToken("token"); err == nil {
+		t.Error("Expected tokens to be rejected without fallback identity")
+	}
+}
+
+func TestEtcdTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohan_etcd_tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := newTestCA(t)
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.certificate.Raw})
+	if err := ioutil.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := newEtcdTLSConfig("", "", caFile, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if config.RootCAs == nil || config.InsecureSkipVerify || len(config.Certificates) != 0 {
+		t.Errorf("unexpected config: %+v", config)
+	}
+	if _, err := ca.issue(t, 2).Verify(x509.VerifyOptions{
+		Roots:     config.RootCAs,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		t.Errorf("certificate issued by CA should be verified: %s", err)
+	}
+
+	if _, err := newEtcdTLSConfig(caFile, "", "", false); err == nil {
+		t.Errorf("expected error for certificate without key")
+	}
+	if _, err := newEtcdTLSConfig(caFile, caFile, "", false); err == nil {
+		t.Errorf("expected error for invalid key")
+	}
+	if _, err := newEtcdTLSConfig("", "", filepath.Join(dir, "missing.pem"), false); err == nil {
+		t.Errorf("expected error for missing CA file")
+	}
+}
@@ -57,6 +57,13 @@ func syncTransactionWrap(tx transaction.Transaction) *transactionEventLogger {
 	return &transactionEventLogger{tx, false}
 }
 
+//syncOptions returns sync_plain and sync_property metadata of the schema
+func syncOptions(s *schema.Schema) (syncPlain bool, syncProperty string) {
+	syncPlain, _ = s.Metadata["sync_plain"].(bool)
+	syncProperty, _ = s.Metadata["sync_property"].(string)
+	return
+}
+
 func (tl *transactionEventLogger) logEvent(eventType string, resource *schema.Resource, version int64) error {
 	schemaManager := schema.GetManager()
 	eventSchema, ok := schemaManager.Schema("event")
@@ -71,23 +78,7 @@ func (tl *transactionEventLogger) logEvent(eventType string, resource *schema.Re
 
 	body, err := resource.JSONString()
 
-	syncPlain := false
-	syncPlainRaw, ok := resource.Schema().Metadata["sync_plain"]
-	if ok {
-		syncPlainBool, ok := syncPlainRaw.(bool)
-		if ok {
-			syncPlain = syncPlainBool
-		}
-	}
-
-	syncProperty := ""
-	syncPropertyRaw, ok := resource.Schema().Metadata["sync_property"]
-	if ok {
-		syncPropertyStr, ok := syncPropertyRaw.(string)
-		if ok {
-			syncProperty = syncPropertyStr
-		}
-	}
+	syncPlain, syncProperty := syncOptions(resource.Schema())
 
 	if err != nil {
 		return fmt.Errorf("Error during event resource deserialisation: %s", err.Error())
@@ -148,6 +139,24 @@ func (tl *transactionEventLogger) Delete(s *schema.Schema, resourceID interface{
 	return tl.logEvent("delete", resource, configVersion)
 }
 
+//DeleteFilter deletes matching resources at once when the schema isn't synced,
+//otherwise one by one so that each deletion is logged
+func (tl *transactionEventLogger) DeleteFilter(s *schema.Schema, filter transaction.Filter) error {
+	if s.Metadata["nosync"] == true {
+		return tl.Transaction.DeleteFilter(s, filter)
+	}
+	resources, _, err := tl.List(s, filter, nil)
+	if err != nil {
+		return err
+	}
+	for _, resource := range resources {
+		if err := tl.Delete(s, resource.ID()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (tl *transactionEventLogger) Commit() error {
 	err := tl.Transaction.Commit()
 	if err != nil {
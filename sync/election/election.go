@@ -0,0 +1,329 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"errors"
+	"path"
+	syn "sync"
+	"time"
+
+	"github.com/cloudwan/gohan/sync"
+)
+
+const (
+	defaultRetryInterval = 5 * time.Second
+	defaultCheckInterval = time.Second
+)
+
+//Errors of campaigns
+var (
+	ErrClosed  = errors.New("election is closed")
+	ErrStopped = errors.New("campaign is stopped")
+)
+
+var (
+	registryMu syn.Mutex
+	registry   = map[sync.Sync]map[string]*Election{}
+)
+
+//Election elects one leader among processes sharing a sync backend
+//Leadership is the lock of the key, so it is backed by a lease with etcdv3
+//and by a key with TTL with etcd v2. Leadership is lost when the lock can't be kept alive.
+type Election struct {
+	backend sync.Sync
+	key     string
+	//RetryInterval is the time between campaigns while another process leads
+	RetryInterval time.Duration
+	//CheckInterval is the time between checks if the lock is still held
+	CheckInterval time.Duration
+
+	mu        syn.Mutex
+	term      *term
+	onElected []func()
+	onLost    []func()
+	closed    chan struct{}
+	closeOnce syn.Once
+}
+
+//term is a period of leadership, ended is closed when it ends
+type term struct {
+	ended chan struct{}
+}
+
+//New creates an election of key
+func New(backend sync.Sync, key string) *Election {
+	return &Election{
+		backend:       backend,
+		key:           key,
+		RetryInterval: defaultRetryInterval,
+		CheckInterval: defaultCheckInterval,
+		closed:        make(chan struct{}),
+	}
+}
+
+//Get returns the election of key shared by all users of backend in this process
+func Get(backend sync.Sync, key string) *Election {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	elections, ok := registry[backend]
+	if !ok {
+		elections = map[string]*Election{}
+		registry[backend] = elections
+	}
+	e, ok := elections[key]
+	if !ok {
+		e = New(backend, key)
+		elections[key] = e
+	}
+	return e
+}
+
+//Key returns the key of the election
+func (e *Election) Key() string {
+	return e.key
+}
+
+//OnElected adds callback called when this process becomes the leader
+func (e *Election) OnElected(callback func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onElected = append(e.onElected, callback)
+}
+
+//OnLost adds callback called when leadership of this process ends
+//It is called when the lock is lost, on Resign and on Close.
+func (e *Election) OnLost(callback func()) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onLost = append(e.onLost, callback)
+}
+
+//Campaign blocks until this process becomes the leader, something is sent to stopChan
+//or the election is closed. stopChan may be nil.
+func (e *Election) Campaign(stopChan chan bool) error {
+	for {
+		err := e.TryCampaign()
+		if err == nil || err == ErrClosed {
+			return err
+		}
+		select {
+		case <-e.closed:
+			return ErrClosed
+		case <-stopChan:
+			return ErrStopped
+		case <-time.After(e.RetryInterval):
+		}
+	}
+}
+
+//TryCampaign tries to become the leader once
+//It returns an error when another process leads.
+func (e *Election) TryCampaign() error {
+	select {
+	case <-e.closed:
+		return ErrClosed
+	default:
+	}
+	if err := e.backend.Lock(e.key, false); err != nil {
+		return err
+	}
+	e.elect()
+	return nil
+}
+
+//Resign ends leadership of this process
+func (e *Election) Resign() error {
+	if t := e.currentTerm(); t != nil {
+		e.end(t)
+	}
+	return e.backend.Unlock(e.key)
+}
+
+//IsLeader checks if this process is the leader
+func (e *Election) IsLeader() bool {
+	return e.currentTerm() != nil && e.backend.HasLock(e.key)
+}
+
+//Leader returns ID of the leading process, which is empty when nobody leads
+func (e *Election) Leader() string {
+	node, err := e.backend.Fetch(e.key)
+	if err != nil || node == nil {
+		return ""
+	}
+	return node.Value
+}
+
+//Observe sends ID of the leading process to responseChan, first the current one
+//and then each change, until something is sent to stopChan.
+func (e *Election) Observe(responseChan chan string, stopChan chan bool) error {
+	eventChan := make(chan *sync.Event)
+	watchStopChan := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		//watching the directory works with etcd v2, which can't watch a missing key
+		done <- e.backend.Watch(path.Dir(e.key), eventChan, watchStopChan, sync.RevisionCurrent)
+	}()
+	stop := func() error {
+		for {
+			select {
+			case watchStopChan <- true:
+			case <-eventChan:
+			case err := <-done:
+				return err
+			}
+		}
+	}
+	send := func(leader string) bool {
+		select {
+		case responseChan <- leader:
+			return true
+		case <-stopChan:
+			return false
+		}
+	}
+
+	leader := e.Leader()
+	if !send(leader) {
+		return stop()
+	}
+	for {
+		select {
+		case event := <-eventChan:
+			if event.Key != e.key {
+				continue
+			}
+			current := e.Leader()
+			if current == leader {
+				continue
+			}
+			leader = current
+			if !send(leader) {
+				return stop()
+			}
+		case err := <-done:
+			return err
+		case <-stopChan:
+			return stop()
+		}
+	}
+}
+
+//Run campaigns until the election is closed and calls lead while this process leads
+//true is sent to stopChan when leadership ends, lead should return then.
+//When lead returns while still leading, it is called again after RetryInterval.
+func (e *Election) Run(lead func(stopChan chan bool)) {
+	for e.Campaign(nil) == nil {
+		t := e.currentTerm()
+		if t == nil {
+			continue
+		}
+		stopChan := make(chan bool)
+		leadDone := make(chan struct{})
+		go func() {
+			select {
+			case <-t.ended:
+				select {
+				case stopChan <- true:
+				case <-leadDone:
+				}
+			case <-leadDone:
+			}
+		}()
+		lead(stopChan)
+		close(leadDone)
+		select {
+		case <-t.ended:
+		case <-e.closed:
+		case <-time.After(e.RetryInterval):
+		}
+	}
+}
+
+//Close resigns and stops campaigns of the election
+//A closed election is removed from the registry, so Get creates a new one.
+func (e *Election) Close() {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+		registryMu.Lock()
+		if elections := registry[e.backend]; elections[e.key] == e {
+			delete(elections, e.key)
+			if len(elections) == 0 {
+				delete(registry, e.backend)
+			}
+		}
+		registryMu.Unlock()
+	})
+	e.Resign()
+}
+
+func (e *Election) currentTerm() *term {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.term
+}
+
+func (e *Election) elect() {
+	e.mu.Lock()
+	if e.term != nil {
+		e.mu.Unlock()
+		return
+	}
+	t := &term{ended: make(chan struct{})}
+	e.term = t
+	callbacks := append([]func(){}, e.onElected...)
+	e.mu.Unlock()
+
+	log.Info("Elected leader of %s", e.key)
+	go e.monitor(t)
+	for _, callback := range callbacks {
+		callback()
+	}
+}
+
+//monitor ends the term when the lock isn't held anymore
+func (e *Election) monitor(t *term) {
+	ticker := time.NewTicker(e.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.ended:
+			return
+		case <-ticker.C:
+			if !e.backend.HasLock(e.key) {
+				log.Warning("Lost leadership of %s", e.key)
+				e.end(t)
+				return
+			}
+		}
+	}
+}
+
+func (e *Election) end(t *term) {
+	e.mu.Lock()
+	if e.term != t {
+		e.mu.Unlock()
+		return
+	}
+	e.term = nil
+	callbacks := append([]func(){}, e.onLost...)
+	e.mu.Unlock()
+
+	close(t.ended)
+	for _, callback := range callbacks {
+		callback()
+	}
+}
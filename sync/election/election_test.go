@@ -0,0 +1,205 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package election
+
+import (
+	"testing"
+	"time"
+
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+const key = "/gohan/cluster/lock/test"
+
+func newElections(t *testing.T) (*memory.Sync, *Election, *memory.Sync, *Election) {
+	first, err := memory.NewSync("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	second := first.NewSession()
+	elections := []*Election{New(first, key), New(second, key)}
+	for _, election := range elections {
+		election.RetryInterval = 10 * time.Millisecond
+		election.CheckInterval = 10 * time.Millisecond
+	}
+	return first, elections[0], second, elections[1]
+}
+
+func waitFor(t *testing.T, condition func() bool, message string) {
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCampaignAndResign(t *testing.T) {
+	first, firstElection, second, secondElection := newElections(t)
+	defer first.Close()
+	defer second.Close()
+
+	if err := firstElection.Campaign(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !firstElection.IsLeader() {
+		t.Errorf("expected to lead after campaign")
+	}
+	if err := secondElection.TryCampaign(); err == nil {
+		t.Errorf("expected campaign to fail while another process leads")
+	}
+
+	elected := make(chan struct{})
+	secondElection.OnElected(func() { close(elected) })
+	go secondElection.Campaign(nil)
+	if err := firstElection.Resign(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case <-elected:
+	case <-time.After(time.Second):
+		t.Fatal("second process wasn't elected after resign")
+	}
+	if firstElection.IsLeader() || !secondElection.IsLeader() {
+		t.Errorf("unexpected leaders")
+	}
+}
+
+func TestGetIsShared(t *testing.T) {
+	backend, err := memory.NewSync("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer backend.Close()
+
+	election := Get(backend, key)
+	if Get(backend, key) != election {
+		t.Errorf("expected the same election of the backend")
+	}
+	if Get(backend.NewSession(), key) == election {
+		t.Errorf("expected another election for another backend")
+	}
+	election.Close()
+	if Get(backend, key) == election {
+		t.Errorf("expected a new election after close")
+	}
+}
+
+func TestOnLost(t *testing.T) {
+	first, firstElection, second, _ := newElections(t)
+	defer second.Close()
+
+	lost := make(chan struct{})
+	firstElection.OnLost(func() { close(lost) })
+	if err := firstElection.Campaign(nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	//closing the session releases its locks like an expired lease
+	first.Close()
+	select {
+	case <-lost:
+	case <-time.After(time.Second):
+		t.Fatal("lost leadership wasn't detected")
+	}
+	if firstElection.IsLeader() {
+		t.Errorf("unexpected leader")
+	}
+}
+
+func TestObserve(t *testing.T) {
+	first, firstElection, second, secondElection := newElections(t)
+	defer first.Close()
+	defer second.Close()
+
+	responseChan := make(chan string)
+	stopChan := make(chan bool)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- secondElection.Observe(responseChan, stopChan)
+	}()
+	receive := func() string {
+		select {
+		case leader := <-responseChan:
+			return leader
+		case <-time.After(time.Second):
+			t.Fatal("no leader received")
+		}
+		return ""
+	}
+
+	if leader := receive(); leader != "" {
+		t.Errorf("unexpected leader: %s", leader)
+	}
+	firstElection.Campaign(nil)
+	if leader := receive(); leader == "" || leader != secondElection.Leader() {
+		t.Errorf("unexpected leader: %s", leader)
+	}
+	firstElection.Resign()
+	if leader := receive(); leader != "" {
+		t.Errorf("unexpected leader: %s", leader)
+	}
+
+	stopChan <- true
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("observe didn't stop")
+	}
+}
+
+func TestRun(t *testing.T) {
+	first, firstElection, second, secondElection := newElections(t)
+	defer first.Close()
+	defer second.Close()
+
+	leading := make(chan string, 2)
+	lead := func(name string) func(chan bool) {
+		return func(stopChan chan bool) {
+			leading <- name
+			<-stopChan
+		}
+	}
+	firstDone := make(chan struct{})
+	go func() {
+		firstElection.Run(lead("first"))
+		close(firstDone)
+	}()
+	if name := <-leading; name != "first" {
+		t.Fatalf("unexpected leader: %s", name)
+	}
+	go secondElection.Run(lead("second"))
+	defer secondElection.Close()
+
+	firstElection.Close()
+	select {
+	case <-firstDone:
+	case <-time.After(time.Second):
+		t.Fatal("run didn't return after close")
+	}
+	select {
+	case name := <-leading:
+		if name != "second" {
+			t.Errorf("unexpected leader: %s", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("second process didn't take over")
+	}
+	waitFor(t, secondElection.IsLeader, "second process isn't leading")
+}
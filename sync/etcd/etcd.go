@@ -16,9 +16,15 @@
 package etcd
 
 import (
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
 	"os"
+	syn "sync"
 	"time"
 
 	"github.com/cloudwan/gohan/sync"
@@ -29,11 +35,15 @@ import (
 
 const masterTTL = 10
 
+//keyNotFound is the etcd error code of missing keys
+const keyNotFound = 100
+
 //Sync is struct for etcd based sync
 type Sync struct {
 	locks      cmap.ConcurrentMap
 	etcdClient *etcd.Client
 	processID  string
+	txnMutex   syn.Mutex
 }
 
 //NewSync initialize new etcd sync
@@ -45,6 +55,66 @@ func NewSync(etcdServers []string) *Sync {
 	return sync
 }
 
+//Config is connection configuration of etcd
+type Config struct {
+	Endpoints []string
+	//DialTimeout is the timeout of establishing connections, one second by default
+	DialTimeout time.Duration
+	//KeepAlive is the period of TCP keepalive probes, one second by default
+	KeepAlive time.Duration
+	//TLS is used for https endpoints, server certificates are verified against the system CAs when it is nil
+	//Verification is skipped only with InsecureSkipVerify of the TLS config.
+	TLS      *tls.Config
+	Username string
+	Password string
+}
+
+//NewSyncWithConfig initialize new etcd sync with TLS, authentication and dial options
+//Credentials are sent with basic authentication, so they should be used with https endpoints.
+func NewSyncWithConfig(config *Config) (*Sync, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints configured")
+	}
+	endpoints := config.Endpoints
+	if config.Username != "" {
+		var err error
+		endpoints, err = withCredentials(endpoints, config.Username, config.Password)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sync := NewSync(endpoints)
+	dialTimeout := time.Second
+	if config.DialTimeout > 0 {
+		dialTimeout = config.DialTimeout
+	}
+	keepAlive := time.Second
+	if config.KeepAlive > 0 {
+		keepAlive = config.KeepAlive
+	}
+	sync.etcdClient.SetDialTimeout(dialTimeout)
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: keepAlive}
+	sync.etcdClient.SetTransport(&http.Transport{
+		Dial:            dialer.Dial,
+		TLSClientConfig: config.TLS,
+	})
+	return sync, nil
+}
+
+//withCredentials adds user info to endpoint URLs, which the HTTP client sends as basic authentication
+func withCredentials(endpoints []string, username, password string) ([]string, error) {
+	result := make([]string, len(endpoints))
+	for i, endpoint := range endpoints {
+		endpointURL, err := url.Parse(endpoint)
+		if err != nil || endpointURL.Host == "" {
+			return nil, fmt.Errorf("invalid etcd endpoint %q", endpoint)
+		}
+		endpointURL.User = url.UserPassword(username, password)
+		result[i] = endpointURL.String()
+	}
+	return result, nil
+}
+
 //Update sync update sync
 func (s *Sync) Update(key, jsonString string) error {
 	var err error
@@ -66,6 +136,39 @@ func (s *Sync) Delete(key string) error {
 	return nil
 }
 
+//Txn emulates a transaction because etcd v2 doesn't support them
+//Transactions of this process are serialized, but they aren't atomic
+//against other clients.
+func (s *Sync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	s.txnMutex.Lock()
+	defer s.txnMutex.Unlock()
+	for _, condition := range conditions {
+		var node *sync.Node
+		resp, err := s.etcdClient.Get(condition.Key, false, false)
+		if err == nil {
+			node = &sync.Node{Key: resp.Node.Key, Value: resp.Node.Value, Revision: int64(resp.Node.ModifiedIndex)}
+		} else if etcdErr, ok := err.(*etcd.EtcdError); !ok || etcdErr.ErrorCode != keyNotFound {
+			return false, err
+		}
+		if !condition.Check(node) {
+			return false, nil
+		}
+	}
+	for _, op := range ops {
+		var err error
+		switch op.Action {
+		case sync.OpUpdate:
+			err = s.Update(op.Key, op.Value)
+		case sync.OpDelete:
+			err = s.Delete(op.Key)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
 //Fetch data from sync
 func (s *Sync) Fetch(key string) (*sync.Node, error) {
 	resp, err := s.etcdClient.Get(key, true, true)
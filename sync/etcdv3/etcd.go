@@ -16,6 +16,7 @@
 package etcdv3
 
 import (
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -47,16 +48,49 @@ func (s *Sync) withTimeout() context.Context {
 	return ctx
 }
 
+//Config is connection configuration of etcd
+type Config struct {
+	Endpoints []string
+	//DialTimeout is the timeout of establishing connections
+	DialTimeout time.Duration
+	//RequestTimeout is the timeout of each request, one second by default
+	RequestTimeout time.Duration
+	//TLS is used for https endpoints
+	TLS *tls.Config
+	//Username and Password enable authentication when both are set
+	Username string
+	Password string
+}
+
 //NewSync initialize new etcd sync
 func NewSync(etcdServers []string, timeout time.Duration) (*Sync, error) {
+	return NewSyncWithConfig(&Config{
+		Endpoints:      etcdServers,
+		DialTimeout:    timeout,
+		RequestTimeout: timeout,
+	})
+}
+
+//NewSyncWithConfig initialize new etcd sync with TLS, authentication and dial options
+func NewSyncWithConfig(config *Config) (*Sync, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, errors.New("no etcd endpoints configured")
+	}
+	timeout := config.RequestTimeout
+	if timeout <= 0 {
+		timeout = time.Second
+	}
 	sync := &Sync{
 		locks:   cmap.New(),
 		timeout: timeout,
 	}
 	client, err := etcd.New(
 		etcd.Config{
-			Endpoints:   etcdServers,
-			DialTimeout: timeout,
+			Endpoints:   config.Endpoints,
+			DialTimeout: config.DialTimeout,
+			TLS:         config.TLS,
+			Username:    config.Username,
+			Password:    config.Password,
 		},
 	)
 	if err != nil {
@@ -92,6 +126,45 @@ func (s *Sync) Delete(key string) error {
 	return err
 }
 
+//Txn applies ops in an etcd transaction when all conditions hold
+func (s *Sync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	cmps := make([]etcd.Cmp, 0, len(conditions))
+	for _, condition := range conditions {
+		operator := condition.Operator
+		switch operator {
+		case "=", "!=", "<", ">":
+		case "":
+			operator = "="
+		default:
+			return false, fmt.Errorf("unknown operator %s of condition on %s", operator, condition.Key)
+		}
+		if condition.Target == sync.TargetRevision {
+			cmps = append(cmps, etcd.Compare(etcd.ModRevision(condition.Key), operator, condition.Revision))
+		} else {
+			cmps = append(cmps, etcd.Compare(etcd.Value(condition.Key), operator, condition.Value))
+		}
+	}
+	etcdOps := make([]etcd.Op, 0, len(ops))
+	for _, op := range ops {
+		switch op.Action {
+		case sync.OpUpdate:
+			if op.Value == "" {
+				// do nothing, because clientv3 doesn't have directories
+				continue
+			}
+			etcdOps = append(etcdOps, etcd.OpPut(op.Key, op.Value))
+		case sync.OpDelete:
+			etcdOps = append(etcdOps, etcd.OpDelete(op.Key))
+		}
+	}
+	resp, err := s.etcdClient.Txn(s.withTimeout()).If(cmps...).Then(etcdOps...).Commit()
+	if err != nil {
+		log.Error(fmt.Sprintf("failed to sync with backend %s", err))
+		return false, err
+	}
+	return resp.Succeeded, nil
+}
+
 //Fetch data from sync
 func (s *Sync) Fetch(key string) (*sync.Node, error) {
 	node, err := s.etcdClient.Get(s.withTimeout(), key, etcd.WithSort(etcd.SortByKey, etcd.SortAscend))
@@ -0,0 +1,485 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	syn "sync"
+
+	"github.com/cloudwan/gohan/sync"
+	"github.com/twinj/uuid"
+)
+
+//historySize is the number of events kept for resuming watches
+const historySize = 1000
+
+type entry struct {
+	Value    string `json:"value"`
+	Revision int64  `json:"revision"`
+	lock     bool
+}
+
+type snapshot struct {
+	Revision int64             `json:"revision"`
+	Entries  map[string]*entry `json:"entries"`
+}
+
+//store is a key value store shared by sessions
+type store struct {
+	mu       syn.Mutex
+	changed  *syn.Cond
+	file     string
+	revision int64
+	entries  map[string]*entry
+	history  []*record
+	watchers map[*watcher]bool
+}
+
+//record is a change of a value, converted to an event when it is sent to a watcher
+type record struct {
+	action   string
+	key      string
+	value    string
+	revision int64
+}
+
+//Sync is an in-process sync backend for single node deployments and tests
+//Values are kept in memory and optionally persisted to a file.
+//Locks are owned by sessions, see NewSession.
+type Sync struct {
+	store     *store
+	processID string
+	locks     map[string]bool
+	watchers  map[*watcher]bool
+	closed    bool
+}
+
+type watcher struct {
+	path   string
+	queue  []*record
+	notify chan struct{}
+	closed bool
+}
+
+//NewSync creates an in-memory sync
+//When file isn't empty, values are loaded from and persisted to the file.
+func NewSync(file string) (*Sync, error) {
+	store := &store{
+		file:     file,
+		entries:  map[string]*entry{},
+		watchers: map[*watcher]bool{},
+	}
+	store.changed = syn.NewCond(&store.mu)
+	if file != "" {
+		if err := store.load(); err != nil {
+			return nil, err
+		}
+	}
+	return newSession(store), nil
+}
+
+func newSession(store *store) *Sync {
+	return &Sync{
+		store:     store,
+		processID: uuid.NewV4().String(),
+		locks:     map[string]bool{},
+		watchers:  map[*watcher]bool{},
+	}
+}
+
+//NewSession creates another client of the same store
+//Sessions share values but own locks separately, like processes sharing etcd.
+func (s *Sync) NewSession() *Sync {
+	return newSession(s.store)
+}
+
+func (st *store) load() error {
+	data, err := ioutil.ReadFile(st.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	loaded := &snapshot{}
+	if err := json.Unmarshal(data, loaded); err != nil {
+		return fmt.Errorf("Invalid sync file %s: %s", st.file, err)
+	}
+	st.revision = loaded.Revision
+	if loaded.Entries != nil {
+		st.entries = loaded.Entries
+	}
+	return nil
+}
+
+//persist writes values and the revision to the file, locks aren't persisted
+func (st *store) persist() error {
+	if st.file == "" {
+		return nil
+	}
+	entries := map[string]*entry{}
+	for key, e := range st.entries {
+		if !e.lock {
+			entries[key] = &entry{Value: e.Value, Revision: e.Revision}
+		}
+	}
+	data, err := json.Marshal(&snapshot{Revision: st.revision, Entries: entries})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(st.file), filepath.Base(st.file))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), st.file)
+}
+
+//put sets the value and notifies watchers, the caller holds the store lock
+func (st *store) put(key, value string, lock bool) error {
+	st.revision++
+	st.set(key, value, lock, st.revision)
+	return st.persist()
+}
+
+//remove deletes the value and notifies watchers, the caller holds the store lock
+func (st *store) remove(key string) error {
+	if _, ok := st.entries[key]; !ok {
+		return nil
+	}
+	st.revision++
+	st.unset(key, st.revision)
+	return st.persist()
+}
+
+func (st *store) set(key, value string, lock bool, revision int64) {
+	st.entries[key] = &entry{Value: value, Revision: revision, lock: lock}
+	if lock {
+		value = ""
+	}
+	st.publish(&record{action: "set", key: key, value: value, revision: revision})
+}
+
+func (st *store) unset(key string, revision int64) {
+	delete(st.entries, key)
+	st.publish(&record{action: "delete", key: key, revision: revision})
+}
+
+func (st *store) publish(r *record) {
+	st.history = append(st.history, r)
+	if len(st.history) > historySize {
+		st.history = st.history[len(st.history)-historySize:]
+	}
+	for w := range st.watchers {
+		if w.matches(r.key) {
+			w.push(r)
+		}
+	}
+	st.changed.Broadcast()
+}
+
+func (r *record) event() *sync.Event {
+	event := &sync.Event{Action: r.action, Key: r.key, Revision: r.revision}
+	if r.value != "" {
+		if err := json.Unmarshal([]byte(r.value), &event.Data); err != nil {
+			log.Warning("failed to unmarshal watch response: %s", err)
+			return nil
+		}
+	}
+	return event
+}
+
+func isUnder(key, path string) bool {
+	return key == path || strings.HasPrefix(key, childPrefix(path))
+}
+
+//childPrefix returns the prefix of keys under path, "/" is the root of every key
+func childPrefix(path string) string {
+	if strings.HasSuffix(path, "/") {
+		return path
+	}
+	return path + "/"
+}
+
+//Update sync update sync
+//When jsonString is empty, this method do nothing because
+//directories are implicit, like in etcd v3.
+func (s *Sync) Update(key, jsonString string) error {
+	if jsonString == "" {
+		return nil
+	}
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.store.put(key, jsonString, false)
+}
+
+//Delete sync update sync
+func (s *Sync) Delete(key string) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.store.remove(key)
+}
+
+//Txn applies ops atomically when all conditions hold
+func (s *Sync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	for _, condition := range conditions {
+		var node *sync.Node
+		if e, ok := s.store.entries[condition.Key]; ok {
+			node = &sync.Node{Key: condition.Key, Value: e.Value, Revision: e.Revision}
+		}
+		if !condition.Check(node) {
+			return false, nil
+		}
+	}
+	//all changes of a transaction share a revision, like in etcd v3
+	revision := s.store.revision + 1
+	changed := false
+	for _, op := range ops {
+		switch op.Action {
+		case sync.OpUpdate:
+			if op.Value != "" {
+				s.store.set(op.Key, op.Value, false, revision)
+				changed = true
+			}
+		case sync.OpDelete:
+			if _, ok := s.store.entries[op.Key]; ok {
+				s.store.unset(op.Key, revision)
+				changed = true
+			}
+		}
+	}
+	if !changed {
+		return true, nil
+	}
+	s.store.revision = revision
+	return true, s.store.persist()
+}
+
+//Fetch data from sync
+func (s *Sync) Fetch(key string) (*sync.Node, error) {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+
+	prefix := childPrefix(key)
+	keys := []string{}
+	for k := range s.store.entries {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	root, ok := s.store.entries[key]
+	if !ok && len(keys) == 0 {
+		return nil, errors.New("Not found")
+	}
+	sort.Strings(keys)
+
+	rootNode := &sync.Node{Key: key}
+	if ok {
+		rootNode.Value = root.Value
+		rootNode.Revision = root.Revision
+	}
+	nodes := map[string]*sync.Node{key: rootNode}
+	for _, k := range keys {
+		parent := rootNode
+		steps := strings.Split(strings.TrimPrefix(k, prefix), "/")
+		for i := range steps {
+			path := prefix + strings.Join(steps[:i+1], "/")
+			node, ok := nodes[path]
+			if !ok {
+				node = &sync.Node{Key: path}
+				nodes[path] = node
+				parent.Children = append(parent.Children, node)
+			}
+			parent = node
+		}
+		e := s.store.entries[k]
+		parent.Value = e.Value
+		parent.Revision = e.Revision
+	}
+	return rootNode, nil
+}
+
+//HasLock checks current session owns lock or not
+func (s *Sync) HasLock(path string) bool {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.locks[path]
+}
+
+//Lock locks resources on sync
+//This call blocks until you can get lock when block is true
+func (s *Sync) Lock(path string, block bool) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	for {
+		if s.closed {
+			return errors.New("sync is closed")
+		}
+		if s.locks[path] {
+			return nil
+		}
+		if _, locked := s.store.entries[path]; !locked {
+			break
+		}
+		if !block {
+			msg := fmt.Sprintf("failed to lock path %s", path)
+			log.Notice(msg)
+			return errors.New(msg)
+		}
+		s.store.changed.Wait()
+	}
+	s.locks[path] = true
+	log.Info("Locked %s", path)
+	return s.store.put(path, s.processID, true)
+}
+
+//Unlock path
+func (s *Sync) Unlock(path string) error {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	return s.unlock(path)
+}
+
+func (s *Sync) unlock(path string) error {
+	if !s.locks[path] {
+		return nil
+	}
+	delete(s.locks, path)
+	log.Info("Unlocked path %s", path)
+	return s.store.remove(path)
+}
+
+func (w *watcher) matches(key string) bool {
+	return isUnder(key, w.path)
+}
+
+func (w *watcher) push(r *record) {
+	w.queue = append(w.queue, r)
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+//Watch keep watch update under the path
+//Events after the revision are replayed when they are still kept in history,
+//otherwise current values modified after the revision are sent as get events.
+func (s *Sync) Watch(path string, responseChan chan *sync.Event, stopChan chan bool, revision int64) error {
+	w := &watcher{path: path, notify: make(chan struct{}, 1)}
+
+	s.store.mu.Lock()
+	if s.closed {
+		s.store.mu.Unlock()
+		return errors.New("sync is closed")
+	}
+	history := s.store.history
+	if revision != sync.RevisionCurrent && len(history) > 0 && history[0].revision <= revision+1 {
+		for _, r := range history {
+			if r.revision > revision && w.matches(r.key) {
+				w.push(r)
+			}
+		}
+	} else {
+		keys := []string{}
+		for key, e := range s.store.entries {
+			if isUnder(key, path) && (revision == sync.RevisionCurrent || e.Revision > revision) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return s.store.entries[keys[i]].Revision < s.store.entries[keys[j]].Revision
+		})
+		for _, key := range keys {
+			e := s.store.entries[key]
+			r := &record{action: "get", key: key, value: e.Value, revision: e.Revision}
+			if e.lock {
+				r.value = ""
+			}
+			w.push(r)
+		}
+	}
+	s.store.watchers[w] = true
+	s.watchers[w] = true
+	s.store.mu.Unlock()
+
+	defer func() {
+		s.store.mu.Lock()
+		delete(s.store.watchers, w)
+		delete(s.watchers, w)
+		s.store.mu.Unlock()
+	}()
+
+	for {
+		s.store.mu.Lock()
+		queue, closed := w.queue, w.closed
+		w.queue = nil
+		s.store.mu.Unlock()
+		if closed {
+			return errors.New("sync is closed")
+		}
+		for _, r := range queue {
+			event := r.event()
+			if event == nil {
+				continue
+			}
+			select {
+			case responseChan <- event:
+			case <-stopChan:
+				return nil
+			}
+		}
+		select {
+		case <-w.notify:
+		case <-stopChan:
+			return nil
+		}
+	}
+}
+
+//Close releases locks and stops watches of the session
+func (s *Sync) Close() {
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	for path := range s.locks {
+		s.unlock(path)
+	}
+	for w := range s.watchers {
+		w.closed = true
+		select {
+		case w.notify <- struct{}{}:
+		default:
+		}
+	}
+	s.closed = true
+	s.store.changed.Broadcast()
+}
@@ -0,0 +1,363 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memory
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	gohan_sync "github.com/cloudwan/gohan/sync"
+)
+
+func TestUpdateAndDelete(t *testing.T) {
+	sync := newSync(t)
+
+	path := "/path/to/somewhere"
+	data := `{"name": "blabla"}`
+	if err := sync.Update(path, data); err != nil {
+		t.Errorf("unexpected error")
+	}
+
+	node, err := sync.Fetch(path)
+	if err != nil {
+		t.Errorf("unexpected error")
+	}
+	if node.Key != path || node.Value != data || node.Revision != 1 || len(node.Children) != 0 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+
+	if err := sync.Delete(path); err != nil {
+		t.Errorf("unexpected error")
+	}
+	if _, err := sync.Fetch(path); err == nil {
+		t.Errorf("unexpected non error")
+	}
+}
+
+func TestRecursiveFetch(t *testing.T) {
+	sync := newSync(t)
+
+	base := "/path/to/somewhere"
+	items := map[string]string{
+		base:                 "",
+		base + "/inside":     "inside",
+		base + "/else/child": "child",
+		base + "invalid":     "should not be included",
+	}
+	for path, data := range items {
+		if err := sync.Update(path, data); err != nil {
+			t.Errorf("unexpected error")
+		}
+	}
+
+	node, err := sync.Fetch(base)
+	if err != nil {
+		t.Fatalf("unexpected error")
+	}
+	if node.Key != base || node.Value != "" || len(node.Children) != 2 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	if node.Children[0].Key != base+"/else" || node.Children[0].Value != "" || len(node.Children[0].Children) != 1 {
+		t.Errorf("unexpected node: %+v", node.Children[0])
+	}
+	if node.Children[0].Children[0].Key != base+"/else/child" || node.Children[0].Children[0].Value != "child" {
+		t.Errorf("unexpected node: %+v", node.Children[0].Children[0])
+	}
+	if node.Children[1].Key != base+"/inside" || node.Children[1].Value != "inside" || len(node.Children[1].Children) != 0 {
+		t.Errorf("unexpected node: %+v", node.Children[1])
+	}
+}
+
+func TestRootFetch(t *testing.T) {
+	sync := newSync(t)
+
+	sync.Update("/first", "first")
+	sync.Update("/second/child", "child")
+
+	node, err := sync.Fetch("/")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if node.Key != "/" || len(node.Children) != 2 {
+		t.Fatalf("unexpected node: %+v", node)
+	}
+	if node.Children[0].Key != "/first" || node.Children[0].Value != "first" {
+		t.Errorf("unexpected node: %+v", node.Children[0])
+	}
+	if node.Children[1].Key != "/second" || len(node.Children[1].Children) != 1 ||
+		node.Children[1].Children[0].Key != "/second/child" || node.Children[1].Children[0].Value != "child" {
+		t.Errorf("unexpected node: %+v", node.Children[1])
+	}
+}
+
+func TestLockUnblocking(t *testing.T) {
+	sync0 := newSync(t)
+	sync1 := sync0.NewSession()
+
+	path := "/path/lock"
+	if err := sync0.Lock(path, false); err != nil {
+		t.Errorf("unexpected error")
+	}
+	if err := sync0.Lock(path, false); err != nil {
+		t.Errorf("unexpected error for lock owner")
+	}
+	if err := sync1.Lock(path, false); err == nil {
+		t.Errorf("unexpected non error")
+	}
+	if !sync0.HasLock(path) || sync1.HasLock(path) {
+		t.Errorf("unexpected lock owner")
+	}
+
+	if err := sync0.Unlock(path); err != nil {
+		t.Errorf("unexpected error")
+	}
+	if err := sync1.Lock(path, false); err != nil {
+		t.Errorf("unexpected error")
+	}
+	if sync0.HasLock(path) || !sync1.HasLock(path) {
+		t.Errorf("unexpected lock owner")
+	}
+
+	sync1.Close()
+	if err := sync0.Lock(path, false); err != nil {
+		t.Errorf("expected lock to be released on close")
+	}
+}
+
+func TestLockBlocking(t *testing.T) {
+	sync0 := newSync(t)
+	sync1 := sync0.NewSession()
+
+	path := "/path/lock"
+	if err := sync0.Lock(path, true); err != nil {
+		t.Errorf("unexpected error")
+	}
+	locked1 := make(chan struct{})
+	go func() {
+		if err := sync1.Lock(path, true); err != nil {
+			t.Errorf("unexpected error")
+		}
+		close(locked1)
+	}()
+
+	time.Sleep(time.Millisecond * 100)
+	select {
+	case <-locked1:
+		t.Errorf("blocking failed")
+	default:
+	}
+
+	if err := sync0.Unlock(path); err != nil {
+		t.Errorf("unexpected error")
+	}
+	select {
+	case <-locked1:
+	case <-time.After(time.Second):
+		t.Fatalf("lock wasn't acquired after unlock")
+	}
+	if sync0.HasLock(path) || !sync1.HasLock(path) {
+		t.Errorf("unexpected lock owner")
+	}
+}
+
+func TestWatch(t *testing.T) {
+	sync := newSync(t)
+
+	path := "/path/to/watch"
+	responseChan := make(chan *gohan_sync.Event)
+	stopChan := make(chan bool)
+	stopped := make(chan error)
+
+	sync.Update(path+"/existing", `{"existing": true}`)
+	sync.Update("/path/to/other", `{"existing": true}`)
+
+	go func() {
+		stopped <- sync.Watch(path, responseChan, stopChan, gohan_sync.RevisionCurrent)
+	}()
+
+	resp := <-responseChan
+	if resp.Action != "get" || resp.Key != path+"/existing" || resp.Data["existing"].(bool) != true {
+		t.Errorf("mismatch response: %+v", resp)
+	}
+
+	sync.Update(path+"/new", `{"existing": false}`)
+	resp = <-responseChan
+	if resp.Action != "set" || resp.Key != path+"/new" || resp.Data["existing"].(bool) != false || resp.Revision != 3 {
+		t.Errorf("mismatch response: %+v", resp)
+	}
+
+	sync.Delete(path + "/existing")
+	resp = <-responseChan
+	if resp.Action != "delete" || resp.Key != path+"/existing" || len(resp.Data) != 0 {
+		t.Errorf("mismatch response: %+v", resp)
+	}
+
+	stopChan <- true
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestRootWatch(t *testing.T) {
+	sync := newSync(t)
+
+	responseChan := make(chan *gohan_sync.Event)
+	stopChan := make(chan bool)
+	stopped := make(chan error)
+
+	sync.Update("/existing", `{"existing": true}`)
+
+	go func() {
+		stopped <- sync.Watch("/", responseChan, stopChan, gohan_sync.RevisionCurrent)
+	}()
+
+	resp := <-responseChan
+	if resp.Action != "get" || resp.Key != "/existing" {
+		t.Errorf("mismatch response: %+v", resp)
+	}
+
+	sync.Update("/path/to/new", `{"existing": false}`)
+	resp = <-responseChan
+	if resp.Action != "set" || resp.Key != "/path/to/new" {
+		t.Errorf("mismatch response: %+v", resp)
+	}
+
+	stopChan <- true
+	if err := <-stopped; err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestWatchResume(t *testing.T) {
+	sync := newSync(t)
+
+	path := "/path/to/watch"
+	sync.Update(path+"/first", `{"n": 1}`)
+	sync.Update(path+"/second", `{"n": 2}`)
+	sync.Delete(path + "/first")
+
+	responseChan := make(chan *gohan_sync.Event)
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	go sync.Watch(path, responseChan, stopChan, 1)
+
+	for _, expected := range []struct {
+		action   string
+		key      string
+		revision int64
+	}{
+		{"set", path + "/second", 2},
+		{"delete", path + "/first", 3},
+	} {
+		resp := <-responseChan
+		if resp.Action != expected.action || resp.Key != expected.key || resp.Revision != expected.revision {
+			t.Errorf("mismatch response: %+v", resp)
+		}
+	}
+}
+
+func TestTxn(t *testing.T) {
+	sync := newSync(t)
+	sync.Update("/config/network", `{"name": "red"}`)
+
+	responseChan := make(chan *gohan_sync.Event, 10)
+	stopChan := make(chan bool)
+	defer close(stopChan)
+	go sync.Watch("/index", responseChan, stopChan, gohan_sync.RevisionCurrent)
+
+	succeeded, err := sync.Txn(
+		[]*gohan_sync.Condition{
+			{Key: "/config/network", Target: gohan_sync.TargetRevision, Operator: "=", Revision: 1},
+			{Key: "/index/red", Target: gohan_sync.TargetRevision, Operator: "=", Revision: 0},
+		},
+		[]*gohan_sync.Op{
+			{Action: gohan_sync.OpUpdate, Key: "/index/red", Value: `{"id": "network"}`},
+			{Action: gohan_sync.OpUpdate, Key: "/index/blue", Value: `{"id": "network"}`},
+		})
+	if err != nil || !succeeded {
+		t.Fatalf("unexpected result: %v %v", succeeded, err)
+	}
+	//the watch may start after the transaction, so keys come in any order
+	keys := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		resp := <-responseChan
+		if resp.Revision != 2 {
+			t.Errorf("mismatch response: %+v", resp)
+		}
+		keys[resp.Key] = true
+	}
+	if !keys["/index/red"] || !keys["/index/blue"] {
+		t.Errorf("unexpected keys: %v", keys)
+	}
+
+	succeeded, err = sync.Txn(
+		[]*gohan_sync.Condition{
+			{Key: "/index/red", Target: gohan_sync.TargetValue, Operator: "=", Value: `{"id": "network"}`},
+			{Key: "/index/green", Target: gohan_sync.TargetValue, Operator: "!=", Value: ""},
+		},
+		[]*gohan_sync.Op{{Action: gohan_sync.OpDelete, Key: "/index/red"}})
+	if err != nil || succeeded {
+		t.Errorf("expected value condition on missing key to fail: %v %v", succeeded, err)
+	}
+	if _, err := sync.Fetch("/index/red"); err != nil {
+		t.Errorf("expected failed transaction not to be applied")
+	}
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohan_sync")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "sync.json")
+
+	sync, err := NewSync(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sync.Update("/path/value", `{"n": 1}`)
+	sync.Lock("/path/lock", false)
+	sync.Close()
+
+	sync, err = NewSync(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node, err := sync.Fetch("/path")
+	if err != nil {
+		t.Fatalf("unexpected error")
+	}
+	if len(node.Children) != 1 || node.Children[0].Value != `{"n": 1}` || node.Children[0].Revision != 1 {
+		t.Errorf("unexpected node: %+v", node)
+	}
+	sync.Update("/path/value", `{"n": 2}`)
+	node, _ = sync.Fetch("/path/value")
+	if node.Revision != 4 {
+		t.Errorf("expected revision to continue, got %d", node.Revision)
+	}
+}
+
+func newSync(t *testing.T) *Sync {
+	sync, err := NewSync("")
+	if err != nil {
+		t.Fatalf("unexpected error")
+	}
+	return sync
+}
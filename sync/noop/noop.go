@@ -36,6 +36,11 @@ func (sync *Sync) Delete(path string) error {
 	return nil
 }
 
+//Txn sync update sync
+func (sync *Sync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	return true, nil
+}
+
 func (sync *Sync) Fetch(path string) (*sync.Node, error) {
 	return nil, nil
 }
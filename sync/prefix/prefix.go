@@ -0,0 +1,158 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefix
+
+import (
+	"strings"
+
+	"github.com/cloudwan/gohan/sync"
+)
+
+//Sync places all keys of a backend under a root prefix
+//Keys passed to and returned from it don't contain the prefix,
+//so deployments with different prefixes can share one backend.
+type Sync struct {
+	backend sync.Sync
+	prefix  string
+}
+
+//NewSync creates sync wrapping backend with prefix
+func NewSync(backend sync.Sync, prefix string) *Sync {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		prefix = ""
+	}
+	return &Sync{backend: backend, prefix: prefix}
+}
+
+//Prefix returns the normalized root prefix
+func (s *Sync) Prefix() string {
+	return s.prefix
+}
+
+func (s *Sync) key(path string) string {
+	if path == "/" || path == "" {
+		return s.prefix
+	}
+	return s.prefix + path
+}
+
+func (s *Sync) path(key string) string {
+	if key == s.prefix {
+		return "/"
+	}
+	return strings.TrimPrefix(key, s.prefix)
+}
+
+func (s *Sync) trimNode(node *sync.Node) {
+	if node == nil {
+		return
+	}
+	node.Key = s.path(node.Key)
+	for _, child := range node.Children {
+		s.trimNode(child)
+	}
+}
+
+//HasLock checks is current process has lock
+func (s *Sync) HasLock(path string) bool {
+	return s.backend.HasLock(s.key(path))
+}
+
+//Lock get lock for path
+func (s *Sync) Lock(path string, block bool) error {
+	return s.backend.Lock(s.key(path), block)
+}
+
+//Unlock unlocks paths
+func (s *Sync) Unlock(path string) error {
+	return s.backend.Unlock(s.key(path))
+}
+
+//Fetch data from sync
+func (s *Sync) Fetch(path string) (*sync.Node, error) {
+	node, err := s.backend.Fetch(s.key(path))
+	s.trimNode(node)
+	return node, err
+}
+
+//Update sync update sync
+func (s *Sync) Update(path, json string) error {
+	return s.backend.Update(s.key(path), json)
+}
+
+//Delete sync update sync
+func (s *Sync) Delete(path string) error {
+	return s.backend.Delete(s.key(path))
+}
+
+//Txn applies ops with prefixed keys atomically when all conditions hold
+func (s *Sync) Txn(conditions []*sync.Condition, ops []*sync.Op) (bool, error) {
+	prefixedConditions := make([]*sync.Condition, len(conditions))
+	for i, condition := range conditions {
+		prefixed := *condition
+		prefixed.Key = s.key(condition.Key)
+		prefixedConditions[i] = &prefixed
+	}
+	prefixedOps := make([]*sync.Op, len(ops))
+	for i, op := range ops {
+		prefixed := *op
+		prefixed.Key = s.key(op.Key)
+		prefixedOps[i] = &prefixed
+	}
+	return s.backend.Txn(prefixedConditions, prefixedOps)
+}
+
+//Watch keep watch update under the path
+//Events of the backend are forwarded with the prefix removed from keys.
+func (s *Sync) Watch(path string, responseChan chan *sync.Event, stopChan chan bool, revision int64) error {
+	eventChan := make(chan *sync.Event)
+	backendStopChan := make(chan bool)
+	done := make(chan error, 1)
+	go func() {
+		done <- s.backend.Watch(s.key(path), eventChan, backendStopChan, revision)
+	}()
+	stop := func() error {
+		for {
+			select {
+			case backendStopChan <- true:
+			case <-eventChan:
+			case err := <-done:
+				return err
+			}
+		}
+	}
+	for {
+		select {
+		case event := <-eventChan:
+			event.Key = s.path(event.Key)
+			select {
+			case responseChan <- event:
+			case <-stopChan:
+				return stop()
+			}
+		case err := <-done:
+			return err
+		case <-stopChan:
+			return stop()
+		}
+	}
+}
+
+//Close closes the backend
+func (s *Sync) Close() {
+	s.backend.Close()
+}
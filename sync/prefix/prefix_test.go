@@ -0,0 +1,139 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prefix
+
+import (
+	"testing"
+	"time"
+
+	gohan_sync "github.com/cloudwan/gohan/sync"
+	"github.com/cloudwan/gohan/sync/memory"
+)
+
+func newSyncs(t *testing.T) (*memory.Sync, *Sync, *Sync) {
+	backend, err := memory.NewSync("")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return backend, NewSync(backend, "/cluster1/"), NewSync(backend, "cluster2")
+}
+
+func TestFetchAndUpdate(t *testing.T) {
+	backend, cluster1, cluster2 := newSyncs(t)
+	defer backend.Close()
+
+	if err := cluster1.Update("/config/networks/a", "one"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := cluster2.Update("/config/networks/a", "two"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	node, err := backend.Fetch("/cluster1/config/networks/a")
+	if err != nil || node.Value != "one" {
+		t.Errorf("unexpected backend node: %+v, %v", node, err)
+	}
+	node, err = cluster2.Fetch("/config")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if node.Key != "/config" || len(node.Children) != 1 {
+		t.Fatalf("unexpected node: %+v", node)
+	}
+	child := node.Children[0]
+	if len(child.Children) != 1 || child.Children[0].Key != "/config/networks/a" || child.Children[0].Value != "two" {
+		t.Errorf("unexpected child: %+v", child)
+	}
+
+	if err := cluster1.Delete("/config/networks/a"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := cluster1.Fetch("/config/networks/a"); err == nil {
+		t.Errorf("expected key to be deleted")
+	}
+	if _, err := cluster2.Fetch("/config/networks/a"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	backend, cluster1, cluster2 := newSyncs(t)
+	defer backend.Close()
+
+	if err := cluster1.Lock("/gohan/cluster/sync", false); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cluster1.HasLock("/gohan/cluster/sync") || !backend.HasLock("/cluster1/gohan/cluster/sync") {
+		t.Errorf("expected lock to be held under prefix")
+	}
+	if err := cluster2.Lock("/gohan/cluster/sync", false); err != nil {
+		t.Errorf("locks of different prefixes should not conflict: %s", err)
+	}
+	if err := cluster1.Unlock("/gohan/cluster/sync"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
+
+func TestTxn(t *testing.T) {
+	backend, cluster1, _ := newSyncs(t)
+	defer backend.Close()
+
+	ok, err := cluster1.Txn(
+		[]*gohan_sync.Condition{{Key: "/a", Target: gohan_sync.TargetRevision, Operator: "=", Revision: 0}},
+		[]*gohan_sync.Op{{Action: gohan_sync.OpUpdate, Key: "/a", Value: "value"}},
+	)
+	if err != nil || !ok {
+		t.Fatalf("unexpected txn result: %v, %v", ok, err)
+	}
+	if node, err := backend.Fetch("/cluster1/a"); err != nil || node.Value != "value" {
+		t.Errorf("unexpected backend node: %+v, %v", node, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	backend, cluster1, cluster2 := newSyncs(t)
+	defer backend.Close()
+
+	responseChan := make(chan *gohan_sync.Event)
+	stopChan := make(chan bool)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- cluster1.Watch("/state_watch", responseChan, stopChan, gohan_sync.RevisionCurrent)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cluster2.Update("/state_watch/a", `{"state": "other"}`)
+	cluster1.Update("/state_watch/a", `{"state": "mine"}`)
+
+	select {
+	case event := <-responseChan:
+		if event.Key != "/state_watch/a" || event.Data["state"] != "mine" {
+			t.Errorf("unexpected event: %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event received")
+	}
+
+	close(stopChan)
+	select {
+	case err := <-errChan:
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("watch didn't stop")
+	}
+}
@@ -26,6 +26,8 @@ type Sync interface {
 	Update(path, json string) error
 	Delete(path string) error
 	Watch(path string, responseChan chan *Event, stopChan chan bool, revision int64) error
+	//Txn applies ops atomically when all conditions hold and reports if they did
+	Txn(conditions []*Condition, ops []*Op) (bool, error)
 	Close()
 }
 
@@ -0,0 +1,200 @@
+// Copyright (C) 2017 NTT Innovation Institute, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//    http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sync
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+//Targets of transaction conditions
+const (
+	TargetRevision = "revision"
+	TargetValue    = "value"
+)
+
+//Actions of transaction operations
+const (
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+//Condition compares the revision or the value of a key in a transaction
+//The revision of a missing key is 0, comparing the value of a missing key fails.
+type Condition struct {
+	Key      string
+	Target   string
+	Operator string
+	Revision int64
+	Value    string
+}
+
+//Op is an operation applied by a transaction
+type Op struct {
+	Action string
+	Key    string
+	Value  string
+}
+
+//Check compares the current revision or value of the key, node is nil for a missing key
+//It is used by backends which emulate transactions.
+func (condition *Condition) Check(node *Node) bool {
+	var compared int
+	switch condition.Target {
+	case TargetRevision:
+		var revision int64
+		if node != nil {
+			revision = node.Revision
+		}
+		compared = compareInt(revision, condition.Revision)
+	default:
+		if node == nil {
+			return false
+		}
+		compared = compareString(node.Value, condition.Value)
+	}
+	switch condition.Operator {
+	case "!=":
+		return compared != 0
+	case "<":
+		return compared < 0
+	case ">":
+		return compared > 0
+	}
+	return compared == 0
+}
+
+func compareInt(a, b int64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+func compareString(a, b string) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+//ParseTxn parses conditions and operations passed by extensions
+//A condition is {"key": key, "revision": revision} or {"key": key, "value": value}
+//with an optional "operator" of "=", "!=", "<" or ">".
+//An operation is {"action": "update", "key": key, "value": value} or {"action": "delete", "key": key}.
+//Values which aren't strings are encoded as JSON.
+func ParseTxn(rawConditions, rawOperations []interface{}) ([]*Condition, []*Op, error) {
+	conditions := []*Condition{}
+	for _, raw := range rawConditions {
+		rawCondition, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("condition should be an object: %v", raw)
+		}
+		condition := &Condition{Operator: "="}
+		condition.Key, _ = rawCondition["key"].(string)
+		if condition.Key == "" {
+			return nil, nil, fmt.Errorf("condition has no key: %v", raw)
+		}
+		if operator, ok := rawCondition["operator"]; ok {
+			condition.Operator, _ = operator.(string)
+			switch condition.Operator {
+			case "=", "!=", "<", ">":
+			default:
+				return nil, nil, fmt.Errorf("unknown operator %v of condition on %s", operator, condition.Key)
+			}
+		}
+		rawRevision, hasRevision := rawCondition["revision"]
+		rawValue, hasValue := rawCondition["value"]
+		switch {
+		case hasRevision && hasValue:
+			return nil, nil, fmt.Errorf("condition on %s should compare either revision or value", condition.Key)
+		case hasRevision:
+			condition.Target = TargetRevision
+			revision, ok := toInt64(rawRevision)
+			if !ok {
+				return nil, nil, fmt.Errorf("revision of condition on %s should be a number", condition.Key)
+			}
+			condition.Revision = revision
+		case hasValue:
+			condition.Target = TargetValue
+			value, err := toValue(rawValue)
+			if err != nil {
+				return nil, nil, err
+			}
+			condition.Value = value
+		default:
+			return nil, nil, fmt.Errorf("condition on %s should compare either revision or value", condition.Key)
+		}
+		conditions = append(conditions, condition)
+	}
+
+	ops := []*Op{}
+	for _, raw := range rawOperations {
+		rawOp, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, nil, fmt.Errorf("operation should be an object: %v", raw)
+		}
+		op := &Op{}
+		op.Action, _ = rawOp["action"].(string)
+		op.Key, _ = rawOp["key"].(string)
+		if op.Key == "" {
+			return nil, nil, fmt.Errorf("operation has no key: %v", raw)
+		}
+		switch op.Action {
+		case OpUpdate:
+			value, err := toValue(rawOp["value"])
+			if err != nil {
+				return nil, nil, err
+			}
+			op.Value = value
+		case OpDelete:
+		default:
+			return nil, nil, fmt.Errorf("unknown action %v of operation on %s", rawOp["action"], op.Key)
+		}
+		ops = append(ops, op)
+	}
+	return conditions, ops, nil
+}
+
+func toInt64(raw interface{}) (int64, bool) {
+	switch value := raw.(type) {
+	case int:
+		return int64(value), true
+	case int64:
+		return value, true
+	case float64:
+		return int64(value), true
+	}
+	return 0, false
+}
+
+func toValue(raw interface{}) (string, error) {
+	switch value := raw.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return value, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}